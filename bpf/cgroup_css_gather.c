@@ -6,7 +6,9 @@
 
 #include "bpf_common.h"
 
-#define CGROUP_KNODE_NAME_MAXLEN 64
+/* the container id, wrapped as <prefix>-<id>.scope by systemd cgroup driver */
+#define CGROUP_CONTAINER_ID_LEN	 64
+#define CGROUP_KNODE_NAME_MAXLEN 128
 
 struct cgroup_perf_event_t {
 	u64 cgroup;
@@ -14,7 +16,7 @@ struct cgroup_perf_event_t {
 	s32 cgroup_root;
 	s32 cgroup_level;
 	u64 css[CGROUP_SUBSYS_COUNT];
-	char knode_name[CGROUP_KNODE_NAME_MAXLEN + 2];
+	char knode_name[CGROUP_KNODE_NAME_MAXLEN];
 };
 
 struct {
@@ -37,7 +39,7 @@ int bpf_cgroup_subsys_state_prog(struct pt_regs *ctx)
 	knode_len =
 	    bpf_probe_read_str(&data.knode_name, sizeof(data.knode_name),
 			       BPF_CORE_READ(cgrp, kn, name));
-	if (knode_len != CGROUP_KNODE_NAME_MAXLEN + 1)
+	if (knode_len < CGROUP_CONTAINER_ID_LEN + 1)
 		return 0;
 
 	data.cgroup	  = (u64)cgrp;
@@ -16,8 +16,8 @@
 
 struct perf_event_t {
 	u64 tgid_pid;
-	u32 saddr;
-	u32 daddr;
+	u8 saddr[16]; // IPv4 address in the first 4 bytes
+	u8 daddr[16];
 	u16 sport;
 	u16 dport;
 	u32 seq;
@@ -29,6 +29,7 @@ struct perf_event_t {
 	u32 sk_max_ack_backlog;
 	u8 state;
 	u8 type;
+	u8 family;
 	char comm[COMPAT_TASK_COMM_LEN];
 };
 
@@ -112,20 +113,28 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	struct sk_buff *skb	  = ctx->skbaddr;
 	struct perf_event_t *data = NULL;
 	struct sock_common *sk_common;
+	struct ipv6hdr ip6hdr;
 	struct tcphdr tcphdr;
 	struct iphdr iphdr;
 	struct sock *sk;
 	u16 protocol = 0;
+	u16 family   = 0;
 	u16 type     = 0;
 	u8 state     = 0;
 
-	/* only for IP && TCP */
-	if (ctx->protocol != ETH_P_IP)
-		return 0;
-
-	bpf_probe_read(&iphdr, sizeof(iphdr), skb_network_header(skb));
-	if (iphdr.protocol != IPPROTO_TCP)
+	/* only for IPv4/IPv6 && TCP */
+	if (ctx->protocol == ETH_P_IP) {
+		bpf_probe_read(&iphdr, sizeof(iphdr), skb_network_header(skb));
+		if (iphdr.protocol != IPPROTO_TCP)
+			return 0;
+	} else if (ctx->protocol == ETH_P_IPV6) {
+		bpf_probe_read(&ip6hdr, sizeof(ip6hdr),
+			       skb_network_header(skb));
+		if (ip6hdr.nexthdr != IPPROTO_TCP)
+			return 0;
+	} else {
 		return 0;
+	}
 
 	sk = BPF_CORE_READ(skb, sk);
 	if (!sk)
@@ -133,8 +142,9 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 
 	sk_common = (struct sock_common *)sk;
 
-	// filter the sock by AF_INET, SOCK_STREAM, IPPROTO_TCP
-	if (BPF_CORE_READ(sk_common, skc_family) != AF_INET)
+	// filter the sock by AF_INET/AF_INET6, SOCK_STREAM, IPPROTO_TCP
+	family = BPF_CORE_READ(sk_common, skc_family);
+	if (family != AF_INET && family != AF_INET6)
 		return 0;
 
 	sk_get_type_and_protocol(sk, &protocol, &type);
@@ -160,8 +170,6 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	bpf_get_current_comm(&data->comm, sizeof(data->comm));
 	data->type	    = TYPE_TCP_COMMON_DROP;
 	data->state	    = state;
-	data->saddr	    = iphdr.saddr;
-	data->daddr	    = iphdr.daddr;
 	data->sport	    = tcphdr.source;
 	data->dport	    = tcphdr.dest;
 	data->seq	    = tcphdr.seq;
@@ -172,6 +180,19 @@ int bpf_kfree_skb_prog(struct trace_event_raw_kfree_skb *ctx)
 	    bpf_get_stack(ctx, data->stack, sizeof(data->stack), 0);
 	data->sk_max_ack_backlog = 0;
 
+	// the IPv4 packet may be received by the dual-stack IPv6 socket
+	if (ctx->protocol == ETH_P_IP) {
+		data->family = AF_INET;
+		__builtin_memcpy(data->saddr, &iphdr.saddr, sizeof(iphdr.saddr));
+		__builtin_memcpy(data->daddr, &iphdr.daddr, sizeof(iphdr.daddr));
+	} else {
+		data->family = AF_INET6;
+		__builtin_memcpy(data->saddr, &ip6hdr.saddr,
+				 sizeof(ip6hdr.saddr));
+		__builtin_memcpy(data->daddr, &ip6hdr.daddr,
+				 sizeof(ip6hdr.daddr));
+	}
+
 	bpf_perf_event_output(ctx, &perf_events, COMPAT_BPF_F_CURRENT_CPU, data,
 			      sizeof(*data));
 
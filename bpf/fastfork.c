@@ -0,0 +1,245 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+#define FASTFORK_MAX_TASKS	 10240
+#define FASTFORK_MAX_WATCH_TGIDS 1024
+
+enum fastfork_probe {
+	FASTFORK_PROBE_FORK = 0, // fork latency
+	FASTFORK_PROBE_PTSEP,	 // copy page table latency
+	FASTFORK_PROBE_WAITPTSEP, // copy-on-write fault latency after fork
+	FASTFORK_PROBE_MAX,
+};
+
+enum lat_zone {
+	LAT_ZONE0 = 0, // 0 ~ 100us
+	LAT_ZONE1,     // 100us ~ 1ms
+	LAT_ZONE2,     // 1ms ~ 10ms
+	LAT_ZONE3,     // 10ms ~ 100ms
+	LAT_ZONE4,     // 100ms ~ inf
+	LAT_ZONE_MAX,
+};
+
+volatile const u64 slow_fork_thresh = 100 * NSEC_PER_MSEC;
+
+struct fork_entry {
+	u64 start_ns;
+	u64 ptsep_ns;
+	u64 nr_ptsep;
+};
+
+struct fastfork_event {
+	u64 latency;
+	u64 ptsep_latency;
+	u64 nr_ptsep;
+	u64 cpu_css;
+	u64 total_vm;
+	s64 child_pid;
+	u32 pid;
+	u32 tgid;
+	u32 watched;
+	u32 pad;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, struct fork_entry);
+	__uint(max_entries, FASTFORK_MAX_TASKS);
+} fork_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, FASTFORK_MAX_TASKS);
+} ptsep_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, FASTFORK_MAX_TASKS);
+} waitptsep_start SEC(".maps");
+
+// the redis-like processes, written by userspace
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, u32);
+	__uint(max_entries, FASTFORK_MAX_WATCH_TGIDS);
+} fastfork_watch_tgids SEC(".maps");
+
+// key: probe * LAT_ZONE_MAX + zone
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, FASTFORK_PROBE_MAX * LAT_ZONE_MAX);
+} fastfork_latency SEC(".maps");
+
+// key: probe, the sum of the latency in ns
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, FASTFORK_PROBE_MAX);
+} fastfork_latency_sum SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+	__uint(key_size, sizeof(int));
+	__uint(value_size, sizeof(u32));
+} fastfork_events SEC(".maps");
+
+static __always_inline void latency_account(u32 probe, u64 latency)
+{
+	u32 key = probe * LAT_ZONE_MAX;
+	u64 *count, *sum;
+
+	if (latency < 100 * NSEC_PER_USEC)
+		key += LAT_ZONE0;
+	else if (latency < 1 * NSEC_PER_MSEC)
+		key += LAT_ZONE1;
+	else if (latency < 10 * NSEC_PER_MSEC)
+		key += LAT_ZONE2;
+	else if (latency < 100 * NSEC_PER_MSEC)
+		key += LAT_ZONE3;
+	else
+		key += LAT_ZONE4;
+
+	count = bpf_map_lookup_elem(&fastfork_latency, &key);
+	if (count)
+		__sync_fetch_and_add(count, 1);
+
+	sum = bpf_map_lookup_elem(&fastfork_latency_sum, &probe);
+	if (sum)
+		__sync_fetch_and_add(sum, latency);
+}
+
+SEC("kprobe/kernel_clone")
+int kprobe_fork(struct pt_regs *ctx)
+{
+	struct fork_entry entry = {};
+	u32 pid			= (u32)bpf_get_current_pid_tgid();
+
+	entry.start_ns = bpf_ktime_get_ns();
+	bpf_map_update_elem(&fork_start, &pid, &entry, COMPAT_BPF_ANY);
+	return 0;
+}
+
+SEC("kretprobe/kernel_clone")
+int kretprobe_fork(struct pt_regs *ctx)
+{
+	struct fastfork_event event = {};
+	struct fork_entry *entry;
+	struct task_struct *task;
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	u32 pid	     = (u32)pid_tgid;
+	u32 tgid     = pid_tgid >> 32;
+	u64 latency;
+
+	entry = bpf_map_lookup_elem(&fork_start, &pid);
+	if (!entry)
+		return 0;
+
+	latency = bpf_ktime_get_ns() - entry->start_ns;
+	latency_account(FASTFORK_PROBE_FORK, latency);
+
+	event.watched = bpf_map_lookup_elem(&fastfork_watch_tgids, &tgid) != NULL;
+	if (latency < slow_fork_thresh && !event.watched) {
+		bpf_map_delete_elem(&fork_start, &pid);
+		return 0;
+	}
+
+	task		    = (struct task_struct *)bpf_get_current_task();
+	event.latency	    = latency;
+	event.ptsep_latency = entry->ptsep_ns;
+	event.nr_ptsep	    = entry->nr_ptsep;
+	event.child_pid	    = (s64)PT_REGS_RC(ctx);
+	event.pid	    = pid;
+	event.tgid	    = tgid;
+	event.total_vm	    = BPF_CORE_READ(task, mm, total_vm);
+	event.cpu_css = (u64)BPF_CORE_READ(task, cgroups, subsys[cpu_cgrp_id]);
+	bpf_get_current_comm(&event.comm, sizeof(event.comm));
+	bpf_map_delete_elem(&fork_start, &pid);
+
+	bpf_perf_event_output(ctx, &fastfork_events, COMPAT_BPF_F_CURRENT_CPU,
+			      &event, sizeof(event));
+	return 0;
+}
+
+SEC("kprobe/copy_page_range")
+int kprobe_copy_page_range(struct pt_regs *ctx)
+{
+	u32 pid = (u32)bpf_get_current_pid_tgid();
+	u64 now = bpf_ktime_get_ns();
+
+	bpf_map_update_elem(&ptsep_start, &pid, &now, COMPAT_BPF_ANY);
+	return 0;
+}
+
+SEC("kretprobe/copy_page_range")
+int kretprobe_copy_page_range(struct pt_regs *ctx)
+{
+	u32 pid = (u32)bpf_get_current_pid_tgid();
+	struct fork_entry *entry;
+	u64 *start, latency;
+
+	start = bpf_map_lookup_elem(&ptsep_start, &pid);
+	if (!start)
+		return 0;
+
+	latency = bpf_ktime_get_ns() - *start;
+	bpf_map_delete_elem(&ptsep_start, &pid);
+	latency_account(FASTFORK_PROBE_PTSEP, latency);
+
+	// the page tables are copied vma by vma in one fork
+	entry = bpf_map_lookup_elem(&fork_start, &pid);
+	if (entry) {
+		entry->ptsep_ns += latency;
+		entry->nr_ptsep++;
+	}
+
+	return 0;
+}
+
+SEC("kprobe/do_wp_page")
+int kprobe_do_wp_page(struct pt_regs *ctx)
+{
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	u32 tgid     = pid_tgid >> 32;
+	u32 pid	     = (u32)pid_tgid;
+	u64 now;
+
+	// too many cow faults in the system, only care the watched processes
+	if (!bpf_map_lookup_elem(&fastfork_watch_tgids, &tgid))
+		return 0;
+
+	now = bpf_ktime_get_ns();
+	bpf_map_update_elem(&waitptsep_start, &pid, &now, COMPAT_BPF_ANY);
+	return 0;
+}
+
+SEC("kretprobe/do_wp_page")
+int kretprobe_do_wp_page(struct pt_regs *ctx)
+{
+	u32 pid = (u32)bpf_get_current_pid_tgid();
+	u64 *start;
+
+	start = bpf_map_lookup_elem(&waitptsep_start, &pid);
+	if (!start)
+		return 0;
+
+	latency_account(FASTFORK_PROBE_WAITPTSEP, bpf_ktime_get_ns() - *start);
+	bpf_map_delete_elem(&waitptsep_start, &pid);
+	return 0;
+}
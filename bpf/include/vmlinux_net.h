@@ -7,7 +7,9 @@
 #define IFNAMSIZ        16
 
 #define ETH_P_IP        0x0800          /* Internet Protocol packet     */
+#define ETH_P_IPV6      0x86DD          /* IPv6 over bluebook           */
 #define AF_INET         2       /* Internet IP Protocol         */
+#define AF_INET6        10      /* IP version 6                 */
 
 #define IP_MF           0x2000          /* Flag: "More Fragments"       */
 #define IP_OFFSET       0x1FFF          /* "Fragment Offset" part       */
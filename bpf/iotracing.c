@@ -0,0 +1,188 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+#define S_IFMT	00170000
+#define S_IFREG 0100000
+
+#define IOTRACING_FILE_NAME_LEN 64
+#define IOTRACING_MAX_FILES	10240
+#define IOTRACING_MAX_TASKS	10240
+#define IOTRACING_MAX_STACKS	1024
+
+volatile const u64 io_schedule_thresh = 100 * NSEC_PER_MSEC;
+
+struct file_key {
+	u32 tgid;
+	u32 dev;
+	u64 ino;
+};
+
+struct file_stat {
+	u64 read_bytes;
+	u64 write_bytes;
+	u64 read_count;
+	u64 write_count;
+	u64 cpu_css;
+	char comm[COMPAT_TASK_COMM_LEN];
+	char name[IOTRACING_FILE_NAME_LEN];
+};
+
+struct io_schedule_entry {
+	u64 start_ns;
+	s64 stack_id;
+};
+
+struct io_schedule_event {
+	u64 latency;
+	u64 cpu_css;
+	s64 stack_id;
+	u32 pid;
+	u32 tgid;
+	char comm[COMPAT_TASK_COMM_LEN];
+};
+
+// file io accounting, key: tgid + device + inode
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, struct file_key);
+	__type(value, struct file_stat);
+	__uint(max_entries, IOTRACING_MAX_FILES);
+} iotracing_file_stats SEC(".maps");
+
+// the timestamp and stack of the task which entered io_schedule
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);
+	__type(value, struct io_schedule_entry);
+	__uint(max_entries, IOTRACING_MAX_TASKS);
+} io_schedule_start SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_STACK_TRACE);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, PERF_MAX_STACK_DEPTH * sizeof(u64));
+	__uint(max_entries, IOTRACING_MAX_STACKS);
+} iotracing_stacks SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+	__uint(key_size, sizeof(int));
+	__uint(value_size, sizeof(u32));
+} iotracing_events SEC(".maps");
+
+static __always_inline void account_file_io(struct file *file, u64 count,
+					    bool is_write)
+{
+	struct file_stat *stat, new_stat = {};
+	struct file_key key		 = {};
+	struct task_struct *task;
+	struct inode *inode;
+	umode_t mode;
+
+	inode = BPF_CORE_READ(file, f_inode);
+	if (!inode)
+		return;
+
+	mode = BPF_CORE_READ(inode, i_mode);
+	if ((mode & S_IFMT) != S_IFREG)
+		return;
+
+	key.tgid = bpf_get_current_pid_tgid() >> 32;
+	key.dev	 = BPF_CORE_READ(inode, i_sb, s_dev);
+	key.ino	 = BPF_CORE_READ(inode, i_ino);
+
+	stat = bpf_map_lookup_elem(&iotracing_file_stats, &key);
+	if (!stat) {
+		task = (struct task_struct *)bpf_get_current_task();
+		new_stat.cpu_css =
+		    (u64)BPF_CORE_READ(task, cgroups, subsys[cpu_cgrp_id]);
+		bpf_get_current_comm(&new_stat.comm, sizeof(new_stat.comm));
+		bpf_probe_read_kernel_str(
+		    &new_stat.name, sizeof(new_stat.name),
+		    BPF_CORE_READ(file, f_path.dentry, d_name.name));
+
+		bpf_map_update_elem(&iotracing_file_stats, &key, &new_stat,
+				    COMPAT_BPF_NOEXIST);
+		stat = bpf_map_lookup_elem(&iotracing_file_stats, &key);
+		if (!stat)
+			return;
+	}
+
+	if (is_write) {
+		__sync_fetch_and_add(&stat->write_bytes, count);
+		__sync_fetch_and_add(&stat->write_count, 1);
+	} else {
+		__sync_fetch_and_add(&stat->read_bytes, count);
+		__sync_fetch_and_add(&stat->read_count, 1);
+	}
+}
+
+SEC("kprobe/vfs_read")
+int kprobe_vfs_read(struct pt_regs *ctx)
+{
+	account_file_io((struct file *)PT_REGS_PARM1(ctx),
+			(u64)PT_REGS_PARM3(ctx), false);
+	return 0;
+}
+
+SEC("kprobe/vfs_write")
+int kprobe_vfs_write(struct pt_regs *ctx)
+{
+	account_file_io((struct file *)PT_REGS_PARM1(ctx),
+			(u64)PT_REGS_PARM3(ctx), true);
+	return 0;
+}
+
+SEC("kprobe/io_schedule")
+int kprobe_io_schedule(struct pt_regs *ctx)
+{
+	struct io_schedule_entry entry = {};
+	u32 pid			       = (u32)bpf_get_current_pid_tgid();
+
+	entry.start_ns = bpf_ktime_get_ns();
+	entry.stack_id = bpf_get_stackid(ctx, &iotracing_stacks, 0);
+
+	bpf_map_update_elem(&io_schedule_start, &pid, &entry, COMPAT_BPF_ANY);
+	return 0;
+}
+
+SEC("kretprobe/io_schedule")
+int kretprobe_io_schedule(struct pt_regs *ctx)
+{
+	struct io_schedule_event event = {};
+	struct io_schedule_entry *entry;
+	struct task_struct *task;
+	u64 pid_tgid = bpf_get_current_pid_tgid();
+	u32 pid	     = (u32)pid_tgid;
+	u64 latency;
+
+	entry = bpf_map_lookup_elem(&io_schedule_start, &pid);
+	if (!entry)
+		return 0;
+
+	latency = bpf_ktime_get_ns() - entry->start_ns;
+	if (latency < io_schedule_thresh || entry->stack_id < 0) {
+		bpf_map_delete_elem(&io_schedule_start, &pid);
+		return 0;
+	}
+
+	task	       = (struct task_struct *)bpf_get_current_task();
+	event.latency  = latency;
+	event.stack_id = entry->stack_id;
+	event.pid      = pid;
+	event.tgid     = pid_tgid >> 32;
+	event.cpu_css  = (u64)BPF_CORE_READ(task, cgroups, subsys[cpu_cgrp_id]);
+	bpf_get_current_comm(&event.comm, sizeof(event.comm));
+	bpf_map_delete_elem(&io_schedule_start, &pid);
+
+	bpf_perf_event_output(ctx, &iotracing_events, COMPAT_BPF_F_CURRENT_CPU,
+			      &event, sizeof(event));
+	return 0;
+}
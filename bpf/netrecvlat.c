@@ -14,6 +14,7 @@
 volatile const long long mono_wall_offset = 0;
 volatile const long long to_netif	  = 5 * 1000 * 1000;   // 5ms
 volatile const long long to_tcpv4	  = 10 * 1000 * 1000;  // 10ms
+volatile const long long to_tcpv6	  = 10 * 1000 * 1000;  // 10ms
 volatile const long long to_user_copy	  = 115 * 1000 * 1000; // 115ms
 
 #define likely(x) __builtin_expect(!!(x), 1)
@@ -28,18 +29,37 @@ struct perf_event_t {
 	u64 pkt_len;
 	u16 sport;
 	u16 dport;
-	u32 saddr;
-	u32 daddr;
 	u32 seq;
 	u32 ack_seq;
+	u8 saddr[16]; // IPv4 address in the first 4 bytes
+	u8 daddr[16];
 	u8 state;
 	u8 where;
+	u8 family;
 };
 
 enum skb_rcv_where {
 	TO_NETIF_RCV,
 	TO_TCPV4_RCV,
 	TO_USER_COPY,
+	TO_TCPV6_RCV,
+	TO_WHERE_MAX,
+};
+
+enum lat_zone {
+	LAT_ZONE0 = 0, // 0 ~ 1ms
+	LAT_ZONE1,     // 1ms ~ 5ms
+	LAT_ZONE2,     // 5ms ~ 10ms
+	LAT_ZONE3,     // 10ms ~ 50ms
+	LAT_ZONE4,     // 50ms ~ 100ms
+	LAT_ZONE5,     // 100ms ~ 500ms
+	LAT_ZONE6,     // 500ms ~ inf
+	LAT_ZONE_MAX,
+};
+
+struct net_recv_lat_hist {
+	u64 sum; // in ns
+	u64 count[LAT_ZONE_MAX];
 };
 
 struct {
@@ -48,8 +68,18 @@ struct {
 	__uint(value_size, sizeof(u32));
 } net_recv_lat_event_map SEC(".maps");
 
+// latency histogram of all the skbs, key: enum skb_rcv_where
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, u32);
+	__type(value, struct net_recv_lat_hist);
+	__uint(max_entries, TO_WHERE_MAX);
+} net_recv_lat_hist_map SEC(".maps");
+
 struct mix {
-	struct iphdr *ip_hdr;
+	u8 saddr[16];
+	u8 daddr[16];
+	u8 family;
 	u64 lat;
 	u8 state;
 	u8 where;
@@ -67,6 +97,76 @@ static inline u64 delta_now_skb_tstamp(struct sk_buff *skb)
 	return bpf_ktime_get_ns() + mono_wall_offset - tstamp;
 }
 
+static inline void latency_account(u32 where, u64 delta)
+{
+	struct net_recv_lat_hist *hist;
+	u32 zone;
+
+	// no tstamp, or the mono_wall_offset is not accurate enough
+	if (!delta || (s64)delta < 0)
+		return;
+
+	hist = bpf_map_lookup_elem(&net_recv_lat_hist_map, &where);
+	if (!hist)
+		return;
+
+	if (delta < 1 * NSEC_PER_MSEC)
+		zone = LAT_ZONE0;
+	else if (delta < 5 * NSEC_PER_MSEC)
+		zone = LAT_ZONE1;
+	else if (delta < 10 * NSEC_PER_MSEC)
+		zone = LAT_ZONE2;
+	else if (delta < 50 * NSEC_PER_MSEC)
+		zone = LAT_ZONE3;
+	else if (delta < 100 * NSEC_PER_MSEC)
+		zone = LAT_ZONE4;
+	else if (delta < 500 * NSEC_PER_MSEC)
+		zone = LAT_ZONE5;
+	else
+		zone = LAT_ZONE6;
+
+	hist->sum += delta;
+	hist->count[zone]++;
+}
+
+// parse the addresses of tcp skb, protocol is skb->protocol in host order.
+static inline bool parse_tcp_skb(struct sk_buff *skb, u16 protocol,
+				 struct mix *_mix)
+{
+	struct ipv6hdr ip6_hdr;
+	struct iphdr ip_hdr;
+
+	if (protocol == ETH_P_IP) {
+		bpf_probe_read(&ip_hdr, sizeof(ip_hdr),
+			       skb_network_header(skb));
+		if (ip_hdr.protocol != IPPROTO_TCP)
+			return false;
+
+		__builtin_memcpy(_mix->saddr, &ip_hdr.saddr,
+				 sizeof(ip_hdr.saddr));
+		__builtin_memcpy(_mix->daddr, &ip_hdr.daddr,
+				 sizeof(ip_hdr.daddr));
+		_mix->family = AF_INET;
+		return true;
+	}
+
+	if (protocol == ETH_P_IPV6) {
+		bpf_probe_read(&ip6_hdr, sizeof(ip6_hdr),
+			       skb_network_header(skb));
+		if (ip6_hdr.nexthdr != IPPROTO_TCP)
+			return false;
+
+		__builtin_memcpy(_mix->saddr, &ip6_hdr.saddr,
+				 sizeof(ip6_hdr.saddr));
+		__builtin_memcpy(_mix->daddr, &ip6_hdr.daddr,
+				 sizeof(ip6_hdr.daddr));
+		_mix->family = AF_INET6;
+		return true;
+	}
+
+	return false;
+}
+
 static inline u8 get_state(struct sk_buff *skb)
 {
 	return BPF_CORE_READ(skb, sk, __sk_common.skc_state);
@@ -89,8 +189,7 @@ fill_and_output_event(void *ctx, struct sk_buff *skb, struct mix *_mix)
 
 	bpf_probe_read(&tcp_hdr, sizeof(tcp_hdr), skb_transport_header(skb));
 	event.latency = _mix->lat;
-	event.saddr   = _mix->ip_hdr->saddr;
-	event.daddr   = _mix->ip_hdr->daddr;
+	event.family  = _mix->family;
 	event.sport   = tcp_hdr.source;
 	event.dport   = tcp_hdr.dest;
 	event.seq     = tcp_hdr.seq;
@@ -98,6 +197,8 @@ fill_and_output_event(void *ctx, struct sk_buff *skb, struct mix *_mix)
 	event.pkt_len = BPF_CORE_READ(skb, len);
 	event.state   = _mix->state;
 	event.where   = _mix->where;
+	__builtin_memcpy(event.saddr, _mix->saddr, sizeof(event.saddr));
+	__builtin_memcpy(event.daddr, _mix->daddr, sizeof(event.daddr));
 
 	bpf_perf_event_output(ctx, &net_recv_lat_event_map,
 			      COMPAT_BPF_F_CURRENT_CPU, &event,
@@ -108,68 +209,74 @@ SEC("tracepoint/net/netif_receive_skb")
 int netif_receive_skb_prog(struct trace_event_raw_net_dev_template *args)
 {
 	struct sk_buff *skb = (struct sk_buff *)args->skbaddr;
-	struct iphdr ip_hdr;
-	u64 delta;
-
-	if (unlikely(BPF_CORE_READ(skb, protocol) !=
-		     bpf_ntohs(ETH_P_IP))) // IPv4
-		return 0;
+	struct mix _mix	    = {.where = TO_NETIF_RCV};
 
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	if (ip_hdr.protocol != IPPROTO_TCP)
+	// IPv4 and IPv6
+	if (!parse_tcp_skb(skb, bpf_ntohs(BPF_CORE_READ(skb, protocol)),
+			   &_mix))
 		return 0;
 
-	delta = delta_now_skb_tstamp(skb);
-	if (delta < to_netif)
+	_mix.lat = delta_now_skb_tstamp(skb);
+	latency_account(TO_NETIF_RCV, _mix.lat);
+	if (_mix.lat < to_netif)
 		return 0;
 
-	fill_and_output_event(args, skb,
-			      &(struct mix){&ip_hdr, delta, 0, TO_NETIF_RCV});
+	fill_and_output_event(args, skb, &_mix);
 
 	return 0;
 }
 
-SEC("kprobe/tcp_v4_rcv")
-int tcp_v4_rcv_prog(struct pt_regs *ctx)
+static inline int tcp_rcv_prog(struct pt_regs *ctx, u16 protocol, u8 where,
+				long long thresh)
 {
 	struct sk_buff *skb = (struct sk_buff *)PT_REGS_PARM1_CORE(ctx);
-	struct iphdr ip_hdr;
-	u64 delta;
+	struct mix _mix	    = {.where = where};
 
-	delta = delta_now_skb_tstamp(skb);
-	if (delta < to_tcpv4)
+	_mix.lat = delta_now_skb_tstamp(skb);
+	latency_account(where, _mix.lat);
+	if (_mix.lat < thresh)
 		return 0;
 
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	fill_and_output_event(
-	    ctx, skb,
-	    &(struct mix){&ip_hdr, delta, get_state(skb), TO_TCPV4_RCV});
+	if (!parse_tcp_skb(skb, protocol, &_mix))
+		return 0;
 
+	_mix.state = get_state(skb);
+	fill_and_output_event(ctx, skb, &_mix);
 	return 0;
 }
 
+SEC("kprobe/tcp_v4_rcv")
+int tcp_v4_rcv_prog(struct pt_regs *ctx)
+{
+	return tcp_rcv_prog(ctx, ETH_P_IP, TO_TCPV4_RCV, to_tcpv4);
+}
+
+// attached only if tcp_v6_rcv exists, ipv6 may be a module or disabled.
+SEC("kprobe/tcp_v6_rcv")
+int tcp_v6_rcv_prog(struct pt_regs *ctx)
+{
+	return tcp_rcv_prog(ctx, ETH_P_IPV6, TO_TCPV6_RCV, to_tcpv6);
+}
+
 SEC("tracepoint/skb/skb_copy_datagram_iovec")
 int skb_copy_datagram_iovec_prog(
     struct trace_event_raw_skb_copy_datagram_iovec *args)
 {
 	struct sk_buff *skb = (struct sk_buff *)args->skbaddr;
-	struct iphdr ip_hdr;
-	u64 delta;
-
-	if (unlikely(BPF_CORE_READ(skb, protocol) != bpf_ntohs(ETH_P_IP)))
-		return 0;
+	struct mix _mix	    = {.where = TO_USER_COPY};
 
-	bpf_probe_read(&ip_hdr, sizeof(ip_hdr), skb_network_header(skb));
-	if (ip_hdr.protocol != IPPROTO_TCP)
+	// IPv4 and IPv6
+	if (!parse_tcp_skb(skb, bpf_ntohs(BPF_CORE_READ(skb, protocol)),
+			   &_mix))
 		return 0;
 
-	delta = delta_now_skb_tstamp(skb);
-	if (delta < to_user_copy)
+	_mix.lat = delta_now_skb_tstamp(skb);
+	latency_account(TO_USER_COPY, _mix.lat);
+	if (_mix.lat < to_user_copy)
 		return 0;
 
-	fill_and_output_event(
-	    args, skb,
-	    &(struct mix){&ip_hdr, delta, get_state(skb), TO_USER_COPY});
+	_mix.state = get_state(skb);
+	fill_and_output_event(args, skb, &_mix);
 
 	return 0;
 }
@@ -11,25 +11,84 @@ char __license[] SEC("license") = "Dual MIT/GPL";
 
 volatile const u64 css = 0;
 volatile const u64 pid = 0;
+// capture the user and kernel stacks or not
+volatile const u32 user_stack	= 1;
+volatile const u32 kernel_stack = 1;
 
-#define PERF_STACK_DEPTH 20
+// the default stack depth, the value size of the stack_traces map is resized
+// by userspace with the configured depth, up to kernel.perf_event_max_stack.
+#define PERF_STACK_DEPTH 64
+// the distinct stacks of the host in one profiling interval
+#define PERF_MAX_STACKS 10240
+// the tasks switched out and not switched in yet
+#define PERF_MAX_OFFCPU_TASKS 10240
 
 struct key_t {
-	u64 ustack[PERF_STACK_DEPTH];
-	u64 kstack[PERF_STACK_DEPTH];
-	s64 ustack_size;
-	s64 kstack_size;
+	s32 ustack_id;
+	s32 kstack_id;
+	u64 css;
 	u32 pid;
 	char name[COMPAT_TASK_COMM_LEN];
+	u32 pad;
 };
 
 struct {
 	__uint(type, BPF_MAP_TYPE_HASH);
 	__uint(key_size, sizeof(struct key_t));
 	__uint(value_size, sizeof(u64));
-	__uint(max_entries, 1024);
+	__uint(max_entries, PERF_MAX_STACKS);
 } counts SEC(".maps");
 
+struct {
+	__uint(type, BPF_MAP_TYPE_STACK_TRACE);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, PERF_STACK_DEPTH * sizeof(u64));
+	__uint(max_entries, PERF_MAX_STACKS);
+} stack_traces SEC(".maps");
+
+struct offcpu_entry {
+	struct key_t key;
+	u64 start_ns;
+};
+
+// the stacks of the tasks switched out, keyed by the thread id
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u32);
+	__type(value, struct offcpu_entry);
+	__uint(max_entries, PERF_MAX_OFFCPU_TASKS);
+} offcpu_start SEC(".maps");
+
+// the stack id is negative if not captured, or failed, e.g. -EEXIST when
+// the stack_traces map is full of the other stacks.
+static __always_inline void stack_key_init(void *ctx, struct key_t *key)
+{
+	key->ustack_id = -1;
+	key->kstack_id = -1;
+
+	if (user_stack)
+		key->ustack_id = bpf_get_stackid(ctx, &stack_traces,
+						 COMPAT_BPF_F_USER_STACK);
+	if (kernel_stack)
+		key->kstack_id = bpf_get_stackid(ctx, &stack_traces, 0);
+
+	bpf_get_current_comm(&key->name, sizeof(key->name));
+}
+
+static __always_inline void counts_add(struct key_t *key, u64 val)
+{
+	u64 *valp = bpf_map_lookup_elem(&counts, key);
+
+	if (valp)
+		__sync_fetch_and_add(valp, val);
+	else if (bpf_map_update_elem(&counts, key, &val, COMPAT_BPF_NOEXIST)) {
+		// the same stack is inserted by another cpu
+		valp = bpf_map_lookup_elem(&counts, key);
+		if (valp)
+			__sync_fetch_and_add(valp, val);
+	}
+}
+
 SEC("perf_event/software/cpu_clock")
 int perf_event_sw_cpu_clock(struct pt_regs *ctx)
 {
@@ -42,20 +101,47 @@ int perf_event_sw_cpu_clock(struct pt_regs *ctx)
 	if (pid != 0 && pid != tgid)
 		return 0;
 
-	struct key_t key = {.pid = bpf_get_current_pid_tgid() >> 32};
-	bpf_get_current_comm(&key.name, sizeof(key.name));
+	struct key_t key = {.pid = tgid, .css = cpu_css};
+	stack_key_init(ctx, &key);
 
-	key.ustack_size = bpf_get_stack(ctx, key.ustack, sizeof(key.ustack),
-					COMPAT_BPF_F_USER_STACK);
-	key.kstack_size = bpf_get_stack(ctx, key.kstack, sizeof(key.kstack), 0);
+	counts_add(&key, 1);
+	return 0;
+}
 
-	u64 *valp = bpf_map_lookup_elem(&counts, &key);
-	if (!valp) {
-		u64 cnt = 1;
-		bpf_map_update_elem(&counts, &key, &cnt, COMPAT_BPF_ANY);
-		return 0;
+// the off-cpu time of the blocked and preempted tasks, the counts are
+// weighted by the microseconds between switched out and switched in.
+SEC("tracepoint/sched/sched_switch")
+int tracepoint_sched_switch(struct trace_event_raw_sched_switch *ctx)
+{
+	u32 next_pid = ctx->next_pid;
+	u32 prev_pid = ctx->prev_pid;
+	struct offcpu_entry *entry;
+	u64 now = bpf_ktime_get_ns();
+
+	// current is still the prev task in sched_switch
+	if (prev_pid != 0) {
+		struct task_struct *curr =
+			(struct task_struct *)bpf_get_current_task();
+		u64 cpu_css = (u64)BPF_CORE_READ(curr, cgroups, subsys[cpu_cgrp_id]);
+		u64 tgid    = bpf_get_current_pid_tgid() >> 32;
+
+		if ((css == 0 || css == cpu_css) && (pid == 0 || pid == tgid)) {
+			struct offcpu_entry new_entry = {
+				.key	  = {.pid = tgid, .css = cpu_css},
+				.start_ns = now,
+			};
+
+			stack_key_init(ctx, &new_entry.key);
+			bpf_map_update_elem(&offcpu_start, &prev_pid, &new_entry,
+					    COMPAT_BPF_ANY);
+		}
 	}
 
-	__sync_fetch_and_add(valp, 1);
+	entry = bpf_map_lookup_elem(&offcpu_start, &next_pid);
+	if (!entry)
+		return 0;
+
+	counts_add(&entry->key, (now - entry->start_ns) / NSEC_PER_USEC);
+	bpf_map_delete_elem(&offcpu_start, &next_pid);
 	return 0;
 }
@@ -32,6 +32,7 @@ struct stat_t {
 	    nlat_03; // task_group counts of sched latency range [20, 50)ms
 	unsigned long
 	    nlat_04; // task_group counts of sched latency range [50, inf)ms
+	unsigned long nlat_sum; // task_group total sched latency in ns
 };
 
 struct g_stat_t {
@@ -45,6 +46,7 @@ struct g_stat_t {
 	    g_nlat_03; // global counts of sched latency range [20, 50)ms
 	unsigned long
 	    g_nlat_04; // global counts of sched latency range [50, inf)ms
+	unsigned long g_nlat_sum; // global total sched latency in ns
 };
 
 struct {
@@ -175,12 +177,13 @@ int sched_switch_entry(struct bpf_raw_tracepoint_args *ctx)
 	if (!g_entry) {
 		// init global counts map
 		struct g_stat_t g_new_stat = {
-			.g_nvcsw   = 0,
-			.g_nivcsw  = 0,
-			.g_nlat_01 = 0,
-			.g_nlat_02 = 0,
-			.g_nlat_03 = 0,
-			.g_nlat_04 = 0,
+			.g_nvcsw    = 0,
+			.g_nivcsw   = 0,
+			.g_nlat_01  = 0,
+			.g_nlat_02  = 0,
+			.g_nlat_03  = 0,
+			.g_nlat_04  = 0,
+			.g_nlat_sum = 0,
 		};
 		bpf_map_update_elem(&cpu_host_metric, &g_key, &g_new_stat,
 				    COMPAT_BPF_NOEXIST);
@@ -196,12 +199,13 @@ int sched_switch_entry(struct bpf_raw_tracepoint_args *ctx)
 		entry = bpf_map_lookup_elem(&cpu_tg_metric, &key);
 		if (!entry) {
 			struct stat_t new_stat = {
-				.nvcsw	 = 0,
-				.nivcsw	 = 0,
-				.nlat_01 = 0,
-				.nlat_02 = 0,
-				.nlat_03 = 0,
-				.nlat_04 = 0,
+				.nvcsw	  = 0,
+				.nivcsw	  = 0,
+				.nlat_01  = 0,
+				.nlat_02  = 0,
+				.nlat_03  = 0,
+				.nlat_04  = 0,
+				.nlat_sum = 0,
 			};
 			bpf_map_update_elem(&cpu_tg_metric, &key, &new_stat,
 					    COMPAT_BPF_NOEXIST);
@@ -247,12 +251,13 @@ int sched_switch_entry(struct bpf_raw_tracepoint_args *ctx)
 		entry = bpf_map_lookup_elem(&cpu_tg_metric, &key);
 		if (!entry) {
 			struct stat_t new_stat = {
-				.nvcsw	 = 0,
-				.nivcsw	 = 0,
-				.nlat_01 = 0,
-				.nlat_02 = 0,
-				.nlat_03 = 0,
-				.nlat_04 = 0,
+				.nvcsw	  = 0,
+				.nivcsw	  = 0,
+				.nlat_01  = 0,
+				.nlat_02  = 0,
+				.nlat_03  = 0,
+				.nlat_04  = 0,
+				.nlat_sum = 0,
 			};
 			bpf_map_update_elem(&cpu_tg_metric, &key, &new_stat,
 					    COMPAT_BPF_NOEXIST);
@@ -261,6 +266,9 @@ int sched_switch_entry(struct bpf_raw_tracepoint_args *ctx)
 				return 0;
 		}
 
+		__sync_fetch_and_add(&entry->nlat_sum, delta);
+		__sync_fetch_and_add(&g_entry->g_nlat_sum, delta);
+
 		if (delta < 10 * NSEC_PER_MSEC) {
 			__sync_fetch_and_add(&entry->nlat_01, 1);
 			__sync_fetch_and_add(&g_entry->g_nlat_01, 1);
@@ -17,6 +17,7 @@ enum lat_zone {
 struct softirq_lat {
 	u64 timestamp;
 	u64 total_latency[LAT_ZONE_MAX];
+	u64 total_latency_sum; // in ns
 };
 
 struct {
@@ -29,15 +30,18 @@ struct {
 SEC("tracepoint/irq/softirq_raise")
 int probe_softirq_raise(struct trace_event_raw_softirq *ctx)
 {
-	struct softirq_lat lat = {
-		.timestamp = bpf_ktime_get_ns(),
-	};
+	struct softirq_lat *lat;
 	u32 vec = ctx->vec;
 
 	if (vec >= NR_SOFTIRQS)
 		return 0;
 
-	bpf_map_update_elem(&softirq_percpu_lats, &vec, &lat, COMPAT_BPF_ANY);
+	// only refresh the timestamp, keep the accumulated latency zones.
+	lat = bpf_map_lookup_elem(&softirq_percpu_lats, &vec);
+	if (!lat)
+		return 0;
+
+	lat->timestamp = bpf_ktime_get_ns();
 	return 0;
 }
 
@@ -51,11 +55,14 @@ int probe_softirq_entry(struct trace_event_raw_softirq *ctx)
 		return 0;
 
 	lat = bpf_map_lookup_elem(&softirq_percpu_lats, &vec);
-	if (!lat)
+	if (!lat || !lat->timestamp)
 		return 0;
 
 	u64 latency = bpf_ktime_get_ns() - lat->timestamp;
 
+	lat->timestamp = 0;
+	__sync_fetch_and_add(&lat->total_latency_sum, latency);
+
 	if (latency < 10 * NSEC_PER_USEC) {
 		__sync_fetch_and_add(&lat->total_latency[LAT_ZONE0], 1);
 	} else if (latency < 100 * NSEC_PER_USEC) {
@@ -0,0 +1,100 @@
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "bpf_common.h"
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+#define WAITRATE_MAX_CPUS    4096
+#define WAITRATE_MAX_CGROUPS 10240
+
+struct cpu_css_key {
+	u64 css;
+	u32 cpu;
+	u32 pad;
+};
+
+struct cpu_css_stat {
+	u64 runtime;
+	u64 nr_switches;
+};
+
+// the cpus of the contended container, written by userspace
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u32);
+	__uint(max_entries, WAITRATE_MAX_CPUS);
+} waitrate_target_cpus SEC(".maps");
+
+// the timestamp of the last context switch on the cpu
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, 1);
+} waitrate_switch_ts SEC(".maps");
+
+// the runtime of the cgroups on the target cpus
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, struct cpu_css_key);
+	__type(value, struct cpu_css_stat);
+	__uint(max_entries, WAITRATE_MAX_CGROUPS);
+} waitrate_cpu_css_stats SEC(".maps");
+
+SEC("tracepoint/sched/sched_switch")
+int tracepoint_sched_switch(struct trace_event_raw_sched_switch *ctx)
+{
+	struct cpu_css_stat *stat, new_stat = {};
+	struct cpu_css_key key		    = {};
+	struct task_struct *prev;
+	u32 cpu = bpf_get_smp_processor_id();
+	u32 zero = 0, *enabled;
+	u64 now, *last;
+
+	enabled = bpf_map_lookup_elem(&waitrate_target_cpus, &cpu);
+	if (!enabled || !*enabled)
+		return 0;
+
+	last = bpf_map_lookup_elem(&waitrate_switch_ts, &zero);
+	if (!last)
+		return 0;
+
+	now = bpf_ktime_get_ns();
+	if (*last == 0) {
+		*last = now;
+		return 0;
+	}
+
+	// the idle task is not a contender
+	if (ctx->prev_pid == 0) {
+		*last = now;
+		return 0;
+	}
+
+	// current is still the prev task in sched_switch
+	prev	= (struct task_struct *)bpf_get_current_task();
+	key.css = (u64)BPF_CORE_READ(prev, cgroups, subsys[cpu_cgrp_id]);
+	key.cpu = cpu;
+
+	stat = bpf_map_lookup_elem(&waitrate_cpu_css_stats, &key);
+	if (!stat) {
+		bpf_map_update_elem(&waitrate_cpu_css_stats, &key, &new_stat,
+				    COMPAT_BPF_NOEXIST);
+		stat = bpf_map_lookup_elem(&waitrate_cpu_css_stats, &key);
+		if (!stat) {
+			*last = now;
+			return 0;
+		}
+	}
+
+	__sync_fetch_and_add(&stat->runtime, now - *last);
+	__sync_fetch_and_add(&stat->nr_switches, 1);
+
+	*last = now;
+	return 0;
+}
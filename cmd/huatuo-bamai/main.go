@@ -15,11 +15,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -60,7 +62,7 @@ func mainAction(ctx *cli.Context) error {
 	if err := cgr.NewRuntime(ctx.App.Name,
 		cgroups.ToSpec(
 			conf.Get().RuntimeCgroup.LimitInitCPU,
-			conf.Get().RuntimeCgroup.LimitMem,
+			conf.Get().RuntimeCgroup.LimitMem*1024*1024,
 		),
 	); err != nil {
 		return fmt.Errorf("new runtime cgroup: %w", err)
@@ -75,13 +77,33 @@ func mainAction(ctx *cli.Context) error {
 
 	// initialize the storage clients.
 	storageInitCtx := storage.InitContext{
+		Sinks:             conf.Get().Storage.Sinks,
 		EsAddresses:       conf.Get().Storage.ES.Address,
 		EsUsername:        conf.Get().Storage.ES.Username,
 		EsPassword:        conf.Get().Storage.ES.Password,
 		EsIndex:           conf.Get().Storage.ES.Index,
+		EsBatchSize:       conf.Get().Storage.ES.BatchSize,
+		EsFlushInterval:   time.Duration(conf.Get().Storage.ES.FlushInterval) * time.Second,
+		EsQueueSize:       conf.Get().Storage.ES.QueueSize,
+		EsMaxRetries:      conf.Get().Storage.ES.MaxRetries,
+		EsSpillPath:       conf.Get().Storage.ES.SpillPath,
+		EsMaxSpillSize:    conf.Get().Storage.ES.MaxSpillSize,
 		LocalPath:         conf.Get().Storage.LocalFile.Path,
 		LocalMaxRotation:  conf.Get().Storage.LocalFile.MaxRotation,
 		LocalRotationSize: conf.Get().Storage.LocalFile.RotationSize,
+		LocalFormat:       conf.Get().Storage.LocalFile.Format,
+		LocalCompression:  conf.Get().Storage.LocalFile.Compression,
+		LocalMaxTotalSize: conf.Get().Storage.LocalFile.MaxTotalSize,
+		WebhookURL:        conf.Get().Storage.Webhook.URL,
+		WebhookHeaders:    conf.Get().Storage.Webhook.Headers,
+		WebhookTimeout:    time.Duration(conf.Get().Storage.Webhook.Timeout) * time.Second,
+		KafkaBrokers:      conf.Get().Storage.Kafka.Brokers,
+		KafkaTopic:        conf.Get().Storage.Kafka.Topic,
+		KafkaClientID:     conf.Get().Storage.Kafka.ClientID,
+		KafkaRequiredAcks: conf.Get().Storage.Kafka.RequiredAcks,
+		KafkaTimeout:      time.Duration(conf.Get().Storage.Kafka.Timeout) * time.Second,
+		TaskHistoryPath:   conf.Get().TaskConfig.HistoryPath,
+		TaskMaxHistory:    conf.Get().TaskConfig.MaxHistory,
 		Region:            conf.Region,
 	}
 
@@ -94,9 +116,12 @@ func mainAction(ctx *cli.Context) error {
 	}
 
 	podListInitCtx := pod.PodContainerInitCtx{
+		Provider:              conf.Get().Pod.Provider,
 		PodListReadOnlyPort:   conf.Get().Pod.KubeletPodListURL,
 		PodListAuthorizedPort: conf.Get().Pod.KubeletPodListHTTPSURL,
 		PodClientCertPath:     conf.Get().Pod.KubeletPodClientCertPath,
+		CRIEndpoint:           conf.Get().Pod.CRIEndpoint,
+		DockerAPIVersion:      conf.Get().Pod.DockerAPIVersion,
 	}
 
 	if err := pod.ContainerPodMgrInit(&podListInitCtx); err != nil {
@@ -104,7 +129,8 @@ func mainAction(ctx *cli.Context) error {
 	}
 
 	blacklisted := conf.Get().Blacklist
-	prom, err := InitMetricsCollector(blacklisted, conf.Region)
+	scrapeTimeout := time.Duration(conf.Get().MetricCollector.ScrapeTimeout) * time.Second
+	prom, err := InitMetricsCollector(blacklisted, conf.Region, scrapeTimeout)
 	if err != nil {
 		return fmt.Errorf("InitMetricsCollector: %w", err)
 	}
@@ -119,7 +145,15 @@ func mainAction(ctx *cli.Context) error {
 	}
 
 	log.Infof("Initialize the Metrics collector: %v", prom)
-	services.Start(conf.Get().APIServer.TCPAddr, mgr, prom)
+	if err := services.Start(&services.ServerOption{
+		Addr:         conf.Get().APIServer.TCPAddr,
+		CertFile:     conf.Get().APIServer.TLS.CertFile,
+		KeyFile:      conf.Get().APIServer.TLS.KeyFile,
+		ClientCAFile: conf.Get().APIServer.TLS.ClientCAFile,
+		Tokens:       conf.Get().APIServer.Tokens,
+	}, mgr, prom); err != nil {
+		return fmt.Errorf("start api server: %w", err)
+	}
 
 	// update cpu quota
 	if err := cgr.UpdateRuntime(cgroups.ToSpec(conf.Get().RuntimeCgroup.LimitCPU, 0)); err != nil {
@@ -138,10 +172,13 @@ func mainAction(ctx *cli.Context) error {
 	for {
 		s := <-waitExit
 		switch s {
-		case syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM:
+		case syscall.SIGHUP:
+			reloadConfig(mgr)
+		case syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM:
 			log.Infof("huatuo-bamai exit by signal %d", s)
 			bpf.CloseBpfManager()
 			pod.ContainerPodMgrClose()
+			storage.Close()
 			return nil
 		case syscall.SIGUSR1:
 			return nil
@@ -151,6 +188,24 @@ func mainAction(ctx *cli.Context) error {
 	}
 }
 
+// reloadConfig reloads the config file, and restarts the tracers whose config
+// are changed.
+func reloadConfig(mgr *tracing.MgrTracingEvent) {
+	changed, err := conf.Reload()
+	if err != nil {
+		log.Errorf("reload config: %v", err)
+		return
+	}
+
+	if slices.Contains(changed, "LogLevel") && conf.Get().LogLevel != "" {
+		log.SetLevel(conf.Get().LogLevel)
+	}
+
+	services.ReloadConfig(changed)
+	restarted := mgr.MgrTracingEventRestartByConfig(changed)
+	log.Infof("reload config by SIGHUP, changed %v, restart tracers %v", changed, restarted)
+}
+
 var (
 	// AppGitCommit will be the hash that the binary was built from
 	// and will be populated by the Makefile
@@ -226,9 +281,8 @@ func main() {
 			Usage: "tools bin dir",
 		},
 		&cli.StringFlag{
-			Name:     "region",
-			Required: true,
-			Usage:    "the host and containers are in this region",
+			Name:  "region",
+			Usage: "the host and containers are in this region, required",
 		},
 		&cli.StringSliceFlag{
 			Name:  "disable-tracing",
@@ -242,9 +296,25 @@ func main() {
 			Name:  "dry-run",
 			Usage: "for loading tests, exit gracefully",
 		},
+		&cli.BoolFlag{
+			Name:  "print-default-config",
+			Usage: "print the default config in TOML and exit",
+		},
 	}
 
 	app.Before = func(ctx *cli.Context) error {
+		if ctx.Bool("print-default-config") {
+			if err := conf.PrintDefaultConfig(os.Stdout); err != nil {
+				return fmt.Errorf("print default config: %w", err)
+			}
+			return cli.Exit("", 0)
+		}
+
+		// the region is not required for printing the default config
+		if ctx.String("region") == "" {
+			return errors.New("required flag \"region\" not set")
+		}
+
 		bpf.DefaultBpfObjDir = buildOptionDir(optionBpfObjDir, ctx)
 		tracing.TaskBinDir = buildOptionDir(optionToolBinDir, ctx)
 
@@ -276,10 +346,7 @@ func main() {
 		// tracer
 		disabledTracing := ctx.StringSlice("disable-tracing")
 		if len(disabledTracing) > 0 {
-			definedTracers := conf.Get().Blacklist
-			definedTracers = append(definedTracers, disabledTracing...)
-
-			conf.Set("Blacklist", definedTracers)
+			conf.SetBlacklistOverride(disabledTracing)
 			log.Infof("The tracer black list by cli: %v", conf.Get().Blacklist)
 		}
 
@@ -16,6 +16,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"huatuo-bamai/pkg/metric"
 
@@ -26,8 +27,8 @@ import (
 var promNamespace = "huatuo_bamai"
 
 // InitMetricsCollector creates a new MetricsCollector instance.
-func InitMetricsCollector(blackListed []string, region string) (*prometheus.Registry, error) {
-	nc, err := metric.NewCollectorManager(blackListed, region)
+func InitMetricsCollector(blackListed []string, region string, scrapeTimeout time.Duration) (*prometheus.Registry, error) {
+	nc, err := metric.NewCollectorManager(blackListed, region, scrapeTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("create collector: %w", err)
 	}
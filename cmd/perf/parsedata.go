@@ -15,19 +15,17 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"sort"
+	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/flamegraph"
+	"huatuo-bamai/internal/profile"
 	"huatuo-bamai/internal/symbol"
 
-	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 )
@@ -35,23 +33,6 @@ import (
 // FlameData is flamegraph data
 var FlameData []flamegraph.FrameData
 
-const perfStackDepth = 20
-
-type eventdata struct {
-	Ustack     [perfStackDepth]uint64
-	Kstack     [perfStackDepth]uint64
-	UstackSize int64
-	KstackSize int64
-	Pid        uint32
-	Name       [16]byte
-}
-
-// CgDumpTrace is an interface for dump stacks in cgusage case
-func CgDumpTrace(addrs []uint64) string {
-	stacks := symbol.DumpKernelBackTrace(addrs, perfStackDepth)
-	return strings.Join(stacks.BackTrace, "\n")
-}
-
 func convertLevels(levels []*querierv1.Level) []*flamegraph.Level {
 	var result []*flamegraph.Level
 	for _, l := range levels {
@@ -63,101 +44,62 @@ func convertLevels(levels []*querierv1.Level) []*flamegraph.Level {
 	return result
 }
 
-func findOrAdd(strA string, b []string) (int, []string) {
-	var index int
-	found := false
-	for idxB, strB := range b {
-		if strA == strB {
-			index = idxB
-			found = true
-			break
-		}
+const (
+	// formatFlamegraphJSON is the nested set of the grafana flamegraph, the
+	// []flamegraph.FrameData in json.
+	formatFlamegraphJSON = "flamegraph-json"
+	// formatFolded is the folded stacks of flamegraph.pl and speedscope.
+	formatFolded = "folded"
+	// formatPprof is the gzipped pprof profile of `go tool pprof`.
+	formatPprof = "pprof"
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case formatFlamegraphJSON, formatFolded, formatPprof:
+		return nil
+	default:
+		return fmt.Errorf("invalid format %q, must be %s, %s or %s",
+			format, formatFlamegraphJSON, formatFolded, formatPprof)
 	}
-	if !found {
-		b = append(b, strA)
-		index = len(b) - 1
+}
+
+func sampleType(mode string) profile.SampleType {
+	if mode == modeOffCPU {
+		return profile.SampleType{Type: "offcpu", Unit: "microseconds"}
 	}
 
-	return index, b
+	return profile.SampleType{Type: "samples", Unit: "count"}
 }
 
-func parsedata(b bpf.BPF) error {
-	items, err := b.DumpMapByName("counts")
-	if err != nil || items == nil {
+func parsedata(b bpf.BPF, mode, format string, duration time.Duration) error {
+	stacks, err := profile.ReadStackCounts(b, false)
+	if err != nil {
 		return err
 	}
 
-	var keyValuePairs []struct {
-		Key   *eventdata
-		Value uint64
+	// the empty profile of the format is still written, the callers always
+	// get a valid output.
+	if len(stacks) == 0 {
+		fmt.Fprintln(os.Stderr, "perf: no samples")
 	}
 
-	u := symbol.NewUsym()
-	for _, v := range items {
-		ed := eventdata{}
-		var count uint64
-		buf := bytes.NewReader(v.Key)
-		err := binary.Read(buf, binary.LittleEndian, &ed)
-		if err != nil {
-			return err
-		}
-		buf = bytes.NewReader(v.Value)
-		err = binary.Read(buf, binary.LittleEndian, &count)
-		if err != nil {
-			return err
+	switch format {
+	case formatFolded:
+		return profile.WriteFolded(os.Stdout, stacks, symbol.NewUsym())
+	case formatPprof:
+		return profile.WritePprof(os.Stdout, stacks, symbol.NewUsym(), sampleType(mode), duration)
+	default:
+		if len(stacks) == 0 {
+			fmt.Println("[]")
+			return nil
 		}
-
-		keyValuePairs = append(keyValuePairs, struct {
-			Key   *eventdata
-			Value uint64
-		}{
-			Key:   &ed,
-			Value: count,
-		})
+		return writeFlamegraphJSON(stacks)
 	}
-	sort.Slice(keyValuePairs, func(i, j int) bool {
-		return keyValuePairs[i].Value < keyValuePairs[j].Value
-	})
-
-	var stacktraces []*ingestv1.StacktraceSample
-	var functionNames []string
-
-	for k := range keyValuePairs {
-		sample := &ingestv1.StacktraceSample{}
-		var index int
-		kv := keyValuePairs[k]
-		sample.Value = int64(kv.Value)
-
-		if kv.Key.KstackSize > 0 {
-			kernelStack := CgDumpTrace(kv.Key.Kstack[:])
-			kstack := strings.Split(kernelStack, "\n")
-			for _, v := range kstack {
-				if v != "" {
-					index, functionNames = findOrAdd(v+"_[k]", functionNames)
-					sample.FunctionIds = append(sample.FunctionIds, int32(index))
-				}
-			}
-		}
-
-		if kv.Key.UstackSize > 0 {
-			for _, addr := range &kv.Key.Ustack {
-				if addr == 0 {
-					break
-				}
-				usym := u.ResolveUstack(addr, kv.Key.Pid)
-				if usym != "" {
-					index, functionNames = findOrAdd(usym, functionNames)
-					sample.FunctionIds = append(sample.FunctionIds, int32(index))
-				}
-			}
-		}
-
-		sttitle := strings.TrimRight(string(kv.Key.Name[:]), "\x00")
-		index, functionNames = findOrAdd(sttitle, functionNames)
-		sample.FunctionIds = append(sample.FunctionIds, int32(index))
+}
 
-		stacktraces = append(stacktraces, sample)
-	}
+func writeFlamegraphJSON(stacks []profile.StackCount) error {
+	stacktraces, functionNames := profile.StacktraceSamples(stacks, symbol.NewUsym())
 
 	// Convert data formats
 	m := phlaremodel.NewTreeMerger()
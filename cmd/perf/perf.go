@@ -28,6 +28,7 @@ import (
 	"huatuo-bamai/internal/bpf"
 	"huatuo-bamai/internal/command/container"
 	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/profile"
 )
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/perf.c -o perf.o
@@ -35,11 +36,55 @@ import (
 //go:embed perf.o
 var perfBpfObj []byte
 
+const (
+	// modeOnCPU samples the stacks running on the cpus at the frequency, or
+	// every period of the cpu clock.
+	modeOnCPU = "oncpu"
+	// modeOffCPU records the stacks switched out from the cpus, weighted by
+	// the microseconds until switched in again.
+	modeOffCPU = "offcpu"
+)
+
+func attachOption(mode string, freq, period uint64) (bpf.AttachOption, error) {
+	switch mode {
+	case modeOnCPU:
+		if freq == 0 && period == 0 {
+			return bpf.AttachOption{}, fmt.Errorf("either freq or period must be set")
+		}
+
+		opt := bpf.AttachOption{
+			ProgramName: "perf_event_sw_cpu_clock",
+		}
+		opt.PerfEvent.SampleFreq = freq
+		opt.PerfEvent.SamplePeriod = period
+		return opt, nil
+	case modeOffCPU:
+		return bpf.AttachOption{
+			ProgramName: "tracepoint_sched_switch",
+			Symbol:      "sched/sched_switch",
+		}, nil
+	default:
+		return bpf.AttachOption{}, fmt.Errorf("invalid mode %q, must be %s or %s", mode, modeOnCPU, modeOffCPU)
+	}
+}
+
 func mainAction(ctx *cli.Context) error {
 	optBpfObj := ctx.String("bpf-obj")
 	optPid := ctx.Uint64("pid")
 	optDuration := ctx.Int("duration")
 
+	optMode := ctx.String("mode")
+	optFormat := ctx.String("format")
+
+	opt, err := attachOption(optMode, ctx.Uint64("freq"), ctx.Uint64("period"))
+	if err != nil {
+		return err
+	}
+
+	if err := checkFormat(optFormat); err != nil {
+		return err
+	}
+
 	var targetCssAddr uint64
 	if containerID := ctx.String("container-id"); containerID != "" {
 		c, err := container.GetContainerByID(ctx.String("server-address"), containerID)
@@ -56,16 +101,22 @@ func mainAction(ctx *cli.Context) error {
 	}
 	defer bpf.CloseBpfManager()
 
-	b, err := bpf.LoadBpfFromBytes(optBpfObj, perfBpfObj, map[string]any{"css": targetCssAddr, "pid": optPid})
+	consts, mapOpts, err := (&profile.LoadOption{
+		CSS:        targetCssAddr,
+		Pid:        optPid,
+		StackDepth: ctx.Int("stack-depth"),
+		Stack:      ctx.String("stack"),
+	}).Consts()
+	if err != nil {
+		return err
+	}
+
+	b, err := bpf.LoadBpfFromBytes(optBpfObj, perfBpfObj, consts, mapOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to load bpf: %w", err)
 	}
 	defer b.Close()
 
-	opt := bpf.AttachOption{
-		ProgramName: "perf_event_sw_cpu_clock",
-	}
-	opt.PerfEvent.SampleFreq = 99
 	if err := b.AttachWithOptions([]bpf.AttachOption{opt}); err != nil {
 		return fmt.Errorf("attach err %w", err)
 	}
@@ -81,7 +132,7 @@ func mainAction(ctx *cli.Context) error {
 		return fmt.Errorf("received signal %s", sig)
 	}
 
-	if err := parsedata(b); err != nil {
+	if err := parsedata(b, optMode, optFormat, time.Duration(optDuration)*time.Second); err != nil {
 		return fmt.Errorf("parsedata err %w", err)
 	}
 
@@ -113,9 +164,40 @@ func main() {
 			Usage: "Tool duration(s)",
 		},
 		&cli.StringFlag{
-			Name:  "server-address",
-			Value: "127.0.0.1:19704",
-			Usage: "huatuo-bamai server address",
+			Name:  "mode",
+			Value: modeOnCPU,
+			Usage: "Profiling mode, oncpu or offcpu",
+		},
+		&cli.Uint64Flag{
+			Name:  "freq",
+			Value: 99,
+			Usage: "Sample frequency(Hz) of the oncpu mode",
+		},
+		&cli.Uint64Flag{
+			Name:  "period",
+			Value: 0,
+			Usage: "Sample period(ns) of the cpu clock of the oncpu mode, overrides the freq if set",
+		},
+		&cli.IntFlag{
+			Name:  "stack-depth",
+			Value: profile.DefaultStackDepth,
+			Usage: "Max depth of the user and kernel stacks, up to kernel.perf_event_max_stack",
+		},
+		&cli.StringFlag{
+			Name:  "stack",
+			Value: profile.StackAll,
+			Usage: "Stacks to capture, all, user or kernel",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: formatFlamegraphJSON,
+			Usage: "Output format, flamegraph-json, folded or pprof",
+		},
+		&cli.StringFlag{
+			Name:    "server-address",
+			Value:   "127.0.0.1:19704",
+			Usage:   "huatuo-bamai server address, host:port or with the scheme, e.g. https://127.0.0.1:19704",
+			EnvVars: []string{container.EnvServerAddr},
 		},
 	}
 
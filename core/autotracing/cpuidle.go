@@ -45,9 +45,10 @@ func newCPUIdle() (*tracing.EventTracingAttr, error) {
 	cgroupMgr, _ = cgroups.NewCgroupManager()
 
 	return &tracing.EventTracingAttr{
-		TracingData: &cpuIdleTracing{},
-		Internal:    20,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &cpuIdleTracing{},
+		Internal:       20,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.CPUIdle"},
 	}, nil
 }
 
@@ -225,15 +226,49 @@ func shouldCareThisEvent(container *containerCPUInfo, threshold *cpuIdleThreshol
 	return false
 }
 
-func runPerf(parent context.Context, containerId string, timeOut int64) ([]byte, error) {
+// perfOptions is the options of the perf tool, the zero options are the
+// defaults of the tool.
+type perfOptions struct {
+	mode         string
+	stackDepth   int
+	stack        string
+	sampleFreq   uint64
+	samplePeriod uint64
+}
+
+func (o *perfOptions) args() []string {
+	var args []string
+
+	if o.mode != "" {
+		args = append(args, "--mode", o.mode)
+	}
+	if o.stackDepth != 0 {
+		args = append(args, "--stack-depth", strconv.Itoa(o.stackDepth))
+	}
+	if o.stack != "" {
+		args = append(args, "--stack", o.stack)
+	}
+	if o.sampleFreq != 0 {
+		args = append(args, "--freq", strconv.FormatUint(o.sampleFreq, 10))
+	}
+	if o.samplePeriod != 0 {
+		args = append(args, "--period", strconv.FormatUint(o.samplePeriod, 10))
+	}
+
+	return args
+}
+
+func runPerf(parent context.Context, opts *perfOptions, containerId string, timeOut int64) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(parent, time.Duration(timeOut+30)*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"),
+	args := append([]string{
 		"--bpf-obj", "cpuidle.o",
 		"--container-id", containerId,
-		"--duration", strconv.FormatInt(timeOut, 10))
+		"--duration", strconv.FormatInt(timeOut, 10),
+	}, opts.args()...)
 
+	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"), args...)
 	return cmd.CombinedOutput()
 }
 
@@ -281,6 +316,13 @@ type CPUIdleTracingData struct {
 func (c *cpuIdleTracing) Start(ctx context.Context) error {
 	interval := conf.Get().Tracing.CPUIdle.Interval
 	perfRunTimeOut := conf.Get().Tracing.CPUIdle.PerfRunTimeOut
+	perfOpts := &perfOptions{
+		mode:         conf.Get().Tracing.CPUIdle.PerfMode,
+		stackDepth:   conf.Get().Tracing.CPUIdle.PerfStackDepth,
+		stack:        conf.Get().Tracing.CPUIdle.PerfStack,
+		sampleFreq:   conf.Get().Tracing.CPUIdle.PerfSampleFreq,
+		samplePeriod: conf.Get().Tracing.CPUIdle.PerfSamplePeriod,
+	}
 
 	threshold := &cpuIdleThreshold{
 		deltaUser:              conf.Get().Tracing.CPUIdle.DeltaUserThreshold,
@@ -306,11 +348,11 @@ func (c *cpuIdleTracing) Start(ctx context.Context) error {
 				continue
 			}
 
-			log.Infof("start perf container [%s], id [%s] with usage: %v, perf_run_timeout: %d",
+			log.Infof("start perf container [%s], id [%s] with usage: %v, perf_run_timeout: %d, perf_mode: %s",
 				container.path, container.id,
 				container.nowUsagePercentage,
-				perfRunTimeOut)
-			flamedata, err := runPerf(ctx, container.id, perfRunTimeOut)
+				perfRunTimeOut, perfOpts.mode)
+			flamedata, err := runPerf(ctx, perfOpts, container.id, perfRunTimeOut)
 			if err != nil {
 				log.Debugf("perf err: %v, output: %v", err, string(flamedata))
 				return err
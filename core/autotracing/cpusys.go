@@ -39,9 +39,10 @@ func init() {
 
 func newCpuSys() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &cpuSysTracing{},
-		Internal:    20,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &cpuSysTracing{},
+		Internal:       20,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.CPUSys"},
 	}, nil
 }
 
@@ -127,13 +128,16 @@ func (c *cpuSysTracing) shouldCareThisEvent(threshold *cpuSysThreshold) bool {
 	return false
 }
 
-func runPerfSystemWide(parent context.Context, timeOut int64) ([]byte, error) {
+func runPerfSystemWide(parent context.Context, opts *perfOptions, timeOut int64) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(parent, time.Duration(timeOut+30)*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"),
+	args := append([]string{
 		"--bpf-obj", "cpuidle.o",
-		"--duration", strconv.FormatInt(timeOut, 10))
+		"--duration", strconv.FormatInt(timeOut, 10),
+	}, opts.args()...)
+
+	cmd := exec.CommandContext(ctx, path.Join(tracing.TaskBinDir, "perf"), args...)
 
 	return cmd.CombinedOutput()
 }
@@ -158,6 +162,12 @@ func (c *cpuSysTracing) buildAndSaveCPUSystem(traceTime time.Time, threshold *cp
 func (c *cpuSysTracing) Start(ctx context.Context) error {
 	interval := conf.Get().Tracing.CPUSys.Interval
 	perfRunTimeOut := conf.Get().Tracing.CPUSys.PerfRunTimeOut
+	perfOpts := &perfOptions{
+		stackDepth:   conf.Get().Tracing.CPUSys.PerfStackDepth,
+		stack:        conf.Get().Tracing.CPUSys.PerfStack,
+		sampleFreq:   conf.Get().Tracing.CPUSys.PerfSampleFreq,
+		samplePeriod: conf.Get().Tracing.CPUSys.PerfSamplePeriod,
+	}
 
 	threshold := &cpuSysThreshold{
 		delta: conf.Get().Tracing.CPUSys.DeltaSysThreshold,
@@ -181,7 +191,7 @@ func (c *cpuSysTracing) Start(ctx context.Context) error {
 
 			log.Infof("start perf system wide, cpu sys: %d, delta: %d, perf_run_timeout: %d",
 				c.sysPercent, c.sysPercentDelta, perfRunTimeOut)
-			flamedata, err := runPerfSystemWide(ctx, perfRunTimeOut)
+			flamedata, err := runPerfSystemWide(ctx, perfOpts, perfRunTimeOut)
 			if err != nil {
 				log.Debugf("perf err: %v, output: %v", err, string(flamedata))
 				return err
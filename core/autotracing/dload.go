@@ -17,6 +17,7 @@ package autotracing
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -24,6 +25,7 @@ import (
 	"huatuo-bamai/internal/cgroups"
 	"huatuo-bamai/internal/cgroups/paths"
 	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/flamegraph"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/pod"
 	"huatuo-bamai/internal/storage"
@@ -42,9 +44,10 @@ func init() {
 
 func newDload() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &dloadTracing{},
-		Internal:    30,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &dloadTracing{},
+		Internal:       30,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.Dload"},
 	}, nil
 }
 
@@ -71,6 +74,9 @@ type DloadTracingData struct {
 	KnowIssue         string  `json:"known_issue"`
 	InKnownList       uint64  `json:"in_known_list"`
 	Stack             string  `json:"stack"`
+	// FlameData is the off-cpu flamegraph of the container, the values are
+	// the microseconds of the stacks switched out.
+	FlameData []flamegraph.FrameData `json:"flamedata,omitempty"`
 }
 
 const (
@@ -151,7 +157,9 @@ func detectDloadContainer(thresh float64, interval int) (*containerDloadInfo, ca
 	return nil, empty, fmt.Errorf("no dload containers")
 }
 
-func buildAndSaveDloadContainer(thresh float64, container *containerDloadInfo, loadstat cadvisorV1.LoadStats) error {
+func buildAndSaveDloadContainer(ctx context.Context, thresh float64, perfRunTimeOut int64,
+	container *containerDloadInfo, loadstat cadvisorV1.LoadStats,
+) error {
 	cgrpPath := container.name
 	containerID := container.container.ID
 	containerHostNamespace := container.container.LabelHostNamespace()
@@ -182,6 +190,17 @@ func buildAndSaveDloadContainer(thresh float64, container *containerDloadInfo, l
 		Stack:             fmt.Sprintf("%s%s", stackCgrp, stackHost),
 	}
 
+	if perfRunTimeOut > 0 {
+		flamedata, err := runPerf(ctx, &perfOptions{mode: "offcpu"}, containerID, perfRunTimeOut)
+		if err != nil {
+			log.Infof("off-cpu perf container %s: %v, output: %s", containerID, err, flamedata)
+		} else if len(flamedata) > 0 {
+			if err := json.Unmarshal(flamedata, &data.FlameData); err != nil {
+				log.Infof("off-cpu perf container %s: %v", containerID, err)
+			}
+		}
+	}
+
 	// Check if this is caused by known issues.
 	knownIssue, inKnownList := conf.KnownIssueSearch(stackCgrp, containerHostNamespace, "")
 	if knownIssue != "" {
@@ -343,6 +362,7 @@ type dloadTracing struct{}
 func (c *dloadTracing) Start(ctx context.Context) error {
 	thresh := conf.Get().Tracing.Dload.ThresholdLoad
 	interval := conf.Get().Tracing.Dload.MonitorGap
+	perfRunTimeOut := conf.Get().Tracing.Dload.PerfRunTimeOut
 
 	for {
 		select {
@@ -360,7 +380,7 @@ func (c *dloadTracing) Start(ctx context.Context) error {
 				continue
 			}
 
-			_ = buildAndSaveDloadContainer(thresh, container, loadstat)
+			_ = buildAndSaveDloadContainer(ctx, thresh, perfRunTimeOut, container, loadstat)
 		}
 	}
 }
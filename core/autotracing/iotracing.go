@@ -0,0 +1,615 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autotracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/iotracing.c -o $BPF_DIR/iotracing.o
+
+func init() {
+	tracing.RegisterEventTracing("iotracing", newIOTracing,
+		capability.BTF(),
+		capability.Kprobe("vfs_read"),
+		capability.Kprobe("vfs_write"),
+		capability.Kprobe("io_schedule"))
+}
+
+func newIOTracing() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData:    &ioTracing{},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.IOTracing"},
+	}, nil
+}
+
+const (
+	diskSectorSize     = 512
+	ioTracingFileName  = 64
+	ioTracingCSSSubsys = "cpu"
+)
+
+type ioTracing struct {
+	disks map[string]*diskStat
+}
+
+// diskStat is the counters of a disk in /proc/diskstats.
+type diskStat struct {
+	major, minor   uint32
+	reads, writes  uint64
+	readSectors    uint64
+	writeSectors   uint64
+	readTicks      uint64
+	writeTicks     uint64
+	ioTicks        uint64
+	sampleUnixNano int64
+}
+
+type ioFileKey struct {
+	Tgid uint32
+	Dev  uint32
+	Ino  uint64
+}
+
+type ioFileStat struct {
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadCount  uint64
+	WriteCount uint64
+	CPUCSS     uint64
+	Comm       [bpf.TaskCommLen]byte
+	Name       [ioTracingFileName]byte
+}
+
+type ioSchedulePerfEvent struct {
+	Latency uint64
+	CPUCSS  uint64
+	StackID int64
+	Pid     uint32
+	Tgid    uint32
+	Comm    [bpf.TaskCommLen]byte
+}
+
+// IOTracingData is the full data structure.
+type IOTracingData struct {
+	Threshold    IOTracingThreshold `json:"threshold"`
+	Disks        []DiskIOStat       `json:"disks"`
+	TopProcesses []ProcessIOInfo    `json:"top_processes"`
+	IOStacks     []IOScheduleStack  `json:"io_stacks"`
+}
+
+type IOTracingThreshold struct {
+	IOutil              uint64 `json:"ioutil"`
+	IOwait              uint64 `json:"iowait"`
+	ReadMBps            uint64 `json:"read_mbps"`
+	WriteMBps           uint64 `json:"write_mbps"`
+	IOScheduleLatencyMs uint64 `json:"io_schedule_latency_ms"`
+}
+
+// DiskIOStat is the disk status when the thresholds trip.
+type DiskIOStat struct {
+	Name      string  `json:"name"`
+	Device    string  `json:"device"`
+	IOutil    float64 `json:"ioutil"`
+	IOwait    float64 `json:"iowait"`
+	ReadMBps  float64 `json:"read_mbps"`
+	WriteMBps float64 `json:"write_mbps"`
+}
+
+// ProcessIOInfo is the io of a process and its top files.
+type ProcessIOInfo struct {
+	Pid               uint32       `json:"pid"`
+	Comm              string       `json:"comm"`
+	ContainerID       string       `json:"container_id"`
+	ContainerHostname string       `json:"container_hostname"`
+	ReadBytes         uint64       `json:"read_bytes"`
+	WriteBytes        uint64       `json:"write_bytes"`
+	Files             []FileIOInfo `json:"files"`
+}
+
+// FileIOInfo is the io of a file accessed by a process.
+type FileIOInfo struct {
+	Path       string `json:"path"`
+	Inode      uint64 `json:"inode"`
+	Device     string `json:"device"`
+	Disk       string `json:"disk"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+// IOScheduleStack is the kernel stack of the task blocked in io_schedule.
+type IOScheduleStack struct {
+	Pid               uint32 `json:"pid"`
+	Comm              string `json:"comm"`
+	ContainerID       string `json:"container_id"`
+	ContainerHostname string `json:"container_hostname"`
+	Count             uint64 `json:"count"`
+	MaxLatencyMs      uint64 `json:"max_latency_ms"`
+	Stack             string `json:"stack"`
+}
+
+func readDiskStats() (map[string]*diskStat, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	now := time.Now().UnixNano()
+	disks := make(map[string]*diskStat)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+
+		// only the whole disks, skip the partitions.
+		if _, err := os.Stat(filepath.Join("/sys/block", strings.ReplaceAll(name, "/", "!"))); err != nil {
+			continue
+		}
+
+		var vals [14]uint64
+		for i := 0; i < 14; i++ {
+			if i == 2 {
+				continue
+			}
+
+			vals[i], err = strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse diskstats %s: %w", name, err)
+			}
+		}
+
+		disks[name] = &diskStat{
+			major:          uint32(vals[0]),
+			minor:          uint32(vals[1]),
+			reads:          vals[3],
+			readSectors:    vals[5],
+			readTicks:      vals[6],
+			writes:         vals[7],
+			writeSectors:   vals[9],
+			writeTicks:     vals[10],
+			ioTicks:        vals[12],
+			sampleUnixNano: now,
+		}
+	}
+
+	return disks, scanner.Err()
+}
+
+// diskIOStat calculates the util, await and bandwidth like iostat.
+func diskIOStat(name string, prev, cur *diskStat) *DiskIOStat {
+	elapsedMs := float64(cur.sampleUnixNano-prev.sampleUnixNano) / float64(time.Millisecond)
+	if elapsedMs <= 0 {
+		return nil
+	}
+
+	stat := &DiskIOStat{
+		Name:      name,
+		Device:    fmt.Sprintf("%d:%d", cur.major, cur.minor),
+		IOutil:    100 * float64(cur.ioTicks-prev.ioTicks) / elapsedMs,
+		ReadMBps:  float64((cur.readSectors-prev.readSectors)*diskSectorSize) / (1 << 20) / (elapsedMs / 1000),
+		WriteMBps: float64((cur.writeSectors-prev.writeSectors)*diskSectorSize) / (1 << 20) / (elapsedMs / 1000),
+	}
+
+	if ios := (cur.reads - prev.reads) + (cur.writes - prev.writes); ios > 0 {
+		stat.IOwait = float64((cur.readTicks-prev.readTicks)+(cur.writeTicks-prev.writeTicks)) / float64(ios)
+	}
+
+	if stat.IOutil > 100 {
+		stat.IOutil = 100
+	}
+
+	return stat
+}
+
+func (t *ioTracing) abnormalDisks(threshold *IOTracingThreshold) ([]DiskIOStat, error) {
+	disks, err := readDiskStats()
+	if err != nil {
+		return nil, err
+	}
+
+	prevDisks := t.disks
+	t.disks = disks
+
+	var abnormal []DiskIOStat
+	for name, cur := range disks {
+		prev, ok := prevDisks[name]
+		if !ok {
+			continue
+		}
+
+		stat := diskIOStat(name, prev, cur)
+		if stat == nil {
+			continue
+		}
+
+		log.Debugf("disk %s util %.2f await %.2f read %.2fMB/s write %.2fMB/s",
+			name, stat.IOutil, stat.IOwait, stat.ReadMBps, stat.WriteMBps)
+
+		if (stat.IOutil >= float64(threshold.IOutil) && stat.IOwait >= float64(threshold.IOwait)) ||
+			stat.ReadMBps >= float64(threshold.ReadMBps) ||
+			stat.WriteMBps >= float64(threshold.WriteMBps) {
+			abnormal = append(abnormal, *stat)
+		}
+	}
+
+	return abnormal, nil
+}
+
+// blockDeviceDisk returns the whole disk name of the block device, the empty
+// string is returned for the non-block device, e.g. overlayfs, tmpfs.
+func blockDeviceDisk(major, minor uint32) string {
+	if major == 0 {
+		return ""
+	}
+
+	devPath := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	link, err := os.Readlink(devPath)
+	if err != nil {
+		return ""
+	}
+
+	if _, err := os.Stat(filepath.Join(devPath, "partition")); err == nil {
+		return filepath.Base(filepath.Dir(link))
+	}
+
+	return filepath.Base(link)
+}
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// processOpenFiles returns the paths of the files opened by the process.
+func processOpenFiles(tgid uint32) map[fileID]string {
+	fdDir := fmt.Sprintf("/proc/%d/fd", tgid)
+
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return nil
+	}
+
+	files := make(map[fileID]string)
+	for _, entry := range entries {
+		fdPath := filepath.Join(fdDir, entry.Name())
+
+		var stat unix.Stat_t
+		if err := unix.Stat(fdPath, &stat); err != nil {
+			continue
+		}
+
+		if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+			continue
+		}
+
+		target, err := os.Readlink(fdPath)
+		if err != nil {
+			continue
+		}
+
+		files[fileID{dev: stat.Dev, ino: stat.Ino}] = target
+	}
+
+	return files
+}
+
+func containerByCSS(css uint64, cssToContainer map[uint64]string, containers map[string]*pod.Container) (id, hostname string) {
+	id, ok := cssToContainer[css]
+	if !ok {
+		return "", ""
+	}
+
+	if c, ok := containers[id]; ok {
+		hostname = c.Hostname
+	}
+
+	return id, hostname
+}
+
+func dumpIOFileStats(b bpf.BPF, disks []DiskIOStat, topProcess, topFiles int) ([]ProcessIOInfo, error) {
+	items, err := b.DumpMapByName("iotracing_file_stats")
+	if err != nil {
+		return nil, err
+	}
+
+	cssToContainer, err := pod.GetCSSToContainerID(ioTracingCSSSubsys)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := pod.GetAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	abnormalDisks := make(map[string]bool, len(disks))
+	for i := range disks {
+		abnormalDisks[disks[i].Name] = true
+	}
+
+	processes := make(map[uint32]*ProcessIOInfo)
+	for _, item := range items {
+		var key ioFileKey
+		var val ioFileStat
+
+		if err := binary.Read(bytes.NewReader(item.Key), binary.NativeEndian, &key); err != nil {
+			return nil, fmt.Errorf("parse file key: %w", err)
+		}
+		if err := binary.Read(bytes.NewReader(item.Value), binary.NativeEndian, &val); err != nil {
+			return nil, fmt.Errorf("parse file stat: %w", err)
+		}
+
+		// the kernel dev_t is MAJOR << 20 | MINOR.
+		major, minor := key.Dev>>20, key.Dev&0xfffff
+
+		// ignore the files on other disks, keep the files on non-block
+		// device, e.g. overlayfs of the containers.
+		disk := blockDeviceDisk(major, minor)
+		if disk != "" && !abnormalDisks[disk] {
+			continue
+		}
+
+		p, ok := processes[key.Tgid]
+		if !ok {
+			p = &ProcessIOInfo{
+				Pid:  key.Tgid,
+				Comm: strings.TrimRight(string(val.Comm[:]), "\x00"),
+			}
+			p.ContainerID, p.ContainerHostname = containerByCSS(val.CPUCSS, cssToContainer, containers)
+			processes[key.Tgid] = p
+		}
+
+		p.ReadBytes += val.ReadBytes
+		p.WriteBytes += val.WriteBytes
+		p.Files = append(p.Files, FileIOInfo{
+			Path:       strings.TrimRight(string(val.Name[:]), "\x00"),
+			Inode:      key.Ino,
+			Device:     fmt.Sprintf("%d:%d", major, minor),
+			Disk:       disk,
+			ReadBytes:  val.ReadBytes,
+			WriteBytes: val.WriteBytes,
+		})
+	}
+
+	top := make([]ProcessIOInfo, 0, len(processes))
+	for _, p := range processes {
+		top = append(top, *p)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].ReadBytes+top[i].WriteBytes > top[j].ReadBytes+top[j].WriteBytes
+	})
+
+	if len(top) > topProcess {
+		top = top[:topProcess]
+	}
+
+	for i := range top {
+		p := &top[i]
+
+		sort.Slice(p.Files, func(i, j int) bool {
+			return p.Files[i].ReadBytes+p.Files[i].WriteBytes > p.Files[j].ReadBytes+p.Files[j].WriteBytes
+		})
+
+		if len(p.Files) > topFiles {
+			p.Files = p.Files[:topFiles]
+		}
+
+		// replace the dentry name with the full path if the file is still opened.
+		openFiles := processOpenFiles(p.Pid)
+		for j := range p.Files {
+			f := &p.Files[j]
+
+			var major, minor uint32
+			_, _ = fmt.Sscanf(f.Device, "%d:%d", &major, &minor)
+			if path, ok := openFiles[fileID{dev: unix.Mkdev(major, minor), ino: f.Inode}]; ok {
+				f.Path = path
+			}
+		}
+	}
+
+	return top, nil
+}
+
+func ioScheduleStack(b bpf.BPF, stackID int64) (string, error) {
+	key := make([]byte, 4)
+	binary.NativeEndian.PutUint32(key, uint32(stackID))
+
+	val, err := b.ReadMap(b.MapIDByName("iotracing_stacks"), key)
+	if err != nil {
+		return "", err
+	}
+
+	addrs := make([]uint64, len(val)/8)
+	if err := binary.Read(bytes.NewReader(val), binary.NativeEndian, addrs); err != nil {
+		return "", err
+	}
+
+	stack := symbol.DumpKernelBackTrace(addrs, symbol.KsymbolStackMaxDepth)
+	return strings.Join(stack.BackTrace, "\n"), nil
+}
+
+// collectIOScheduleStacks reads the tasks which blocked in io_schedule longer
+// than the threshold, the same stacks of a process are merged.
+func collectIOScheduleStacks(ctx context.Context, b bpf.BPF, reader bpf.PerfEventReader, maxStacks int) []IOScheduleStack {
+	type stackKey struct {
+		tgid    uint32
+		stackID int64
+	}
+
+	cssToContainer, _ := pod.GetCSSToContainerID(ioTracingCSSSubsys)
+	containers, _ := pod.GetAllContainers()
+
+	index := make(map[stackKey]int)
+	stacks := []IOScheduleStack{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stacks
+		default:
+			var data ioSchedulePerfEvent
+
+			if err := reader.ReadInto(&data); err != nil {
+				return stacks
+			}
+
+			key := stackKey{tgid: data.Tgid, stackID: data.StackID}
+			latencyMs := data.Latency / uint64(time.Millisecond)
+
+			if i, ok := index[key]; ok {
+				stacks[i].Count++
+				if latencyMs > stacks[i].MaxLatencyMs {
+					stacks[i].MaxLatencyMs = latencyMs
+				}
+				continue
+			}
+
+			if len(stacks) >= maxStacks {
+				continue
+			}
+
+			stack, err := ioScheduleStack(b, data.StackID)
+			if err != nil {
+				log.Debugf("iotracing read stack %d: %v", data.StackID, err)
+				continue
+			}
+
+			s := IOScheduleStack{
+				Pid:          data.Tgid,
+				Comm:         strings.TrimRight(string(data.Comm[:]), "\x00"),
+				Count:        1,
+				MaxLatencyMs: latencyMs,
+				Stack:        stack,
+			}
+			s.ContainerID, s.ContainerHostname = containerByCSS(data.CPUCSS, cssToContainer, containers)
+
+			index[key] = len(stacks)
+			stacks = append(stacks, s)
+		}
+	}
+}
+
+func (t *ioTracing) captureIO(ctx context.Context, disks []DiskIOStat, threshold *IOTracingThreshold) (*IOTracingData, error) {
+	iotracingConf := conf.Get().Tracing.IOTracing
+
+	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), map[string]any{
+		"io_schedule_thresh": threshold.IOScheduleLatencyMs * uint64(time.Millisecond),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	childCtx, cancel := context.WithTimeout(ctx, time.Duration(iotracingConf.PeriodSecond)*time.Second)
+	defer cancel()
+
+	reader, err := b.AttachAndEventPipe(childCtx, "iotracing_events", 8192)
+	if err != nil {
+		return nil, fmt.Errorf("attach and event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	stacks := collectIOScheduleStacks(childCtx, b, reader, iotracingConf.MaxStackNumber)
+	if ctx.Err() != nil {
+		return nil, types.ErrExitByCancelCtx
+	}
+
+	top, err := dumpIOFileStats(b, disks, iotracingConf.TopProcessCount, iotracingConf.TopFilesPerProcess)
+	if err != nil {
+		return nil, fmt.Errorf("dump file stats: %w", err)
+	}
+
+	return &IOTracingData{
+		Threshold:    *threshold,
+		Disks:        disks,
+		TopProcesses: top,
+		IOStacks:     stacks,
+	}, nil
+}
+
+func (t *ioTracing) Start(ctx context.Context) error {
+	iotracingConf := conf.Get().Tracing.IOTracing
+
+	threshold := &IOTracingThreshold{
+		IOutil:              iotracingConf.IOutilThreshold,
+		IOwait:              iotracingConf.IOwaitThreshold,
+		ReadMBps:            iotracingConf.ReadThreshold,
+		WriteMBps:           iotracingConf.WriteThreshold,
+		IOScheduleLatencyMs: iotracingConf.IOScheduleThreshold,
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return types.ErrExitByCancelCtx
+		case <-time.After(time.Duration(iotracingConf.PeriodSecond) * time.Second):
+			disks, err := t.abnormalDisks(threshold)
+			if err != nil {
+				return err
+			}
+
+			if len(disks) == 0 {
+				continue
+			}
+
+			traceTime := time.Now()
+
+			log.Infof("start iotracing, abnormal disks: %v", disks)
+			data, err := t.captureIO(ctx, disks, threshold)
+			if err != nil {
+				return err
+			}
+
+			storage.Save("iotracing", "", traceTime, data)
+
+			// the capturing takes a period, restart the disk sampling.
+			t.disks = nil
+		}
+	}
+}
@@ -37,9 +37,10 @@ func init() {
 
 func newMemBurst() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &memBurstTracing{},
-		Internal:    10,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &memBurstTracing{},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.MemoryBurst"},
 	}, nil
 }
 
@@ -0,0 +1,444 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autotracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	collector "huatuo-bamai/core/metrics"
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/internal/cgroups"
+	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/internal/utils/parseutil"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/waitrate.c -o $BPF_DIR/waitrate.o
+
+func init() {
+	tracing.RegisterEventTracing("waitrate", newWaitrate,
+		capability.BTF(),
+		capability.Tracepoint("sched", "sched_switch"))
+}
+
+func newWaitrate() (*tracing.EventTracingAttr, error) {
+	cgroup, err := cgroups.NewCgroupManager()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracing.EventTracingAttr{
+		TracingData: &waitrateTracing{
+			cgroup: cgroup,
+			series: make(map[string]*waitrateRing),
+		},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.Waitrate"},
+	}, nil
+}
+
+const (
+	// the minimum samples before detecting the spike.
+	waitrateMinHistory = 12
+	// the wait_rate lower than this is never considered as contention.
+	waitrateMinimum = 1.0
+
+	waitrateCSSSubsys = "cpu"
+	waitrateHostName  = "host"
+)
+
+type waitrateTracing struct {
+	cgroup cgroups.Cgroup
+	series map[string]*waitrateRing
+}
+
+// waitrateRing is the ring buffer of the wait_rate samples.
+type waitrateRing struct {
+	data  []float64
+	start int
+	size  int
+}
+
+func newWaitrateRing(capacity int) *waitrateRing {
+	return &waitrateRing{data: make([]float64, capacity)}
+}
+
+func (r *waitrateRing) push(val float64) {
+	if r.size < len(r.data) {
+		r.data[(r.start+r.size)%len(r.data)] = val
+		r.size++
+		return
+	}
+
+	r.data[r.start] = val
+	r.start = (r.start + 1) % len(r.data)
+}
+
+// values returns the samples from the oldest to the latest.
+func (r *waitrateRing) values() []float64 {
+	vals := make([]float64, r.size)
+	for i := 0; i < r.size; i++ {
+		vals[i] = r.data[(r.start+i)%len(r.data)]
+	}
+
+	return vals
+}
+
+func (r *waitrateRing) full() bool {
+	return r.size == len(r.data)
+}
+
+func (r *waitrateRing) reset() {
+	r.start, r.size = 0, 0
+}
+
+type waitrateCSSKey struct {
+	CSS uint64
+	CPU uint32
+	Pad uint32
+}
+
+type waitrateCSSStat struct {
+	Runtime    uint64
+	NrSwitches uint64
+}
+
+// WaitrateTracingData is the full data structure.
+type WaitrateTracingData struct {
+	Reason     string              `json:"reason"`
+	Qos        string              `json:"qos"`
+	Waitrate   float64             `json:"waitrate"`
+	Average    float64             `json:"average"`
+	Slope      float64             `json:"slope"`
+	Threshold  float64             `json:"threshold"`
+	CPUs       []int               `json:"cpus"`
+	Duration   int                 `json:"duration"`
+	Contenders []WaitrateContender `json:"contenders"`
+}
+
+// WaitrateContender is the container which ran on the cpus of the contended
+// container, the host processes are merged into the hostname "host".
+type WaitrateContender struct {
+	ContainerID       string  `json:"container_id"`
+	ContainerHostname string  `json:"container_hostname"`
+	Qos               string  `json:"qos"`
+	RuntimeMs         uint64  `json:"runtime_ms"`
+	RuntimePercent    float64 `json:"runtime_percent"`
+	NrSwitches        uint64  `json:"nr_switches"`
+	CPUs              []int   `json:"cpus"`
+}
+
+type waitrateAbnormal struct {
+	container *pod.Container
+	data      *WaitrateTracingData
+}
+
+func waitrateAverage(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+
+	return sum / float64(len(vals))
+}
+
+// waitrateSlope returns the least squares slope of the samples, the unit is
+// the wait_rate per sample.
+func waitrateSlope(vals []float64) float64 {
+	n := float64(len(vals))
+	if n < 2 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range vals {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+// detect checks the spike and the slope of the wait_rate series with the
+// thresholds of the container qos level.
+func (w *waitrateTracing) detect(container *pod.Container, ring *waitrateRing) *WaitrateTracingData {
+	waitrateConf := conf.Get().Tracing.Waitrate
+	level := strconv.Itoa(container.Qos.Int())
+
+	vals := ring.values()
+	latest := vals[len(vals)-1]
+	if latest < waitrateMinimum {
+		return nil
+	}
+
+	history := vals[:len(vals)-1]
+	average := waitrateAverage(history)
+
+	// spike: the latest sample exceeds the history average by the threshold percent.
+	if threshold, ok := waitrateConf.SpikeThreshold[level]; ok &&
+		len(history) >= min(waitrateMinHistory, len(ring.data)-1) &&
+		latest > average*(1+threshold/100) {
+		return &WaitrateTracingData{
+			Reason:    "spike",
+			Qos:       container.Qos.String(),
+			Waitrate:  latest,
+			Average:   average,
+			Slope:     waitrateSlope(vals),
+			Threshold: threshold,
+		}
+	}
+
+	// slope: the wait_rate keeps increasing in the whole window.
+	if threshold, ok := waitrateConf.SlopeThreshold[level]; ok && ring.full() {
+		if slope := waitrateSlope(vals); slope > threshold {
+			return &WaitrateTracingData{
+				Reason:    "slope",
+				Qos:       container.Qos.String(),
+				Waitrate:  latest,
+				Average:   average,
+				Slope:     slope,
+				Threshold: threshold,
+			}
+		}
+	}
+
+	return nil
+}
+
+// sample pushes the wait_rate of the containers into the ring buffers, and
+// returns the most contended container.
+func (w *waitrateTracing) sample(capacity int) (*waitrateAbnormal, error) {
+	containers, err := pod.GetContainersByType(pod.ContainerTypeNormal | pod.ContainerTypeSidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range w.series {
+		if _, ok := containers[id]; !ok {
+			delete(w.series, id)
+		}
+	}
+
+	var abnormal *waitrateAbnormal
+	for id, container := range containers {
+		waitrate, err := collector.ContainerWaitRate(container)
+		if err != nil {
+			log.Debugf("failed to get wait_rate of %s: %v", container, err)
+			continue
+		}
+
+		ring, ok := w.series[id]
+		if !ok || len(ring.data) != capacity {
+			ring = newWaitrateRing(capacity)
+			w.series[id] = ring
+		}
+		ring.push(waitrate)
+
+		data := w.detect(container, ring)
+		if data == nil {
+			continue
+		}
+
+		if abnormal == nil || data.Waitrate > abnormal.data.Waitrate {
+			abnormal = &waitrateAbnormal{container: container, data: data}
+		}
+	}
+
+	return abnormal, nil
+}
+
+func (w *waitrateTracing) containerCPUs(container *pod.Container) ([]int, error) {
+	cpus, err := w.cgroup.CpusetCpus(container.CgroupSuffix)
+	if err == nil && len(cpus) > 0 {
+		return cpus, nil
+	}
+
+	// the container may run on all the online cpus.
+	return parseutil.ReadCPUList("/sys/devices/system/cpu/online")
+}
+
+// captureContenders records the runtime of the cgroups on the cpus of the
+// contended container.
+func (w *waitrateTracing) captureContenders(ctx context.Context, victim *pod.Container, cpus []int, duration int) ([]WaitrateContender, error) {
+	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	items := make([]bpf.MapItem, 0, len(cpus))
+	for _, cpu := range cpus {
+		key := make([]byte, 4)
+		val := make([]byte, 4)
+		binary.NativeEndian.PutUint32(key, uint32(cpu))
+		binary.NativeEndian.PutUint32(val, 1)
+		items = append(items, bpf.MapItem{Key: key, Value: val})
+	}
+
+	if err := b.WriteMapItems(b.MapIDByName("waitrate_target_cpus"), items); err != nil {
+		return nil, fmt.Errorf("write target cpus: %w", err)
+	}
+
+	if err := b.Attach(); err != nil {
+		return nil, fmt.Errorf("attach: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, types.ErrExitByCancelCtx
+	case <-time.After(time.Duration(duration) * time.Second):
+	}
+
+	stats, err := b.DumpMapByName("waitrate_cpu_css_stats")
+	if err != nil {
+		return nil, fmt.Errorf("dump cpu css stats: %w", err)
+	}
+
+	containers, err := pod.GetAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	cssToContainer, err := pod.GetCSSToContainerID(waitrateCSSSubsys)
+	if err != nil {
+		return nil, err
+	}
+
+	contenders := make(map[string]*WaitrateContender)
+	contenderCPUs := make(map[string]map[int]bool)
+	for _, item := range stats {
+		var key waitrateCSSKey
+		var val waitrateCSSStat
+
+		if err := binary.Read(bytes.NewReader(item.Key), binary.NativeEndian, &key); err != nil {
+			return nil, fmt.Errorf("parse cpu css key: %w", err)
+		}
+		if err := binary.Read(bytes.NewReader(item.Value), binary.NativeEndian, &val); err != nil {
+			return nil, fmt.Errorf("parse cpu css stat: %w", err)
+		}
+
+		id := cssToContainer[key.CSS]
+		if id == victim.ID {
+			continue
+		}
+
+		c, ok := contenders[id]
+		if !ok {
+			c = &WaitrateContender{ContainerID: id, ContainerHostname: waitrateHostName}
+			if container, ok := containers[id]; ok {
+				c.ContainerHostname = container.Hostname
+				c.Qos = container.Qos.String()
+			}
+
+			contenders[id] = c
+			contenderCPUs[id] = make(map[int]bool)
+		}
+
+		c.RuntimeMs += val.Runtime / uint64(time.Millisecond)
+		c.NrSwitches += val.NrSwitches
+		contenderCPUs[id][int(key.CPU)] = true
+	}
+
+	total := float64(duration) * float64(len(cpus)) * 1000
+	result := make([]WaitrateContender, 0, len(contenders))
+	for id, c := range contenders {
+		for cpu := range contenderCPUs[id] {
+			c.CPUs = append(c.CPUs, cpu)
+		}
+		sort.Ints(c.CPUs)
+
+		if total > 0 {
+			c.RuntimePercent = 100 * float64(c.RuntimeMs) / total
+		}
+
+		result = append(result, *c)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RuntimeMs > result[j].RuntimeMs
+	})
+
+	return result, nil
+}
+
+func (w *waitrateTracing) Start(ctx context.Context) error {
+	sampleConf := conf.Get().Tracing.Waitrate.SampleConfig
+	capacity := sampleConf["DataSetCapability"]
+	interval := sampleConf["SampleInterval"]
+	captureTime := sampleConf["OnceCaptureTime"]
+
+	if capacity < 2 || interval <= 0 || captureTime <= 0 {
+		return fmt.Errorf("invalid waitrate sample config: %v", sampleConf)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return types.ErrExitByCancelCtx
+		case <-time.After(time.Duration(interval) * time.Second):
+			abnormal, err := w.sample(capacity)
+			if err != nil {
+				return err
+			}
+
+			if abnormal == nil {
+				continue
+			}
+
+			traceTime := time.Now()
+			container, data := abnormal.container, abnormal.data
+
+			cpus, err := w.containerCPUs(container)
+			if err != nil {
+				return err
+			}
+
+			log.Infof("start waitrate tracing, %s %s: waitrate %.2f, average %.2f, slope %.4f",
+				container, data.Reason, data.Waitrate, data.Average, data.Slope)
+
+			contenders, err := w.captureContenders(ctx, container, cpus, captureTime)
+			if err != nil {
+				return err
+			}
+
+			data.CPUs = cpus
+			data.Duration = captureTime
+			data.Contenders = contenders
+			storage.Save("waitrate", container.ID, traceTime, data)
+
+			// start a new window, avoid tracing the same contention again.
+			w.series[container.ID].reset()
+		}
+	}
+}
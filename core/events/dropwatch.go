@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/storage"
@@ -67,8 +68,8 @@ var typeMap = map[uint8]string{
 
 type perfEventT struct {
 	TgidPid         uint64                              `json:"tgid_pid"`
-	Saddr           uint32                              `json:"saddr"`
-	Daddr           uint32                              `json:"daddr"`
+	Saddr           [net.IPv6len]byte                   `json:"saddr"`
+	Daddr           [net.IPv6len]byte                   `json:"daddr"`
 	Sport           uint16                              `json:"sport"`
 	Dport           uint16                              `json:"dport"`
 	Seq             uint32                              `json:"seq"`
@@ -80,6 +81,7 @@ type perfEventT struct {
 	SkMaxAckBacklog uint32                              `json:"sk_max_ack_backlog"`
 	State           uint8                               `json:"state"`
 	Type            uint8                               `json:"type"`
+	Family          uint8                               `json:"family"`
 	Comm            [bpf.TaskCommLen]byte               `json:"comm"`
 }
 
@@ -107,14 +109,17 @@ type dropWatchTracing struct{}
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/dropwatch.c -o $BPF_DIR/dropwatch.o
 
 func init() {
-	tracing.RegisterEventTracing(tracerName, newDropWatch)
+	tracing.RegisterEventTracing(tracerName, newDropWatch,
+		capability.BTF(),
+		capability.Tracepoint("skb", "kfree_skb"))
 }
 
 func newDropWatch() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &dropWatchTracing{},
-		Internal:    10,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &dropWatchTracing{},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.Dropwatch"},
 	}, nil
 }
 
@@ -164,8 +169,8 @@ func (c *dropWatchTracing) Start(ctx context.Context) error {
 
 func (c *dropWatchTracing) formatEvent(event *perfEventT) *DropWatchTracingData {
 	// hostname
-	saddr := netutil.InetNtop(event.Saddr).String()
-	daddr := netutil.InetNtop(event.Daddr).String()
+	saddr := netutil.InetAddr(event.Family, event.Saddr).String()
+	daddr := netutil.InetAddr(event.Family, event.Daddr).String()
 	srcHostname := "<nil>"
 	destHostname := "<nil>"
 	h, err := net.LookupAddr(saddr)
@@ -179,7 +184,7 @@ func (c *dropWatchTracing) formatEvent(event *perfEventT) *DropWatchTracingData
 	}
 
 	// stack
-	stacks := strings.Join(symbol.DumpKernelBackTrace(event.Stack[:], symbol.KsymbolStackMaxDepth).BackTrace, "\n")
+	stacks := strings.Join(symbol.DumpKernelBackTraceOffset(event.Stack[:], symbol.KsymbolStackMaxDepth).BackTrace, "\n")
 
 	// tracer data
 	data := &DropWatchTracingData{
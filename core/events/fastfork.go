@@ -0,0 +1,491 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+
+	"github.com/prometheus/procfs"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/fastfork.c -o $BPF_DIR/fastfork.o
+
+const (
+	fastforkProbeFork = iota
+	fastforkProbePtsep
+	fastforkProbeWaitptsep
+	fastforkProbeMax
+)
+
+const fastforkLatencyZones = 5
+
+// the upper bounds in seconds of the latency zones but the last one.
+var fastforkLatencyBuckets = []float64{0.0001, 0.001, 0.01, 0.1}
+
+var fastforkProbeNames = [fastforkProbeMax]string{"fork", "ptsep", "waitptsep"}
+
+// the processes which fork to persist the large memory, e.g. BGSAVE of redis.
+var redisLikeComms = []string{"redis-server", "keydb-server", "valkey-server"}
+
+type fastforkPerfEvent struct {
+	Latency      uint64
+	PtsepLatency uint64
+	NrPtsep      uint64
+	CPUCSS       uint64
+	TotalVM      uint64
+	ChildPid     int64
+	Pid          uint32
+	Tgid         uint32
+	Watched      uint32
+	Pad          uint32
+	Comm         [bpf.TaskCommLen]byte
+}
+
+// FastforkTracingData is the full data structure.
+type FastforkTracingData struct {
+	Pid               uint32 `json:"pid"`
+	Tgid              uint32 `json:"tgid"`
+	Comm              string `json:"comm"`
+	ChildPid          int64  `json:"child_pid"`
+	LatencyUs         uint64 `json:"latency_us"`
+	PtsepLatencyUs    uint64 `json:"ptsep_latency_us"`
+	NrPtsep           uint64 `json:"nr_ptsep"`
+	Threshold         uint64 `json:"threshold"`
+	TotalVMBytes      uint64 `json:"total_vm_bytes"`
+	RSSBytes          uint64 `json:"rss_bytes"`
+	RedisLike         bool   `json:"redis_like"`
+	ContainerID       string `json:"container_id"`
+	ContainerHostname string `json:"container_hostname"`
+}
+
+type redisForkInfo struct {
+	comm        string
+	containerID string
+	latencyUs   uint64
+	forkTime    time.Time
+}
+
+type fastforkLatencyHistogram struct {
+	counts [fastforkLatencyZones]uint64
+	sum    uint64 // ns
+}
+
+type fastforkTracing struct {
+	bpf       bpf.BPF
+	isRunning bool
+	mutex     sync.Mutex
+
+	// the slow forks since the tracer created, exported as the counters.
+	hostSlowForks      uint64
+	containerSlowForks map[string]uint64
+	redis              map[uint32]*redisForkInfo
+}
+
+func init() {
+	tracing.RegisterEventTracing("fastfork", newFastfork, capability.BTF())
+}
+
+func newFastfork() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &fastforkTracing{
+			containerSlowForks: make(map[string]uint64),
+			redis:              make(map[uint32]*redisForkInfo),
+		},
+		Internal:       10,
+		Flag:           tracing.FlagTracing | tracing.FlagMetric,
+		ConfigSections: []string{"Tracing.Fastfork"},
+	}, nil
+}
+
+func (f *fastforkTracing) Update() ([]*metric.Data, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.isRunning {
+		return nil, nil
+	}
+
+	histograms, err := f.latencyHistograms()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []*metric.Data{}
+	for probe, hist := range histograms {
+		buckets, count := metric.CumulativeBuckets(fastforkLatencyBuckets, hist.counts[:])
+		metrics = append(metrics, metric.NewHistogramData("latency_seconds", count, float64(hist.sum)/float64(time.Second), buckets,
+			"fork latency histogram", map[string]string{"probe": fastforkProbeNames[probe]}))
+	}
+
+	containers, err := pod.GetNormalContainers()
+	if err != nil {
+		return nil, fmt.Errorf("get normal container: %w", err)
+	}
+
+	metrics = append(metrics, metric.NewCounterData("host_slow_fork_total", float64(f.hostSlowForks), "host slow fork counter", nil))
+	for id, count := range f.containerSlowForks {
+		container, ok := containers[id]
+		if !ok {
+			// the container is gone, its counter is never exported again.
+			delete(f.containerSlowForks, id)
+			continue
+		}
+
+		metrics = append(metrics, metric.NewContainerCounterData(container, "slow_fork_total", float64(count), "containers slow fork counter", nil))
+	}
+
+	// the pids come and go, only the last fork of each comm in a container
+	// is exported.
+	type redisForkKey struct {
+		containerID string
+		comm        string
+	}
+	lastForks := make(map[redisForkKey]*redisForkInfo)
+	for _, info := range f.redis {
+		if info.forkTime.IsZero() {
+			continue
+		}
+
+		key := redisForkKey{containerID: info.containerID, comm: info.comm}
+		if last, ok := lastForks[key]; !ok || info.forkTime.After(last.forkTime) {
+			lastForks[key] = info
+		}
+	}
+
+	for _, info := range lastForks {
+		labels := map[string]string{"comm": info.comm}
+
+		if container, ok := containers[info.containerID]; ok {
+			metrics = append(metrics, metric.NewContainerGaugeData(container, "redis_fork_latency", float64(info.latencyUs), "the last fork latency (us) of redis process", labels))
+			continue
+		}
+
+		metrics = append(metrics, metric.NewGaugeData("redis_fork_latency", float64(info.latencyUs), "the last fork latency (us) of redis process", labels))
+	}
+
+	return metrics, nil
+}
+
+// latencyHistograms reads the latency zones and the latency sum of the probes.
+func (f *fastforkTracing) latencyHistograms() ([fastforkProbeMax]fastforkLatencyHistogram, error) {
+	var histograms [fastforkProbeMax]fastforkLatencyHistogram
+
+	items, err := f.bpf.DumpMapByName("fastfork_latency")
+	if err != nil {
+		return histograms, fmt.Errorf("dump map: %w", err)
+	}
+
+	for _, item := range items {
+		key, count, err := parseFastforkMapItem(item)
+		if err != nil {
+			return histograms, err
+		}
+
+		probe := key / fastforkLatencyZones
+		if probe >= fastforkProbeMax {
+			continue
+		}
+
+		histograms[probe].counts[key%fastforkLatencyZones] = count
+	}
+
+	items, err = f.bpf.DumpMapByName("fastfork_latency_sum")
+	if err != nil {
+		return histograms, fmt.Errorf("dump map: %w", err)
+	}
+
+	for _, item := range items {
+		probe, sum, err := parseFastforkMapItem(item)
+		if err != nil {
+			return histograms, err
+		}
+
+		if probe >= fastforkProbeMax {
+			continue
+		}
+
+		histograms[probe].sum = sum
+	}
+
+	return histograms, nil
+}
+
+func parseFastforkMapItem(item bpf.MapItem) (key uint32, value uint64, err error) {
+	if err := binary.Read(bytes.NewReader(item.Key), binary.NativeEndian, &key); err != nil {
+		return 0, 0, fmt.Errorf("read map key: %w", err)
+	}
+	if err := binary.Read(bytes.NewReader(item.Value), binary.NativeEndian, &value); err != nil {
+		return 0, 0, fmt.Errorf("read map value: %w", err)
+	}
+
+	return key, value, nil
+}
+
+func isRedisLike(comm string) bool {
+	for _, name := range redisLikeComms {
+		if comm == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateRedisProcesses writes the redis-like processes into the bpf map, the
+// forks of them are always recorded.
+func (f *fastforkTracing) updateRedisProcesses(b bpf.BPF) error {
+	procs, err := procfs.AllProcs()
+	if err != nil {
+		return err
+	}
+
+	alive := make(map[uint32]string)
+	for _, proc := range procs {
+		comm, err := proc.Comm()
+		if err != nil || !isRedisLike(comm) {
+			continue
+		}
+
+		alive[uint32(proc.PID)] = comm
+	}
+
+	mapID := b.MapIDByName("fastfork_watch_tgids")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var stale [][]byte
+	for pid := range f.redis {
+		if _, ok := alive[pid]; !ok {
+			key := make([]byte, 4)
+			binary.NativeEndian.PutUint32(key, pid)
+			stale = append(stale, key)
+			delete(f.redis, pid)
+		}
+	}
+
+	if len(stale) > 0 {
+		if err := b.DeleteMapItems(mapID, stale); err != nil {
+			log.Debugf("fastfork delete stale redis pids: %v", err)
+		}
+	}
+
+	var items []bpf.MapItem
+	for pid, comm := range alive {
+		if _, ok := f.redis[pid]; ok {
+			continue
+		}
+
+		key := make([]byte, 4)
+		val := make([]byte, 4)
+		binary.NativeEndian.PutUint32(key, pid)
+		binary.NativeEndian.PutUint32(val, 1)
+		items = append(items, bpf.MapItem{Key: key, Value: val})
+
+		f.redis[pid] = &redisForkInfo{comm: comm}
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	return b.WriteMapItems(mapID, items)
+}
+
+func fastforkAttachOptions() []bpf.AttachOption {
+	fastforkConf := conf.Get().Tracing.Fastfork
+	opts := []bpf.AttachOption{}
+
+	if fastforkConf.EnableForkProbe != 0 {
+		// kernel_clone is renamed from _do_fork since v5.10
+		forkSymbol := "kernel_clone"
+		if !symbol.KernelSymbolExists(forkSymbol) {
+			forkSymbol = "_do_fork"
+		}
+
+		opts = append(opts,
+			bpf.AttachOption{ProgramName: "kprobe_fork", Symbol: forkSymbol},
+			bpf.AttachOption{ProgramName: "kretprobe_fork", Symbol: forkSymbol})
+	}
+
+	if fastforkConf.EnablePtsepProbe != 0 && symbol.KernelSymbolExists("copy_page_range") {
+		opts = append(opts,
+			bpf.AttachOption{ProgramName: "kprobe_copy_page_range", Symbol: "copy_page_range"},
+			bpf.AttachOption{ProgramName: "kretprobe_copy_page_range", Symbol: "copy_page_range"})
+	}
+
+	// do_wp_page may be inlined in some kernels.
+	if fastforkConf.EnableWaitptsepProbe != 0 && symbol.KernelSymbolExists("do_wp_page") {
+		opts = append(opts,
+			bpf.AttachOption{ProgramName: "kprobe_do_wp_page", Symbol: "do_wp_page"},
+			bpf.AttachOption{ProgramName: "kretprobe_do_wp_page", Symbol: "do_wp_page"})
+	}
+
+	return opts
+}
+
+func processRSS(pid uint32) uint64 {
+	proc, err := procfs.NewProc(int(pid))
+	if err != nil {
+		return 0
+	}
+
+	stat, err := proc.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return uint64(stat.ResidentMemory())
+}
+
+func (f *fastforkTracing) handleEvent(data *fastforkPerfEvent, threshold uint64) {
+	caseData := &FastforkTracingData{
+		Pid:            data.Pid,
+		Tgid:           data.Tgid,
+		Comm:           strings.TrimRight(string(data.Comm[:]), "\x00"),
+		ChildPid:       data.ChildPid,
+		LatencyUs:      data.Latency / uint64(time.Microsecond),
+		PtsepLatencyUs: data.PtsepLatency / uint64(time.Microsecond),
+		NrPtsep:        data.NrPtsep,
+		Threshold:      threshold,
+		TotalVMBytes:   data.TotalVM * uint64(os.Getpagesize()),
+		RSSBytes:       processRSS(data.Tgid),
+		RedisLike:      data.Watched != 0,
+	}
+
+	if container, err := pod.GetContainerByCSS(data.CPUCSS, "cpu"); err == nil && container != nil {
+		caseData.ContainerID = container.ID
+		caseData.ContainerHostname = container.Hostname
+	}
+
+	f.mutex.Lock()
+	if info, ok := f.redis[data.Tgid]; ok {
+		info.latencyUs = caseData.LatencyUs
+		info.containerID = caseData.ContainerID
+		info.forkTime = time.Now()
+	}
+
+	slow := data.Latency >= threshold*uint64(time.Millisecond)
+	if slow {
+		if caseData.ContainerID == "" {
+			f.hostSlowForks++
+		} else {
+			f.containerSlowForks[caseData.ContainerID]++
+		}
+	}
+	f.mutex.Unlock()
+
+	// the fast forks of the redis-like processes are only exported as metrics.
+	if slow {
+		storage.Save("fastfork", caseData.ContainerID, time.Now(), caseData)
+	}
+}
+
+func (f *fastforkTracing) Start(ctx context.Context) error {
+	fastforkConf := conf.Get().Tracing.Fastfork
+
+	opts := fastforkAttachOptions()
+	if len(opts) == 0 {
+		return fmt.Errorf("all the fastfork probes are disabled")
+	}
+
+	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), map[string]any{
+		"slow_fork_thresh": fastforkConf.SlowForkThreshold * uint64(time.Millisecond),
+	})
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	if err := b.AttachWithOptions(opts); err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, err := b.EventPipeByName(childCtx, "fastfork_events", 8192)
+	if err != nil {
+		return fmt.Errorf("event pipe: %w", err)
+	}
+	defer reader.Close()
+
+	if err := f.updateRedisProcesses(b); err != nil {
+		log.Infof("fastfork update redis processes: %v", err)
+	}
+
+	f.mutex.Lock()
+	f.bpf = b
+	f.isRunning = true
+	f.mutex.Unlock()
+
+	defer func() {
+		f.mutex.Lock()
+		f.isRunning = false
+		f.mutex.Unlock()
+	}()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	go func() {
+		interval := time.Duration(fastforkConf.RedisInfoCollectionInterval) * time.Second
+		if interval == 0 {
+			return
+		}
+
+		for {
+			select {
+			case <-childCtx.Done():
+				return
+			case <-time.After(interval):
+				if err := f.updateRedisProcesses(b); err != nil {
+					log.Infof("fastfork update redis processes: %v", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		default:
+			var data fastforkPerfEvent
+
+			if err := reader.ReadInto(&data); err != nil {
+				return fmt.Errorf("read from perf event fail: %w", err)
+			}
+
+			f.handleEvent(&data, fastforkConf.SlowForkThreshold)
+		}
+	}
+}
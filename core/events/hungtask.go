@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/internal/utils/kmsgutil"
 	"huatuo-bamai/pkg/metric"
@@ -42,6 +43,7 @@ type hungTaskPerfEventData struct {
 type HungTaskTracerData struct {
 	Pid                   int32  `json:"pid"`
 	Comm                  string `json:"comm"`
+	Stack                 string `json:"stack"`
 	CPUsStack             string `json:"cpus_stack"`
 	BlockedProcessesStack string `json:"blocked_processes_stack"`
 }
@@ -54,12 +56,10 @@ type hungTaskTracing struct {
 
 func init() {
 	// Some OS distributions such as Fedora-42 may disable this feature.
-	hungTaskSysctl := "/proc/sys/kernel/hung_task_timeout_secs"
-	if _, err := os.Stat(hungTaskSysctl); err != nil {
-		return
-	}
-
-	tracing.RegisterEventTracing("hungtask", newHungTask)
+	tracing.RegisterEventTracing("hungtask", newHungTask,
+		capability.File("/proc/sys/kernel/hung_task_timeout_secs"),
+		capability.BTF(),
+		capability.Tracepoint("sched", "sched_process_hang"))
 }
 
 func newHungTask() (*tracing.EventTracingAttr, error) {
@@ -121,6 +121,13 @@ func (c *hungTaskTracing) Start(ctx context.Context) error {
 
 			c.nextAllowedTime = now.Add(c.bo.Duration())
 
+			// the kernel stack of the hung task, in the format of
+			// "name+0xoff/0xsize [module]" as the other events.
+			stack, err := hungTaskStack(data.Pid)
+			if err != nil {
+				stack = err.Error()
+			}
+
 			cpusBT, err := kmsgutil.GetAllCPUsBT()
 			if err != nil {
 				cpusBT = err.Error()
@@ -134,9 +141,28 @@ func (c *hungTaskTracing) Start(ctx context.Context) error {
 			storage.Save("hungtask", "", time.Now(), &HungTaskTracerData{
 				Pid:                   data.Pid,
 				Comm:                  strings.TrimRight(string(data.Comm[:]), "\x00"),
+				Stack:                 stack,
 				CPUsStack:             cpusBT,
 				BlockedProcessesStack: blockedProcessesBT,
 			})
 		}
 	}
 }
+
+// hungTaskStack reads the kernel stack of the task, e.g.
+// "[<0>] io_schedule+0x12/0x40", the addresses are hidden by the kernel.
+func hungTaskStack(pid int32) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stack", pid))
+	if err != nil {
+		return "", err
+	}
+
+	var frames []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if _, frame, ok := strings.Cut(line, "] "); ok {
+			frames = append(frames, frame)
+		}
+	}
+
+	return strings.Join(frames, "\n"), nil
+}
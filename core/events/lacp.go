@@ -23,10 +23,12 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
 
 	"github.com/vishvananda/netlink"
 )
@@ -39,11 +41,10 @@ type lacpTracing struct {
 func init() {
 	// bond mode4 (802.3ad) requires bonding.ko module,
 	// the kprobe point is in bonding module, if not exist, should not load bpf
-	if !isLacpEnv() {
-		return
-	}
-
-	tracing.RegisterEventTracing("lacp", newLACPTracing)
+	tracing.RegisterEventTracing("lacp", newLACPTracing,
+		capability.New("bond:802.3ad", lacpEnv),
+		capability.BTF(),
+		capability.Kprobe("ad_disable_collecting_distributing"))
 }
 
 func newLACPTracing() (*tracing.EventTracingAttr, error) {
@@ -129,18 +130,18 @@ func readAllFiles(dir string) (string, error) {
 	})
 }
 
-func isLacpEnv() bool {
+func lacpEnv() error {
 	links, err := netlink.LinkList()
 	if err != nil {
-		return false
+		return err
 	}
 
 	for _, l := range links {
 		if l.Type() == "bond" &&
 			l.(*netlink.Bond).Mode == netlink.BOND_MODE_802_3AD {
-			return true
+			return nil
 		}
 	}
 
-	return false
+	return fmt.Errorf("%w: no 802.3ad bond", types.ErrNotSupported)
 }
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/pod"
@@ -45,14 +46,17 @@ type MemoryReclaimTracingData struct {
 }
 
 func init() {
-	tracing.RegisterEventTracing("memory_reclaim_events", newMemoryReclaim)
+	tracing.RegisterEventTracing("memory_reclaim_events", newMemoryReclaim,
+		capability.BTF(),
+		capability.Kprobe("try_to_free_pages"))
 }
 
 func newMemoryReclaim() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &memoryReclaimTracing{},
-		Internal:    5,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &memoryReclaimTracing{},
+		Internal:       5,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.MemoryReclaim"},
 	}, nil
 }
 
@@ -105,8 +105,9 @@ func newNetdevTracing() (*tracing.EventTracingAttr, error) {
 			metricsLinkStatusCountMap: initMap,
 			name:                      "netdev_events",
 		},
-		Internal: 10,
-		Flag:     tracing.FlagTracing | tracing.FlagMetric,
+		Internal:       10,
+		Flag:           tracing.FlagTracing | tracing.FlagMetric,
+		ConfigSections: []string{"Tracing.Netdev"},
 	}, nil
 }
 
@@ -15,28 +15,48 @@
 package events
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"syscall"
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/pod"
 	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/internal/symbol"
 	"huatuo-bamai/internal/utils/netutil"
 	"huatuo-bamai/internal/utils/procfsutil"
+	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 
+	"github.com/tklauser/numcpus"
 	"golang.org/x/sys/unix"
 )
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/netrecvlat.c -o $BPF_DIR/netrecvlat.o
 
-type netRecvLatTracing struct{}
+type netRecvLatTracing struct {
+	bpf         bpf.BPF
+	isRunning   bool
+	cpuPossible int
+}
+
+// from bpf net_recv_lat_hist_map
+type netRecvLatHist struct {
+	Sum   uint64
+	Count [7]uint64
+}
+
+// the upper bounds of the latency zones in bpf, in seconds.
+var netRecvLatBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5}
 
 // NetTracingData is the full data structure.
 type NetTracingData struct {
@@ -62,12 +82,13 @@ type netRcvPerfEvent struct {
 	PktLen  uint64
 	Sport   uint16
 	Dport   uint16
-	Saddr   uint32
-	Daddr   uint32
 	Seq     uint32
 	AckSeq  uint32
+	Saddr   [net.IPv6len]byte
+	Daddr   [net.IPv6len]byte
 	State   uint8
 	Where   uint8
+	Family  uint8
 }
 
 // from include/net/tcp_states.h
@@ -93,29 +114,105 @@ var toWhere = []string{
 	"TO_NETIF_RCV",
 	"TO_TCPV4_RCV",
 	"TO_USER_COPY",
+	"TO_TCPV6_RCV",
 }
 
 func init() {
-	tracing.RegisterEventTracing("netrecvlat", newNetRcvLat)
+	tracing.RegisterEventTracing("netrecvlat", newNetRcvLat,
+		capability.BTF(),
+		capability.Tracepoint("net", "netif_receive_skb"),
+		capability.Kprobe("tcp_v4_rcv"),
+		capability.Optional(capability.Kprobe("tcp_v6_rcv")),
+		capability.Tracepoint("skb", "skb_copy_datagram_iovec"))
 }
 
 func newNetRcvLat() (*tracing.EventTracingAttr, error) {
+	cpuPossible, err := numcpus.GetPossible()
+	if err != nil {
+		return nil, fmt.Errorf("fetch possible cpu num: %w", err)
+	}
+
 	return &tracing.EventTracingAttr{
-		TracingData: &netRecvLatTracing{},
-		Internal:    10,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &netRecvLatTracing{cpuPossible: cpuPossible},
+		Internal:       10,
+		Flag:           tracing.FlagTracing | tracing.FlagMetric,
+		ConfigSections: []string{"Tracing.NetRecvLat"},
 	}, nil
 }
 
+// Update the latency histograms of the skbs.
+func (c *netRecvLatTracing) Update() ([]*metric.Data, error) {
+	if !c.isRunning {
+		return nil, nil
+	}
+
+	items, err := c.bpf.DumpMapByName("net_recv_lat_hist_map")
+	if err != nil {
+		return nil, fmt.Errorf("dump map: %w", err)
+	}
+
+	metricData := []*metric.Data{}
+	for _, item := range items {
+		var where uint32
+		histOnAllCPU := make([]netRecvLatHist, c.cpuPossible)
+
+		if err = binary.Read(bytes.NewReader(item.Key), binary.LittleEndian, &where); err != nil {
+			return nil, fmt.Errorf("read map key: %w", err)
+		}
+		if int(where) >= len(toWhere) {
+			continue
+		}
+
+		if err = binary.Read(bytes.NewReader(item.Value), binary.LittleEndian, &histOnAllCPU); err != nil {
+			return nil, fmt.Errorf("read map value: %w", err)
+		}
+
+		var hist netRecvLatHist
+		for _, h := range histOnAllCPU {
+			hist.Sum += h.Sum
+			for zone, count := range h.Count {
+				hist.Count[zone] += count
+			}
+		}
+
+		buckets, count := metric.CumulativeBuckets(netRecvLatBuckets, hist.Count[:])
+		metricData = append(metricData,
+			metric.NewHistogramData("latency_seconds", count, float64(hist.Sum)/float64(time.Second), buckets,
+				"tcp skb receive latency histogram", map[string]string{"where": toWhere[where]}))
+	}
+
+	return metricData, nil
+}
+
+// netRecvLatAttachOptions returns the programs to attach, tcp_v6_rcv is not
+// found if ipv6 is disabled or the module is not loaded.
+func netRecvLatAttachOptions() []bpf.AttachOption {
+	opts := []bpf.AttachOption{
+		{ProgramName: "netif_receive_skb_prog", Symbol: "net/netif_receive_skb"},
+		{ProgramName: "tcp_v4_rcv_prog", Symbol: "tcp_v4_rcv"},
+		{ProgramName: "skb_copy_datagram_iovec_prog", Symbol: "skb/skb_copy_datagram_iovec"},
+	}
+
+	if symbol.KernelSymbolExists("tcp_v6_rcv") {
+		opts = append(opts, bpf.AttachOption{ProgramName: "tcp_v6_rcv_prog", Symbol: "tcp_v6_rcv"})
+	}
+
+	return opts
+}
+
 func (c *netRecvLatTracing) Start(ctx context.Context) error {
 	toNetIf := conf.Get().Tracing.NetRecvLat.ToNetIf       // ms, before RPS to a core recv(__netif_receive_skb)
 	toTCPV4 := conf.Get().Tracing.NetRecvLat.ToTCPV4       // ms, before RPS to TCP recv(tcp_v4_rcv)
 	toUserCopy := conf.Get().Tracing.NetRecvLat.ToUserCopy // ms, before RPS to user recv(skb_copy_datagram_iovec)
+	toTCPV6 := conf.Get().Tracing.NetRecvLat.ToTCPV6       // ms, before RPS to TCP recv(tcp_v6_rcv)
+	if toTCPV6 == 0 {
+		toTCPV6 = toTCPV4
+	}
 
 	if toNetIf == 0 || toTCPV4 == 0 || toUserCopy == 0 {
 		return fmt.Errorf("netrecvlat threshold [%v %v %v]ms invalid", toNetIf, toTCPV4, toUserCopy)
 	}
-	log.Infof("netrecvlat start, latency threshold [%v %v %v]ms", toNetIf, toTCPV4, toUserCopy)
+	log.Infof("netrecvlat start, latency threshold [%v %v %v %v]ms", toNetIf, toTCPV4, toTCPV6, toUserCopy)
 
 	monoWallOffset, err := estMonoWallOffset()
 	if err != nil {
@@ -128,6 +225,7 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 		"mono_wall_offset": monoWallOffset,
 		"to_netif":         toNetIf * 1000 * 1000,
 		"to_tcpv4":         toTCPV4 * 1000 * 1000,
+		"to_tcpv6":         toTCPV6 * 1000 * 1000,
 		"to_user_copy":     toUserCopy * 1000 * 1000,
 	}
 	b, err := bpf.LoadBpf(bpf.ThisBpfOBJ(), args)
@@ -139,7 +237,11 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 	childCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	reader, err := b.AttachAndEventPipe(childCtx, "net_recv_lat_event_map", 8192)
+	if err := b.AttachWithOptions(netRecvLatAttachOptions()); err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+
+	reader, err := b.EventPipeByName(childCtx, "net_recv_lat_event_map", 8192)
 	if err != nil {
 		return err
 	}
@@ -147,6 +249,10 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 
 	b.WaitDetachByBreaker(childCtx, cancel)
 
+	c.bpf = b
+	c.isRunning = true
+	defer func() { c.isRunning = false }()
+
 	// save host netns
 	hostNetNsInode, err := procfsutil.NetNSInodeByPid(1)
 	if err != nil {
@@ -187,7 +293,7 @@ func (c *netRecvLatTracing) Start(ctx context.Context) error {
 			where := toWhere[pd.Where]
 			lat := pd.Latency / 1000 / 1000 // ms
 			state := tcpStateMap[pd.State]
-			saddr, daddr := netutil.InetNtop(pd.Saddr).String(), netutil.InetNtop(pd.Daddr).String()
+			saddr, daddr := netutil.InetAddr(pd.Family, pd.Saddr).String(), netutil.InetAddr(pd.Family, pd.Daddr).String()
 			sport, dport := netutil.InetNtohs(pd.Sport), netutil.InetNtohs(pd.Dport)
 			seq, ackSeq := netutil.InetNtohl(pd.Seq), netutil.InetNtohl(pd.AckSeq)
 			pktLen := pd.PktLen
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/pod"
 	"huatuo-bamai/internal/storage"
@@ -62,7 +63,9 @@ type oomMetric struct {
 type oomCollector struct{}
 
 func init() {
-	tracing.RegisterEventTracing("oom", newOOMCollector)
+	tracing.RegisterEventTracing("oom", newOOMCollector,
+		capability.BTF(),
+		capability.Kprobe("oom_kill_process"))
 }
 
 func newOOMCollector() (*tracing.EventTracingAttr, error) {
@@ -0,0 +1,206 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/pod"
+	"huatuo-bamai/internal/profile"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/pkg/tracing"
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+)
+
+//go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/perf.c -o $BPF_DIR/perf.o
+
+const profilingBpfObj = "perf.o"
+
+type profilingTracing struct{}
+
+func init() {
+	tracing.RegisterEventTracing("profiling", newProfiling,
+		capability.BTF(),
+		capability.ProgramType(ebpf.PerfEvent))
+}
+
+func newProfiling() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData:    &profilingTracing{},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.Profiling"},
+	}, nil
+}
+
+// Start samples the stacks of the host, and pushes the profiles of the host
+// and containers at each interval.
+func (c *profilingTracing) Start(ctx context.Context) error {
+	profilingConf := conf.Get().Tracing.Profiling
+	if profilingConf.ServerURL == "" {
+		return fmt.Errorf("%w: Tracing.Profiling.ServerURL is empty", types.ErrNotSupported)
+	}
+
+	if profilingConf.SampleRate == 0 || profilingConf.UploadInterval <= 0 {
+		return fmt.Errorf("invalid Tracing.Profiling.SampleRate %d or UploadInterval %d, must be > 0",
+			profilingConf.SampleRate, profilingConf.UploadInterval)
+	}
+
+	pusher, err := profile.NewPusher(profilingConf.ServerURL, profilingConf.AuthToken,
+		time.Duration(profilingConf.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	consts, mapOpts, err := (&profile.LoadOption{}).Consts()
+	if err != nil {
+		return err
+	}
+
+	b, err := bpf.LoadBpf(profilingBpfObj, consts, mapOpts...)
+	if err != nil {
+		return fmt.Errorf("load bpf: %w", err)
+	}
+	defer b.Close()
+
+	opt := bpf.AttachOption{ProgramName: "perf_event_sw_cpu_clock"}
+	opt.PerfEvent.SampleFreq = profilingConf.SampleRate
+	if err := b.AttachWithOptions([]bpf.AttachOption{opt}); err != nil {
+		return fmt.Errorf("attach: %w", err)
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b.WaitDetachByBreaker(childCtx, cancel)
+
+	hostname, _ := os.Hostname()
+	p := &profilingPusher{
+		pusher:     pusher,
+		appName:    profilingConf.AppName,
+		sampleRate: profilingConf.SampleRate,
+		labels:     map[string]string{"hostname": hostname, "region": conf.Region},
+	}
+
+	ticker := time.NewTicker(time.Duration(profilingConf.UploadInterval) * time.Second)
+	defer ticker.Stop()
+
+	// the symbol caches are bounded, and shared by all the intervals.
+	usym := symbol.NewUsym()
+	from := time.Now()
+	for {
+		select {
+		case <-childCtx.Done():
+			return nil
+		case until := <-ticker.C:
+			if err := p.push(childCtx, b, usym, from, until); err != nil {
+				log.Warnf("push profiles: %v", err)
+			}
+			from = until
+		}
+	}
+}
+
+type profilingPusher struct {
+	pusher     *profile.Pusher
+	appName    string
+	sampleRate uint64
+	labels     map[string]string
+}
+
+// push pushes the samples of each container with the container labels, and
+// the others of the host with the host labels only, so each sample is pushed
+// once, and the profile of the whole host is the sum of them.
+func (p *profilingPusher) push(ctx context.Context, b bpf.BPF, usym *symbol.Usym, from, until time.Time) error {
+	stacks, err := profile.ReadStackCounts(b, true)
+	if err != nil {
+		return fmt.Errorf("read stacks: %w", err)
+	}
+
+	if len(stacks) == 0 {
+		return nil
+	}
+
+	containers, err := pod.GetAllContainers()
+	if err != nil {
+		log.Warnf("get containers: %v", err)
+	}
+
+	cssToContainer := make(map[uint64]*pod.Container, len(containers))
+	for _, container := range containers {
+		if css, ok := container.CSS["cpu"]; ok {
+			cssToContainer[css] = container
+		}
+	}
+
+	hostStacks := []profile.StackCount{}
+	containerStacks := make(map[*pod.Container][]profile.StackCount)
+	for _, stack := range stacks {
+		if container, ok := cssToContainer[stack.Key.CSS]; ok {
+			containerStacks[container] = append(containerStacks[container], stack)
+		} else {
+			hostStacks = append(hostStacks, stack)
+		}
+	}
+
+	var errs []error
+	if len(hostStacks) > 0 {
+		errs = append(errs, p.pushProfile(ctx, hostStacks, p.labels, usym, from, until))
+	}
+
+	for container, stacks := range containerStacks {
+		labels := maps.Clone(p.labels)
+		labels["container_id"] = container.ID
+		labels["container_name"] = container.Name
+		labels["container_hostname"] = container.Hostname
+		labels["container_namespace"] = container.LabelHostNamespace()
+		labels["container_type"] = container.Type.String()
+		labels["container_qos"] = container.Qos.String()
+
+		errs = append(errs, p.pushProfile(ctx, stacks, labels, usym, from, until))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *profilingPusher) pushProfile(ctx context.Context, stacks []profile.StackCount, labels map[string]string,
+	usym *symbol.Usym, from, until time.Time,
+) error {
+	var folded bytes.Buffer
+	if err := profile.WriteFolded(&folded, stacks, usym); err != nil {
+		return err
+	}
+
+	return p.pusher.Push(ctx, &profile.Profile{
+		Name:       p.appName,
+		Labels:     labels,
+		From:       from,
+		Until:      until,
+		SampleRate: p.sampleRate,
+		Folded:     folded.Bytes(),
+	})
+}
@@ -16,18 +16,16 @@ package events
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/internal/symbol"
 	"huatuo-bamai/pkg/tracing"
-	"huatuo-bamai/pkg/types"
 )
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/softirq_tracing.c -o $BPF_DIR/softirq_tracing.o
@@ -56,14 +54,19 @@ type SoftirqTracingData struct {
 }
 
 func init() {
-	tracing.RegisterEventTracing("softirq_tracing", newSoftirq)
+	tracing.RegisterEventTracing("softirq_tracing", newSoftirq,
+		capability.BTF(),
+		capability.Kprobe("account_process_tick"),
+		capability.Kprobe("tick_nohz_restart_sched_tick"),
+		capability.Tracepoint("timer", "tick_stop"))
 }
 
 func newSoftirq() (*tracing.EventTracingAttr, error) {
 	return &tracing.EventTracingAttr{
-		TracingData: &softirqTracing{},
-		Internal:    10,
-		Flag:        tracing.FlagTracing,
+		TracingData:    &softirqTracing{},
+		Internal:       10,
+		Flag:           tracing.FlagTracing,
+		ConfigSections: []string{"Tracing.Softirq"},
 	}, nil
 }
 
@@ -81,10 +84,6 @@ func (c *softirqTracing) Start(ctx context.Context) error {
 
 	reader, err := attachIrqAndEventPipe(childCtx, b)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return types.ErrNotSupported
-		}
-
 		return fmt.Errorf("attach irq and event pipe: %w", err)
 	}
 	defer reader.Close()
@@ -136,7 +135,7 @@ func (c *softirqTracing) Start(ctx context.Context) error {
 
 // softirqDumpTrace is an interface for dump stacks in this case with offset and module info
 func softirqDumpTrace(addrs []uint64) string {
-	stacks := symbol.DumpKernelBackTrace(addrs, symbol.KsymbolStackMaxDepth)
+	stacks := symbol.DumpKernelBackTraceOffset(addrs, symbol.KsymbolStackMaxDepth)
 	return strings.Join(stacks.BackTrace, "\n")
 }
 
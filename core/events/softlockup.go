@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/internal/utils/kmsgutil"
 	"huatuo-bamai/pkg/metric"
@@ -49,7 +50,9 @@ type softLockupTracing struct {
 }
 
 func init() {
-	tracing.RegisterEventTracing("softlockup", newSoftLockup)
+	tracing.RegisterEventTracing("softlockup", newSoftLockup,
+		capability.BTF(),
+		capability.Kprobe("add_taint"))
 }
 
 func newSoftLockup() (*tracing.EventTracingAttr, error) {
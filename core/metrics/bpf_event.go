@@ -0,0 +1,51 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+)
+
+type bpfEventCollector struct{}
+
+func init() {
+	tracing.RegisterEventTracing("bpf_event", newBpfEventCollector)
+}
+
+func newBpfEventCollector() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &bpfEventCollector{},
+		Flag:        tracing.FlagMetric,
+	}, nil
+}
+
+func (c *bpfEventCollector) Update() ([]*metric.Data, error) {
+	metrics := []*metric.Data{}
+	for _, stat := range bpf.EventLostSamplesList() {
+		metrics = append(metrics,
+			metric.NewCounterData("lost_samples_total", float64(stat.Lost),
+				"samples dropped as the perf event buffer is full",
+				map[string]string{
+					"bpf": strings.TrimSuffix(stat.BpfName, ".o"),
+					"map": stat.MapName,
+				}))
+	}
+
+	return metrics, nil
+}
@@ -15,6 +15,7 @@
 package collector
 
 import (
+	"fmt"
 	"reflect"
 	"sync"
 	"time"
@@ -50,25 +51,72 @@ type cpuStatCollector struct {
 	mutex  sync.Mutex
 }
 
+var (
+	cpuStatCollectorOnce     sync.Once
+	cpuStatCollectorInstance *cpuStatCollector
+	cpuStatCollectorErr      error
+)
+
 func init() {
 	tracing.RegisterEventTracing("cpu_stat", newCPUStat)
 	_ = pod.RegisterContainerLifeResources("collector_cpu_stat", reflect.TypeOf(&cpuStat{}))
+	// the delta window of ContainerWaitRate, apart from the metrics.
+	_ = pod.RegisterContainerLifeResources("collector_cpu_stat_waitrate", reflect.TypeOf(&cpuStat{}))
+}
+
+// sharedCPUStatCollector returns the collector shared by the cpu_stat metrics
+// and the other users of the wait rate, the container stats are updated by
+// one instance.
+func sharedCPUStatCollector() (*cpuStatCollector, error) {
+	cpuStatCollectorOnce.Do(func() {
+		cgroup, err := cgroups.NewCgroupManager()
+		if err != nil {
+			cpuStatCollectorErr = err
+			return
+		}
+
+		cpuStatCollectorInstance = &cpuStatCollector{cgroup: cgroup}
+	})
+
+	return cpuStatCollectorInstance, cpuStatCollectorErr
 }
 
 func newCPUStat() (*tracing.EventTracingAttr, error) {
-	cgroup, err := cgroups.NewCgroupManager()
+	collector, err := sharedCPUStatCollector()
 	if err != nil {
 		return nil, err
 	}
 
 	return &tracing.EventTracingAttr{
-		TracingData: &cpuStatCollector{
-			cgroup: cgroup,
-		},
-		Flag: tracing.FlagMetric,
+		TracingData: collector,
+		Flag:        tracing.FlagMetric,
 	}, nil
 }
 
+// ContainerWaitRate returns the wait_rate of the container since the last
+// call, which is calculated as the cpu_stat wait_rate metric, but in its own
+// delta window, so the metric is not affected by the callers.
+func ContainerWaitRate(container *pod.Container) (float64, error) {
+	collector, err := sharedCPUStatCollector()
+	if err != nil {
+		return 0, err
+	}
+
+	cpu, ok := container.LifeResouces("collector_cpu_stat_waitrate").(*cpuStat)
+	if !ok {
+		return 0, fmt.Errorf("no cpu stat of %s", container)
+	}
+
+	if err := collector.cpuMetricUpdate(cpu, container); err != nil {
+		return 0, err
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	return cpu.waitrateHierarchy, nil
+}
+
 func (c *cpuStatCollector) cpuMetricUpdate(cpu *cpuStat, container *pod.Container) error {
 	var (
 		deltaThrottledSum     uint64
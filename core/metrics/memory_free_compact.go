@@ -21,12 +21,17 @@ import (
 	"fmt"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 )
 
 func init() {
-	tracing.RegisterEventTracing("memory_free", newMemoryHost)
+	tracing.RegisterEventTracing("memory_free", newMemoryHost,
+		capability.BTF(),
+		capability.Tracepoint("vmscan", "mm_vmscan_direct_reclaim_begin"),
+		capability.Tracepoint("vmscan", "mm_vmscan_direct_reclaim_end"),
+		capability.Kprobe("try_to_compact_pages"))
 }
 
 func newMemoryHost() (*tracing.EventTracingAttr, error) {
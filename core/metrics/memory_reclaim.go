@@ -21,13 +21,17 @@ import (
 	"fmt"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/pod"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 )
 
 func init() {
-	tracing.RegisterEventTracing("memory_reclaim", newMemoryCgroup)
+	tracing.RegisterEventTracing("memory_reclaim", newMemoryCgroup,
+		capability.BTF(),
+		capability.Tracepoint("vmscan", "mm_vmscan_memcg_reclaim_begin"),
+		capability.Kprobe("mem_cgroup_css_released"))
 }
 
 func newMemoryCgroup() (*tracing.EventTracingAttr, error) {
@@ -24,6 +24,7 @@ import (
 	"slices"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/utils/parseutil"
@@ -47,7 +48,9 @@ type netdevHw struct {
 
 //go:generate $BPF_COMPILE $BPF_INCLUDE -s $BPF_DIR/netdev_hw.c -o $BPF_DIR/netdev_hw.o
 func init() {
-	tracing.RegisterEventTracing("netdev_hw", newNetdevHw)
+	tracing.RegisterEventTracing("netdev_hw", newNetdevHw,
+		capability.BTF(),
+		capability.Kprobe("carrier_down_count_show"))
 }
 
 func newNetdevHw() (*tracing.EventTracingAttr, error) {
@@ -16,33 +16,41 @@ package collector
 
 import (
 	"reflect"
+	"time"
 
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/internal/pod"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 )
 
-type runqlatCollector struct {
-	runqlatMetric []*metric.Data
+// the upper bounds of the nlat_01..03 in bpf, in seconds.
+var runqlatBuckets = []float64{0.01, 0.02, 0.05}
+
+func runqlatHistogram(data *latencyBpfData) (buckets map[float64]uint64, count uint64, sum float64) {
+	buckets, count = metric.CumulativeBuckets(runqlatBuckets, []uint64{
+		data.NumLatency01, data.NumLatency02, data.NumLatency03, data.NumLatency04,
+	})
+	return buckets, count, float64(data.LatencySum) / float64(time.Second)
 }
 
+type runqlatCollector struct{}
+
 func init() {
 	_ = pod.RegisterContainerLifeResources("runqlat", reflect.TypeOf(&latencyBpfData{}))
-	tracing.RegisterEventTracing("runqlat", newRunqlatCollector)
+	tracing.RegisterEventTracing("runqlat", newRunqlatCollector,
+		capability.BTF(),
+		capability.Tracepoint("sched", "sched_wakeup"),
+		capability.Tracepoint("sched", "sched_wakeup_new"),
+		capability.Tracepoint("sched", "sched_switch"),
+		capability.Tracepoint("sched", "sched_process_exit"),
+		capability.Kprobe("free_fair_sched_group"),
+		capability.Kprobe("destroy_pid_namespace"))
 }
 
 func newRunqlatCollector() (*tracing.EventTracingAttr, error) {
-	collector := &runqlatCollector{
-		runqlatMetric: []*metric.Data{
-			metric.NewGaugeData("g_nlat_01", 0, "nlat_01 of host", nil),
-			metric.NewGaugeData("g_nlat_02", 0, "nlat_02 of host", nil),
-			metric.NewGaugeData("g_nlat_03", 0, "nlat_03 of host", nil),
-			metric.NewGaugeData("g_nlat_04", 0, "nlat_04 of host", nil),
-		},
-	}
-
 	return &tracing.EventTracingAttr{
-		TracingData: collector,
+		TracingData: &runqlatCollector{},
 		Internal:    10,
 		Flag:        tracing.FlagTracing | tracing.FlagMetric,
 	}, nil
@@ -63,19 +71,14 @@ func (c *runqlatCollector) Update() ([]*metric.Data, error) {
 	for _, container := range containers {
 		metrics := container.LifeResouces("runqlat").(*latencyBpfData)
 
+		buckets, count, sum := runqlatHistogram(metrics)
 		runqlatMetric = append(runqlatMetric,
-			metric.NewContainerGaugeData(container, "nlat_01", float64(metrics.NumLatency01), "nlat_01", nil),
-			metric.NewContainerGaugeData(container, "nlat_02", float64(metrics.NumLatency02), "nlat_02", nil),
-			metric.NewContainerGaugeData(container, "nlat_03", float64(metrics.NumLatency03), "nlat_03", nil),
-			metric.NewContainerGaugeData(container, "nlat_04", float64(metrics.NumLatency04), "nlat_04", nil))
+			metric.NewContainerHistogramData(container, "latency_seconds", count, sum, buckets, "run queue latency histogram", nil))
 	}
 
-	c.runqlatMetric[0].Value = float64(globalRunqlat.NumLatency01)
-	c.runqlatMetric[1].Value = float64(globalRunqlat.NumLatency02)
-	c.runqlatMetric[2].Value = float64(globalRunqlat.NumLatency03)
-	c.runqlatMetric[3].Value = float64(globalRunqlat.NumLatency04)
-
-	runqlatMetric = append(runqlatMetric, c.runqlatMetric...)
+	buckets, count, sum := runqlatHistogram(&globalRunqlat)
+	runqlatMetric = append(runqlatMetric,
+		metric.NewHistogramData("latency_seconds", count, sum, buckets, "run queue latency histogram of host", nil))
 
 	return runqlatMetric, nil
 }
@@ -35,6 +35,7 @@ type latencyBpfData struct {
 	NumLatency02         uint64
 	NumLatency03         uint64
 	NumLatency04         uint64
+	LatencySum           uint64
 }
 
 var (
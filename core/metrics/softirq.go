@@ -19,9 +19,10 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"strconv"
+	"time"
 
 	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/capability"
 	"huatuo-bamai/pkg/metric"
 	"huatuo-bamai/pkg/tracing"
 
@@ -29,7 +30,10 @@ import (
 )
 
 func init() {
-	tracing.RegisterEventTracing("softirq", newSoftirq)
+	tracing.RegisterEventTracing("softirq", newSoftirq,
+		capability.BTF(),
+		capability.Tracepoint("irq", "softirq_raise"),
+		capability.Tracepoint("irq", "softirq_entry"))
 }
 
 func newSoftirq() (*tracing.EventTracingAttr, error) {
@@ -65,10 +69,14 @@ type softirqLatency struct {
 }
 
 type softirqLatencyData struct {
-	Timestamp    uint64
-	TotalLatency [4]uint64
+	Timestamp       uint64
+	TotalLatency    [4]uint64
+	TotalLatencySum uint64
 }
 
+// the upper bounds of the latency zones in bpf, in seconds.
+var softirqLatencyBuckets = []float64{0.00001, 0.0001, 0.001}
+
 const (
 	softirqHi = iota
 	softirqTime
@@ -129,7 +137,6 @@ func (s *softirqLatency) Update() ([]*metric.Data, error) {
 		return nil, fmt.Errorf("dump map: %w", err)
 	}
 
-	labels := make(map[string]string)
 	metricData := []*metric.Data{}
 
 	// IRQ: 0 ... NR_SOFTIRQS_MAX
@@ -149,18 +156,25 @@ func (s *softirqLatency) Update() ([]*metric.Data, error) {
 			return nil, fmt.Errorf("read map value: %w", err)
 		}
 
-		labels["type"] = irqTypeName(int(irqVector))
+		var (
+			zoneCounts [4]uint64
+			latencySum uint64
+		)
 
 		for cpuid, lat := range latencyOnAllCPU {
 			if cpuid >= s.cpuOnline {
 				break
 			}
-			labels["cpuid"] = strconv.Itoa(cpuid)
 			for zoneid, zone := range lat.TotalLatency {
-				labels["zone"] = strconv.Itoa(zoneid)
-				metricData = append(metricData, metric.NewGaugeData("latency", float64(zone), "softirq latency", labels))
+				zoneCounts[zoneid] += zone
 			}
+			latencySum += lat.TotalLatencySum
 		}
+
+		buckets, count := metric.CumulativeBuckets(softirqLatencyBuckets, zoneCounts[:])
+		metricData = append(metricData,
+			metric.NewHistogramData("latency_seconds", count, float64(latencySum)/float64(time.Second), buckets,
+				"softirq latency histogram", map[string]string{"type": irqTypeName(int(irqVector))}))
 	}
 
 	return metricData, nil
@@ -0,0 +1,49 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"huatuo-bamai/internal/storage"
+	"huatuo-bamai/pkg/metric"
+	"huatuo-bamai/pkg/tracing"
+)
+
+type storageCollector struct{}
+
+func init() {
+	tracing.RegisterEventTracing("storage", newStorageCollector)
+}
+
+func newStorageCollector() (*tracing.EventTracingAttr, error) {
+	return &tracing.EventTracingAttr{
+		TracingData: &storageCollector{},
+		Flag:        tracing.FlagMetric,
+	}, nil
+}
+
+func (c *storageCollector) Update() ([]*metric.Data, error) {
+	stats, ok := storage.ESWriterStats()
+	if !ok {
+		return nil, nil
+	}
+
+	return []*metric.Data{
+		metric.NewGaugeData("es_queued", float64(stats.Queued), "documents queued in memory", nil),
+		metric.NewGaugeData("es_spilled", float64(stats.Spilled), "documents spilled to disk", nil),
+		metric.NewCounterData("es_sent_total", float64(stats.Sent), "documents indexed into elasticsearch", nil),
+		metric.NewCounterData("es_dropped_total", float64(stats.Dropped), "documents dropped as the queue and spill files are full", nil),
+		metric.NewCounterData("es_failed_total", float64(stats.Failed), "documents rejected by elasticsearch or failed to spill", nil),
+	}, nil
+}
@@ -33,7 +33,15 @@ type Option struct {
 //	CloseBpfManager()
 //
 //	// LoadBpf the bpf and return the bpf.
-//	LoadBpf(objName string, consts map[string]any) (BPF, error)
+//	LoadBpf(objName string, consts map[string]any, mapOpts ...MapOption) (BPF, error)
+
+// MapOption resizes a map before loading, e.g. the stack trace map sized by
+// the stack depth. The zero fields are unchanged.
+type MapOption struct {
+	Name       string
+	ValueSize  uint32
+	MaxEntries uint32
+}
 
 // AttachOption is an option for attaching a program.
 type AttachOption struct {
@@ -75,23 +75,23 @@ type defaultBPF struct {
 var _ BPF = (*defaultBPF)(nil)
 
 // LoadBpfFromBytes loads the bpf from bytes.
-func LoadBpfFromBytes(bpfName string, bpfBytes []byte, consts map[string]any) (BPF, error) {
-	return loadBpfFromReader(bpfName, bytes.NewReader(bpfBytes), consts)
+func LoadBpfFromBytes(bpfName string, bpfBytes []byte, consts map[string]any, mapOpts ...MapOption) (BPF, error) {
+	return loadBpfFromReader(bpfName, bytes.NewReader(bpfBytes), consts, mapOpts)
 }
 
 // LoadBpf the bpf and return the bpf.
-func LoadBpf(bpfName string, consts map[string]any) (BPF, error) {
+func LoadBpf(bpfName string, consts map[string]any, mapOpts ...MapOption) (BPF, error) {
 	f, err := os.Open(filepath.Join(DefaultBpfObjDir, bpfName))
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return loadBpfFromReader(bpfName, f, consts)
+	return loadBpfFromReader(bpfName, f, consts, mapOpts)
 }
 
 // loadBpfFromReader loads the bpf from reader.
-func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (BPF, error) {
+func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any, mapOpts []MapOption) (BPF, error) {
 	specs, err := ebpf.LoadCollectionSpecFromReader(rd)
 	if err != nil {
 		return nil, fmt.Errorf("can't parse the bpf file %s: %w", bpfName, err)
@@ -104,6 +104,20 @@ func loadBpfFromReader(bpfName string, rd io.ReaderAt, consts map[string]any) (B
 		}
 	}
 
+	for _, opt := range mapOpts {
+		spec, ok := specs.Maps[opt.Name]
+		if !ok {
+			return nil, fmt.Errorf("can't resize the map %s: not found", opt.Name)
+		}
+
+		if opt.ValueSize != 0 {
+			spec.ValueSize = opt.ValueSize
+		}
+		if opt.MaxEntries != 0 {
+			spec.MaxEntries = opt.MaxEntries
+		}
+	}
+
 	// loads Maps and Programs into the kernel.
 	coll, err := ebpf.NewCollection(specs)
 	if err != nil {
@@ -455,20 +469,23 @@ func (b *defaultBPF) attachPerfEvent(progID uint32, samplePeriod, sampleFreq uin
 		return fmt.Errorf("bpf %s duplicated symbol: %s", b, perfEventPmuSysbmol)
 	}
 
+	// the period takes precedence over the frequency
+	opt := &perfEventPMUOption{
+		samplePeriodFreq: sampleFreq,
+		sampleType:       sampleTypeFreq,
+	}
 	if samplePeriod != 0 {
-		return types.ErrNotSupported
+		opt.samplePeriodFreq = samplePeriod
+		opt.sampleType = sampleTypePeriod
 	}
 
-	if sampleFreq == 0 {
+	if opt.samplePeriodFreq == 0 {
 		return types.ErrArgsInvalid
 	}
 
 	spec := b.programSpecs[progID]
-	event, err := attachPerfEventPMU(&perfEventPMUOption{
-		samplePeriodFreq: sampleFreq,
-		sampleType:       sampleTypeFreq,
-		program:          spec.bProg,
-	})
+	opt.program = spec.bProg
+	event, err := attachPerfEventPMU(opt)
 	if err != nil {
 		return fmt.Errorf("attach bpf perfevent PERF_COUNT_SW_CPU_CLOCK: %w", err)
 	}
@@ -501,7 +518,7 @@ func (b *defaultBPF) Loaded() (bool, error) {
 
 // EventPipe gets event-pipe and returns a PerfEventReader.
 func (b *defaultBPF) EventPipe(ctx context.Context, mapID, perCPUBuffer uint32) (PerfEventReader, error) {
-	reader, err := newPerfEventReader(ctx, b.mapSpecs[mapID].bMap, int(perCPUBuffer))
+	reader, err := newEventReader(ctx, b.name, b.mapSpecs[mapID], int(perCPUBuffer))
 	if err != nil {
 		return nil, err
 	}
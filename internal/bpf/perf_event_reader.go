@@ -14,6 +14,12 @@
 
 package bpf
 
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
 // PerfEventReader reads the eBPF perf_event.
 type PerfEventReader interface {
 	// ReadInto reads the eBPF perf_event into pdata.
@@ -22,3 +28,48 @@ type PerfEventReader interface {
 	// Close the PerfEventReader.
 	Close() error
 }
+
+// EventLostSamples is the lost samples of an event-pipe map.
+type EventLostSamples struct {
+	BpfName string
+	MapName string
+	Lost    uint64
+}
+
+type eventLostKey struct {
+	bpfName string
+	mapName string
+}
+
+// the lost samples of all event-pipe maps, map: eventLostKey -> *atomic.Uint64
+var eventLostSamples sync.Map
+
+// eventLostCounter returns the lost samples counter of the map, the counter
+// is kept when the bpf is reloaded.
+func eventLostCounter(bpfName, mapName string) *atomic.Uint64 {
+	counter, _ := eventLostSamples.LoadOrStore(eventLostKey{bpfName, mapName}, &atomic.Uint64{})
+	return counter.(*atomic.Uint64)
+}
+
+// EventLostSamplesList returns the lost samples of all event-pipe maps.
+func EventLostSamplesList() []EventLostSamples {
+	res := []EventLostSamples{}
+	eventLostSamples.Range(func(k, v any) bool {
+		key := k.(eventLostKey)
+		res = append(res, EventLostSamples{
+			BpfName: key.bpfName,
+			MapName: key.mapName,
+			Lost:    v.(*atomic.Uint64).Load(),
+		})
+		return true
+	})
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].BpfName != res[j].BpfName {
+			return res[i].BpfName < res[j].BpfName
+		}
+		return res[i].MapName < res[j].MapName
+	})
+
+	return res
+}
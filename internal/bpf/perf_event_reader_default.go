@@ -22,8 +22,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"huatuo-bamai/internal/log"
 	"huatuo-bamai/pkg/types"
 
 	"github.com/cilium/ebpf"
@@ -36,20 +38,37 @@ type perfEventReader struct {
 	ctx       context.Context
 	rd        *perf.Reader
 	cancelCtx context.CancelFunc
+	name      string
+	lost      *atomic.Uint64
 }
 
 // _ is a type assertion
 var _ PerfEventReader = (*perfEventReader)(nil)
 
+// newEventReader creates the reader by the map type, perf_event_array or ringbuf.
+func newEventReader(ctx context.Context, bpfName string, spec mapSpec, perCPUBuffer int) (PerfEventReader, error) {
+	if spec.bMap.Type() == ebpf.RingBuf {
+		return newRingbufReader(ctx, spec.bMap)
+	}
+
+	return newPerfEventReader(ctx, spec.bMap, perCPUBuffer, bpfName, spec.name)
+}
+
 // newPerfEventReader creates a new perfEventReader.
-func newPerfEventReader(ctx context.Context, array *ebpf.Map, perCPUBuffer int) (PerfEventReader, error) {
+func newPerfEventReader(ctx context.Context, array *ebpf.Map, perCPUBuffer int, bpfName, mapName string) (PerfEventReader, error) {
 	rd, err := perf.NewReader(array, perCPUBuffer)
 	if err != nil {
 		return nil, fmt.Errorf("can't create the perf event reader: %w", err)
 	}
 
 	readerCtx, cancel := context.WithCancel(ctx)
-	return &perfEventReader{ctx: readerCtx, rd: rd, cancelCtx: cancel}, nil
+	return &perfEventReader{
+		ctx:       readerCtx,
+		rd:        rd,
+		cancelCtx: cancel,
+		name:      bpfName + "/" + mapName,
+		lost:      eventLostCounter(bpfName, mapName),
+	}, nil
 }
 
 // Close the perfEventReader.
@@ -81,7 +100,10 @@ func (r *perfEventReader) ReadInto(pdata any) error {
 				return fmt.Errorf("failed to read the event: %w", err)
 			}
 
+			// the perf buffer is full, and the samples are dropped by kernel
 			if record.LostSamples != 0 {
+				r.lost.Add(record.LostSamples)
+				log.Debugf("perf event %s lost %d samples", r.name, record.LostSamples)
 				continue
 			}
 
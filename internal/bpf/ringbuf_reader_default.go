@@ -0,0 +1,95 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !didi
+
+package bpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/pkg/errors"
+)
+
+// ringbufReader reads the eBPF ringbuf, which is shared by all cpus and keeps
+// the events in order. The kernel does not report the lost samples of ringbuf,
+// the bpf_ringbuf_output() fails in bpf programs when the buffer is full.
+type ringbufReader struct {
+	ctx       context.Context
+	rd        *ringbuf.Reader
+	cancelCtx context.CancelFunc
+}
+
+// _ is a type assertion
+var _ PerfEventReader = (*ringbufReader)(nil)
+
+// newRingbufReader creates a new ringbufReader, the buffer size is defined
+// by the max_entries of the map.
+func newRingbufReader(ctx context.Context, ring *ebpf.Map) (PerfEventReader, error) {
+	rd, err := ringbuf.NewReader(ring)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the ringbuf reader: %w", err)
+	}
+
+	readerCtx, cancel := context.WithCancel(ctx)
+	return &ringbufReader{ctx: readerCtx, rd: rd, cancelCtx: cancel}, nil
+}
+
+// Close the ringbufReader.
+func (r *ringbufReader) Close() error {
+	r.cancelCtx()
+	r.rd.Close()
+
+	return nil
+}
+
+// ReadInto reads the eBPF ringbuf event into pdata.
+func (r *ringbufReader) ReadInto(pdata any) error {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return types.ErrExitByCancelCtx
+		default:
+			// set the poll deadline 100ms
+			r.rd.SetDeadline(time.Now().Add(100 * time.Millisecond))
+
+			// read the event
+			record, err := r.rd.Read()
+			if err != nil {
+				if errors.Is(err, ringbuf.ErrClosed) { // Close
+					return fmt.Errorf("ringbufReader is closed: %w", types.ErrExitByCancelCtx)
+				} else if errors.Is(err, os.ErrDeadlineExceeded) { // poll deadline
+					continue
+				}
+				return fmt.Errorf("failed to read the event: %w", err)
+			}
+
+			// parse the event
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.NativeEndian, pdata); err != nil {
+				return fmt.Errorf("failed to parse the event: %w", err)
+			}
+
+			return nil
+		}
+	}
+}
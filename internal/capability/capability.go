@@ -0,0 +1,162 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capability probes the kernel capabilities the tracers depend on,
+// e.g. BTF, kprobe symbols, tracepoints and bpf program/map types. Each
+// requirement is probed once, and the result is cached.
+package capability
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"huatuo-bamai/internal/cgroups"
+	"huatuo-bamai/internal/log"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Requirement is a capability the tracer depends on.
+type Requirement struct {
+	// Name e.g. "btf", "kprobe:tcp_v4_rcv", "tracepoint:sched/sched_switch"
+	Name  string
+	probe func() error
+}
+
+func (r Requirement) String() string {
+	return r.Name
+}
+
+// New returns a requirement probed by the function, which returns nil if
+// the capability is supported.
+func New(name string, probe func() error) Requirement {
+	return Requirement{Name: name, probe: probe}
+}
+
+var (
+	probeLock sync.Mutex
+	probed    = map[string]error{}
+)
+
+func (r Requirement) check() error {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+
+	if err, ok := probed[r.Name]; ok {
+		return err
+	}
+
+	err := r.probe()
+	probed[r.Name] = err
+
+	log.Debugf("capability %s probed: %v", r.Name, err)
+	return err
+}
+
+// Check probes the requirements, and returns the error of the first
+// unsupported one, e.g. "kprobe:tcp_v4_rcv: symbol not found".
+func Check(reqs ...Requirement) error {
+	for _, r := range reqs {
+		if err := r.check(); err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Result is the probed result of a requirement
+type Result struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Host represents the capabilities of the host
+type Host struct {
+	KernelRelease string          `json:"kernel_release"`
+	BTF           bool            `json:"btf"`
+	CgroupMode    string          `json:"cgroup_mode"`
+	ProgramTypes  map[string]bool `json:"program_types"`
+	MapTypes      map[string]bool `json:"map_types"`
+	// Probes all the requirements probed, by the tracers and the above.
+	Probes []Result `json:"probes"`
+}
+
+// the bpf program and map types used by the tracers
+var (
+	hostProgramTypes = []ebpf.ProgramType{
+		ebpf.Kprobe,
+		ebpf.TracePoint,
+		ebpf.RawTracepoint,
+		ebpf.PerfEvent,
+	}
+	hostMapTypes = []ebpf.MapType{
+		ebpf.Hash,
+		ebpf.Array,
+		ebpf.PerCPUHash,
+		ebpf.PerCPUArray,
+		ebpf.PerfEventArray,
+		ebpf.RingBuf,
+	}
+)
+
+// HostCapabilities probes the capabilities of the host.
+func HostCapabilities() *Host {
+	h := &Host{
+		BTF:          Check(BTF()) == nil,
+		CgroupMode:   cgroups.CgroupMode().String(),
+		ProgramTypes: make(map[string]bool, len(hostProgramTypes)),
+		MapTypes:     make(map[string]bool, len(hostMapTypes)),
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		h.KernelRelease = unix.ByteSliceToString(uts.Release[:])
+	}
+
+	for _, pt := range hostProgramTypes {
+		h.ProgramTypes[pt.String()] = Check(ProgramType(pt)) == nil
+	}
+
+	for _, mt := range hostMapTypes {
+		h.MapTypes[mt.String()] = Check(MapType(mt)) == nil
+	}
+
+	h.Probes = Probed()
+	return h
+}
+
+// Probed returns the results of the requirements probed, sorted by name.
+func Probed() []Result {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+
+	results := make([]Result, 0, len(probed))
+	for name, err := range probed {
+		result := Result{Name: name, Supported: err == nil}
+		if err != nil {
+			result.Reason = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capability
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"huatuo-bamai/internal/cgroups"
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/symbol"
+	"huatuo-bamai/pkg/types"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+)
+
+var (
+	btfPath      = "/sys/kernel/btf/vmlinux"
+	tracefsPaths = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+)
+
+// BTF requires the kernel BTF, which the CO-RE bpf objects are relocated by.
+func BTF() Requirement {
+	return New("btf", func() error {
+		return stat(btfPath)
+	})
+}
+
+// Kprobe requires the kernel function, in vmlinux or the loaded modules.
+func Kprobe(name string) Requirement {
+	return New("kprobe:"+name, func() error {
+		if !symbol.KernelSymbolExists(name) {
+			return fmt.Errorf("%w: symbol not found", types.ErrNotSupported)
+		}
+
+		return nil
+	})
+}
+
+// Optional probes the requirement, which is reported by the capabilities but
+// never fails the check, e.g. the probe attached only if supported.
+func Optional(r Requirement) Requirement {
+	return New("optional:"+r.Name, func() error {
+		if err := r.check(); err != nil {
+			log.Infof("optional capability %s not supported: %v", r.Name, err)
+		}
+		return nil
+	})
+}
+
+// Tracepoint requires the tracepoint, which is used by raw tracepoints too,
+// e.g. Tracepoint("sched", "sched_switch").
+func Tracepoint(group, name string) Requirement {
+	return New(fmt.Sprintf("tracepoint:%s/%s", group, name), func() error {
+		var err error
+		for _, dir := range tracefsPaths {
+			if err = stat(filepath.Join(dir, "events", group, name)); err == nil {
+				return nil
+			}
+		}
+
+		return err
+	})
+}
+
+// ProgramType requires the bpf program type.
+func ProgramType(pt ebpf.ProgramType) Requirement {
+	return New("program:"+pt.String(), func() error {
+		return featureError(features.HaveProgramType(pt))
+	})
+}
+
+// MapType requires the bpf map type.
+func MapType(mt ebpf.MapType) Requirement {
+	return New("map:"+mt.String(), func() error {
+		return featureError(features.HaveMapType(mt))
+	})
+}
+
+// CgroupMode requires the cgroup mode of the host is one of the modes.
+func CgroupMode(modes ...cgroups.Mode) Requirement {
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		names = append(names, mode.String())
+	}
+
+	return New("cgroup:"+strings.Join(names, "|"), func() error {
+		if mode := cgroups.CgroupMode(); !slices.Contains(modes, mode) {
+			return fmt.Errorf("%w: cgroup mode %s", types.ErrNotSupported, mode)
+		}
+
+		return nil
+	})
+}
+
+// File requires the file, e.g. a sysctl or a procfs entry.
+func File(path string) Requirement {
+	return New("file:"+path, func() error {
+		return stat(path)
+	})
+}
+
+func stat(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s not found", types.ErrNotSupported, path)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func featureError(err error) error {
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		return fmt.Errorf("%w: %w", types.ErrNotSupported, err)
+	}
+
+	return err
+}
@@ -43,6 +43,19 @@ const (
 	Unified
 )
 
+func (m Mode) String() string {
+	switch m {
+	case Legacy:
+		return "legacy"
+	case Hybrid:
+		return "hybrid"
+	case Unified:
+		return "unified"
+	default:
+		return "unavailable"
+	}
+}
+
 type Cgroup interface {
 	// Name returns the cgroup name.
 	Name() string
@@ -62,6 +75,8 @@ type Cgroup interface {
 	CpuStatRaw(path string) (map[string]uint64, error)
 	// CpuQuotaAndPeriod cgroup quota and period
 	CpuQuotaAndPeriod(path string) (*stats.CpuQuota, error)
+	// CpusetCpus return the cpus which the cgroup can run on
+	CpusetCpus(path string) ([]int, error)
 	// MemoryStatRaw memory.stat
 	MemoryStatRaw(path string) (map[string]uint64, error)
 	// MemoryEventRaw memory.stat
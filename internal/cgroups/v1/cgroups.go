@@ -152,6 +152,10 @@ func (c *CgroupV1) CpuQuotaAndPeriod(path string) (*stats.CpuQuota, error) {
 	}, nil
 }
 
+func (c *CgroupV1) CpusetCpus(path string) ([]int, error) {
+	return parseutil.ReadCPUList(paths.Path(subsysCpuset, path, "cpuset.cpus"))
+}
+
 func (c *CgroupV1) MemoryStatRaw(path string) (map[string]uint64, error) {
 	return parseutil.RawKV(paths.Path(subsysMemory, path, "memory.stat"))
 }
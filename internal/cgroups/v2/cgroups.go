@@ -125,6 +125,10 @@ func (c *CgroupV2) CpuQuotaAndPeriod(path string) (*stats.CpuQuota, error) {
 	return &stats.CpuQuota{Quota: quota, Period: period}, nil
 }
 
+func (c *CgroupV2) CpusetCpus(path string) ([]int, error) {
+	return parseutil.ReadCPUList(paths.Path(path, "cpuset.cpus.effective"))
+}
+
 func (c *CgroupV2) MemoryStatRaw(path string) (map[string]uint64, error) {
 	return parseutil.RawKV(paths.Path(path, "memory.stat"))
 }
@@ -15,24 +15,69 @@
 package container
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"huatuo-bamai/internal/pod"
 )
 
-func getContainers(serverAddr, containerID string) ([]pod.Container, error) {
+// the environments exported by huatuo-bamai to the tools it starts.
+const (
+	// EnvServerAddr is the address of the API server, e.g. https://127.0.0.1:19704.
+	EnvServerAddr = "HUATUO_BAMAI_API_ADDR"
+	// EnvServerToken is the bearer token of the API server.
+	EnvServerToken = "HUATUO_BAMAI_API_TOKEN"
+)
+
+// serverURL returns the URL of the server address, which is host:port in
+// HTTP, or with the scheme.
+func serverURL(serverAddr string) (*url.URL, error) {
+	if !strings.Contains(serverAddr, "://") {
+		serverAddr = "http://" + serverAddr
+	}
+	return url.Parse(serverAddr)
+}
+
+func newClient(u *url.URL) *http.Client {
 	client := &http.Client{
 		Timeout: 3 * time.Second,
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/containers/json", serverAddr), http.NoBody)
+	// huatuo-bamai itself on the loopback, whose certificate is not issued
+	// for the loopback address generally.
+	if ip := net.ParseIP(u.Hostname()); u.Scheme == "https" && ip != nil && ip.IsLoopback() {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		}
+	}
+
+	return client
+}
+
+func getContainers(serverAddr, containerID string) ([]pod.Container, error) {
+	u, err := serverURL(serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address %s: %w", serverAddr, err)
+	}
+	u.Path = "/containers/json"
+
+	client := newClient(u)
+	req, err := http.NewRequest(http.MethodGet, u.String(), http.NoBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
 
+	if token := os.Getenv(EnvServerToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	if containerID != "" {
 		req.URL.RawQuery = fmt.Sprintf("container_id=%s", containerID)
 	}
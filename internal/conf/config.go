@@ -15,35 +15,48 @@
 package conf
 
 import (
-	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"regexp"
-	"strings"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"huatuo-bamai/internal/log"
 
 	"github.com/pelletier/go-toml"
 )
 
-// CommonConf global common configuration
+// CommonConf global common configuration, the comment tags are emitted by
+// PrintDefaultConfig.
 type CommonConf struct {
-	LogLevel string `default:"Info"`
-	LogFile  string
+	LogLevel string `default:"Info" comment:"log-level: Debug, Info, Warn, Error, Panic"`
+	LogFile  string `comment:"logging filepath, stdout if empty"`
 
 	// Blacklist for tracing and metrics
-	Blacklist []string
+	Blacklist []string `comment:"the blacklist for tracing and metrics"`
 
-	// APIServer addr
+	// APIServer addr, TLS and authentication
 	APIServer struct {
 		TCPAddr string `default:":19704"`
-	}
+
+		// TLS serves HTTPS, and verifies the client certificates by the
+		// ClientCAFile (mTLS) if set.
+		TLS struct {
+			CertFile     string `comment:"serve HTTPS if both CertFile and KeyFile are set"`
+			KeyFile      string
+			ClientCAFile string `comment:"require the client certificates signed by it (mTLS) if set"`
+		}
+
+		// Tokens: the bearer tokens and their roles, viewer or admin.
+		Tokens map[string]string `secret:"key" validate:"dive,keys,required,endkeys,oneof=viewer admin" comment:"the bearer tokens and their roles, viewer or admin, disabled if empty"`
+	} `comment:"the address the HTTP API listens on, and the authentication"`
 
 	// HuaTuo config
 	HuaTuoConf struct {
 		UserName         string
-		PassWord         string
+		PassWord         string `secret:"value"`
 		UnixAddr         string
 		ServerIP         string
 		APIVersion       string
@@ -57,50 +70,100 @@ type CommonConf struct {
 	RuntimeCgroup struct {
 		// limit cpu num 0.5 2.0
 		// limit memory (MB)
-		LimitInitCPU float64 `default:"0.5"`
+		LimitInitCPU float64 `default:"0.5" comment:"the cpu limit during the initialization"`
 		LimitCPU     float64 `default:"2.0"`
-		LimitMem     int64   `default:"2048"`
-	}
+		LimitMem     int64   `default:"2048" comment:"limit memory (MB)"`
+	} `comment:"the resource limit of huatuo-bamai"`
 
 	// Storage for huatuo-bamai tracer storage
 	Storage struct {
+		// Sinks: the storage backends the documents are saved into,
+		// supported: elasticsearch, localfile, webhook, kafka.
+		Sinks []string `comment:"the storage backends: elasticsearch, localfile, webhook, kafka"`
+
 		// ES configurations
 		ES struct {
-			Address, Username, Password, Index string
-		}
+			Address, Username, Index string
+			Password                 string `secret:"value"`
+
+			// the documents are sent by the bulk API asynchronously.
+			BatchSize     int `default:"500" comment:"the documents per bulk request"`
+			FlushInterval int `default:"5" comment:"the max interval in seconds of the bulk requests"`
+			QueueSize     int `default:"10000" comment:"the max documents queued in memory"`
+			MaxRetries    int `default:"3" validate:"gte=0" comment:"the max retries of a failed bulk request, 0 to not retry"`
+			// SpillPath: the documents are spilled into it when ES is down,
+			// and replayed when ES is available again.
+			SpillPath    string `default:"record/es-spill" comment:"the documents are spilled into it when ES is down, disabled if empty"`
+			MaxSpillSize int    `default:"100" comment:"the max size in Megabytes of the spill files"`
+		} `comment:"disable ES storage if one of Address, Username, Password empty"`
 
 		// LocalFile record file configuration
 		LocalFile struct {
-			Path         string `default:"record"`
-			RotationSize int    `default:"100"`
-			MaxRotation  int    `default:"10"`
-		}
-	}
+			Path         string `default:"record" comment:"all but the last element of path for per tracer"`
+			RotationSize int    `default:"100" comment:"the maximum size in Megabytes of a record file before it gets rotated"`
+			MaxRotation  int    `default:"10" comment:"the maximum number of old record files to retain"`
+			// Format: text or jsonl(one JSON document per line).
+			Format string `default:"text" comment:"text or jsonl (one JSON document per line)"`
+			// Compression: gzip or zstd the rotated files, disabled if empty.
+			Compression string `comment:"compress the rotated files by gzip or zstd, disabled if empty"`
+			// MaxTotalSize: the max size in Megabytes of all the record
+			// files, the oldest rotated files are removed, unlimited if 0.
+			MaxTotalSize int `comment:"the maximum size in Megabytes of all the record files, unlimited if 0"`
+		} `comment:"tracer's record data"`
+
+		// Webhook posts the documents in JSON
+		Webhook struct {
+			URL     string
+			Timeout int `default:"10" comment:"the timeout of per request in seconds"`
+			// the keys following a table belong to it in TOML, keep it last
+			Headers map[string]string `secret:"value"`
+		} `comment:"post the documents in JSON to the URL, enabled by webhook in Sinks"`
+
+		// Kafka produces the documents into the topic
+		Kafka struct {
+			Brokers      []string
+			Topic        string
+			ClientID     string `default:"huatuo-bamai"`
+			RequiredAcks string `default:"leader" comment:"none, leader or all"`
+			Timeout      int    `default:"10" comment:"the timeout of per request in seconds"`
+		} `comment:"produce the documents into the kafka topic, enabled by kafka in Sinks"`
+	} `comment:"storage configurations"`
 
 	TaskConfig struct {
-		MaxRunningTask int `default:"10"`
+		MaxRunningTask int    `default:"10" comment:"the max tasks running concurrently"`
+		HistoryPath    string `default:"record/task" comment:"the task history and outputs are persisted into it, kept in memory only if empty"`
+		MaxHistory     int    `default:"1000" comment:"the max tasks kept in the history, the oldest are removed"`
 	}
 
 	Tracing struct {
 		// CPUIdle for cpuidle configuration
 		CPUIdle struct {
-			UserThreshold          int64
-			SysThreshold           int64
-			UsageThreshold         int64
-			DeltaUserThreshold     int64
-			DeltaSysThreshold      int64
-			DeltaUsageThreshold    int64
-			Interval               int64
-			IntervalContinuousPerf int64
-			PerfRunTimeOut         int64
+			UserThreshold          int64  `default:"75" comment:"%, the user usage of the container"`
+			SysThreshold           int64  `default:"45" comment:"%, the sys usage of the container"`
+			UsageThreshold         int64  `default:"90" comment:"%, the total usage of the container"`
+			DeltaUserThreshold     int64  `default:"30" comment:"%, the delta of the user usage"`
+			DeltaSysThreshold      int64  `comment:"%, the delta of the sys usage"`
+			DeltaUsageThreshold    int64  `default:"30" comment:"%, the delta of the total usage"`
+			Interval               int64  `default:"10" validate:"gt=0" comment:"the interval in seconds of the check"`
+			IntervalContinuousPerf int64  `default:"1800" validate:"gt=0" comment:"the min interval in seconds of the flamegraphs of a container"`
+			PerfRunTimeOut         int64  `default:"10" validate:"gt=0" comment:"the duration in seconds of the flamegraph"`
+			PerfMode               string `default:"oncpu" comment:"the perf mode of the flamegraph, oncpu or offcpu"`
+			PerfStackDepth         int    `default:"64" comment:"the max depth of the stacks, up to kernel.perf_event_max_stack"`
+			PerfStack              string `default:"all" comment:"the stacks to capture, all, user or kernel"`
+			PerfSampleFreq         uint64 `default:"99" comment:"the sample frequency in Hz of the oncpu mode"`
+			PerfSamplePeriod       uint64 `comment:"the sample period in ns of the cpu clock, overrides PerfSampleFreq if set"`
 		}
 
 		// CPUSys for cpusys configuration
 		CPUSys struct {
-			SysThreshold      int64
-			DeltaSysThreshold int64
-			Interval          int64
-			PerfRunTimeOut    int64
+			SysThreshold      int64  `default:"50" comment:"%, the sys usage of the host"`
+			DeltaSysThreshold int64  `default:"30" comment:"%, the delta of the sys usage"`
+			Interval          int64  `default:"2" validate:"gt=0" comment:"the interval in seconds of the check"`
+			PerfRunTimeOut    int64  `default:"10" validate:"gt=0" comment:"the duration in seconds of the flamegraph"`
+			PerfStackDepth    int    `default:"64" comment:"the max depth of the stacks, up to kernel.perf_event_max_stack"`
+			PerfStack         string `default:"all" comment:"the stacks to capture, all, user or kernel"`
+			PerfSampleFreq    uint64 `default:"99" comment:"the sample frequency in Hz"`
+			PerfSamplePeriod  uint64 `comment:"the sample period in ns of the cpu clock, overrides PerfSampleFreq if set"`
 		}
 
 		// Waitrate for waitrate.go
@@ -112,55 +175,57 @@ type CommonConf struct {
 
 		// Softirq for softirq thresh configuration
 		Softirq struct {
-			ThresholdTime uint64
+			ThresholdTime uint64 `default:"100000000" comment:"ns, the softirq latency longer than this is recorded"`
 		}
 
 		// Dload for dload thresh configuration
 		Dload struct {
-			ThresholdLoad float64
-			MonitorGap    int
+			ThresholdLoad  float64 `default:"5.0" comment:"the load of the container"`
+			MonitorGap     int     `default:"180" comment:"the seconds after the container started to monitor it"`
+			PerfRunTimeOut int64   `default:"10" comment:"the duration in seconds of the off-cpu flamegraph of the container, disabled if 0"`
 		}
 
 		// IOTracing for iotracer thresh configuration
 		IOTracing struct {
-			IOScheduleThreshold uint64
-			ReadThreshold       uint64
-			WriteThreshold      uint64
-			IOutilThreshold     uint64
-			IOwaitThreshold     uint64
-			PeriodSecond        uint64
-			MaxStackNumber      int
-			TopProcessCount     int
-			TopFilesPerProcess  int
+			IOScheduleThreshold uint64 `default:"100" comment:"ms, the io schedule latency"`
+			ReadThreshold       uint64 `default:"2000" comment:"MB/s"`
+			WriteThreshold      uint64 `default:"1500" comment:"MB/s"`
+			IOutilThreshold     uint64 `default:"90" comment:"%"`
+			IOwaitThreshold     uint64 `default:"100" comment:"ms"`
+			PeriodSecond        uint64 `default:"8" validate:"gt=0" comment:"the period in seconds of the tracing"`
+			MaxStackNumber      int    `default:"16" validate:"gt=0"`
+			TopProcessCount     int    `default:"15" validate:"gt=0"`
+			TopFilesPerProcess  int    `default:"10" validate:"gt=0"`
 		}
 
 		// MemoryReclaim for MemoryReclaim configuration
 		MemoryReclaim struct {
-			Deltath uint64
+			Deltath uint64 `default:"900000000" comment:"ns, the direct reclaim longer than this is recorded"`
 		}
 
 		// MemoryBurst configuration
 		MemoryBurst struct {
-			HistoryWindowLength int
-			SampleInterval      int
-			SilencePeriod       int
-			TopNProcesses       int
-			BurstRatio          float64
-			AnonThreshold       int
+			HistoryWindowLength int     `default:"60" validate:"gt=0" comment:"the samples in the history"`
+			SampleInterval      int     `default:"5" validate:"gt=0" comment:"the interval in seconds of the samples"`
+			SilencePeriod       int     `default:"300" comment:"the min interval in seconds of the reports"`
+			TopNProcesses       int     `default:"10"`
+			BurstRatio          float64 `default:"2.0"`
+			AnonThreshold       int     `default:"70" comment:"%, the anon memory of the total"`
 		}
 
 		// NetRecvLat configuration
 		NetRecvLat struct {
-			ToNetIf              uint64
-			ToTCPV4              uint64
-			ToUserCopy           uint64
-			IgnoreHost           bool
-			IgnoreContainerLevel []int
-		}
+			ToNetIf              uint64 `default:"5" validate:"gt=0" comment:"ms, from driver to a core recv"`
+			ToTCPV4              uint64 `default:"10" validate:"gt=0" comment:"ms, from driver to TCP recv, contains ToNetIf"`
+			ToTCPV6              uint64 `default:"10" comment:"ms, from driver to TCP recv of IPv6, contains ToNetIf, ToTCPV4 if 0"`
+			ToUserCopy           uint64 `default:"115" validate:"gt=0" comment:"ms, from driver to user recv, contains ToNetIf + ToUserCopy"`
+			IgnoreHost           bool   `default:"true" comment:"whether to ignore the host process"`
+			IgnoreContainerLevel []int  `default:"103,3,4"`
+		} `comment:"the latency threshold for package receive"`
 
 		// Dropwatch configuration
 		Dropwatch struct {
-			IgnoreNeighInvalidate bool
+			IgnoreNeighInvalidate bool `comment:"ignore the error of neigh_invalidate"`
 		}
 
 		// Netdev configuration
@@ -168,30 +233,46 @@ type CommonConf struct {
 			Whitelist []string
 		}
 		Fastfork struct {
-			RedisInfoCollectionInterval uint32 `default:"3600"`
-			EnableForkProbe             uint32 `default:"1"`
-			EnablePtsepProbe            uint32 `default:"1"`
-			EnableWaitptsepProbe        uint32 `default:"1"`
+			RedisInfoCollectionInterval uint32 `default:"3600" validate:"gt=0" comment:"interval (seconds) of redis process information collection"`
+			EnableForkProbe             uint32 `default:"1" comment:"enable fork kprobe and kretprobe"`
+			EnablePtsepProbe            uint32 `default:"1" comment:"enable copy page table kprobe and kretprobe"`
+			EnableWaitptsepProbe        uint32 `default:"1" comment:"enable copy-on-write fault kprobe and kretprobe for redis-like processes"`
+			SlowForkThreshold           uint64 `default:"100" comment:"ms, the fork slower than this is recorded"`
 		}
+
+		// Profiling pushes the cpu profiles of the host and containers
+		// continuously to the pyroscope compatible server.
+		Profiling struct {
+			ServerURL      string `comment:"the pyroscope server, e.g. http://127.0.0.1:4040, disabled if empty"`
+			AuthToken      string `secret:"value" comment:"the bearer token of the server, optional"`
+			AppName        string `default:"huatuo-bamai.cpu"`
+			SampleRate     uint64 `default:"99" validate:"gt=0" comment:"the sample frequency in Hz"`
+			UploadInterval int    `default:"10" validate:"gt=0" comment:"the interval in seconds of pushing the profiles"`
+			Timeout        int    `default:"10" validate:"gt=0" comment:"the timeout of per push in seconds"`
+		} `comment:"the continuous profiling, the profiling tracer is restarted when enabled"`
 	}
 
 	MetricCollector struct {
+		// ScrapeTimeout: the deadline of each collector in one scrape, in
+		// seconds. The last good snapshot is served when it overruns.
+		ScrapeTimeout uint32 `default:"5" comment:"the deadline of each collector in one scrape, in seconds"`
+
 		Netdev struct {
 			// Use `netlink` instead of `procfs net/dev` to get netdev statistic.
 			// Only support the host environment to use `netlink` now!
-			EnableNetlink bool
+			EnableNetlink bool `comment:"use netlink instead of procfs net/dev, only for the host environment"`
 			// IgnoredDevices: Ignore special devices in this netdev statistic.
 			// AcceptDevices: Accept special devices in this netdev statistic.
 			// These configurations use `Regexp`.
 			// 'IgnoredDevices' has higher priority than 'AcceptDevices'.
-			IgnoredDevices, AcceptDevices string
+			IgnoredDevices, AcceptDevices string `comment:"Regexp, IgnoredDevices has higher priority than AcceptDevices"`
 		}
 		Qdisc struct {
 			// IgnoredDevices: Ignore special devices in this qdisc statistic.
 			// AcceptDevices: Accept special devices in this qdisc statistic.
 			// These configurations use `Regexp`.
 			// 'IgnoredDevices' has higher priority than 'AcceptDevices'.
-			IgnoredDevices, AcceptDevices string
+			IgnoredDevices, AcceptDevices string `comment:"Regexp, IgnoredDevices has higher priority than AcceptDevices"`
 		}
 		Vmstat struct {
 			IncludedMetrics, ExcludedMetrics string
@@ -208,91 +289,147 @@ type CommonConf struct {
 			// The 'key' format: protocol + '_' + netstat_name. eg: TcpExt_TCPSynRetrans.
 			// These configurations use `Regexp`.
 			// 'ExcludedMetrics' has higher priority than 'IncludedMetrics'.
-			ExcludedMetrics, IncludedMetrics string
+			ExcludedMetrics, IncludedMetrics string `comment:"Regexp of protocol_name, e.g. TcpExt_TCPSynRetrans, ExcludedMetrics has higher priority"`
 		}
 		MountPointStat struct {
 			IncludedMountPoints string
 		}
-	}
+	} `comment:"Collector Configurations"`
 
 	// WarningFilter for filt the known issues
 	WarningFilter struct {
 		PatternList [][]string
-	}
+	} `comment:"the patterns of the known issues: [name, pattern, matching1, matching2]"`
 
 	// Pod configuration
 	Pod struct {
+		// Provider discovers the containers: kubelet, cri or docker
+		Provider                 string `default:"kubelet" comment:"the container discovery: kubelet, cri or docker"`
 		KubeletPodListURL        string `default:"http://127.0.0.1:10255/pods"`
 		KubeletPodListHTTPSURL   string `default:"https://127.0.0.1:10250/pods"`
 		KubeletPodClientCertPath string `default:"/var/lib/kubelet/pki/kubelet-client-current.pem"`
-		DockerAPIVersion         string `default:"1.24"`
-	}
+		CRIEndpoint              string `default:"unix:///run/containerd/containerd.sock" comment:"the CRI runtime socket, for the cri provider"`
+		DockerAPIVersion         string `default:"1.24" comment:"the docker engine API version, for the docker provider"`
+	} `comment:"Pod Configurations"`
 }
 
 var (
 	lock       = sync.Mutex{}
 	configFile = ""
-	config     = &CommonConf{}
+
+	// base is the configuration of the file and the updates by API, it is
+	// written into the file by Sync.
+	base = &CommonConf{}
+	// the tracers disabled by the command line, they are appended to the
+	// Blacklist of base, and never written into the file.
+	cliBlacklist []string
+
+	// config is base with the command line overrides, it is replaced as a
+	// whole and never modified in place, so that the readers are not raced.
+	config atomic.Pointer[CommonConf]
 
 	// Region is host and containers belong to.
 	Region string
 )
 
+func init() {
+	config.Store(&CommonConf{})
+}
+
 // LoadConfig load conf file
 func LoadConfig(path string) error {
-	f, err := os.Open(path)
+	c, err := loadConfigFile(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	// defaults.SetDefaults(config)
-	d := toml.NewDecoder(f)
-	if err := d.Strict(true).Decode(config); err != nil {
-		return err
-	}
 
-	// MB
-	config.RuntimeCgroup.LimitMem *= 1024 * 1024
+	lock.Lock()
+	base = c
 	configFile = path
+	config.Store(withOverrides(c))
+	lock.Unlock()
 
-	log.Infof("Loadconfig:\n%+v\n", config)
+	log.Infof("Loadconfig:\n%+v\n", Redacted(Get()))
 	return nil
 }
 
-// Get return the global configuration obj
-func Get() *CommonConf {
-	return config
-}
-
-// Set is a function that modifies the configuration obj
-//
-//	 @key: supported keys
-//			- "Key1"
-//			- "Key1.Key2"
-func Set(key string, val any) {
+// SetBlacklistOverride disables the tracers by the command line, they are
+// kept in the Blacklist after Update and Reload, and not written by Sync.
+func SetBlacklistOverride(names []string) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	// find key
-	c := reflect.ValueOf(config)
-	for _, k := range strings.Split(key, ".") {
-		elem := c.Elem().FieldByName(k)
-		if !elem.IsValid() || !elem.CanAddr() {
-			panic(fmt.Errorf("invalid elem %s: %v", key, elem))
+	cliBlacklist = slices.Clone(names)
+	config.Store(withOverrides(base))
+}
+
+// withOverrides returns a copy of c with the command line overrides.
+func withOverrides(c *CommonConf) *CommonConf {
+	next := *c
+
+	if len(cliBlacklist) > 0 {
+		next.Blacklist = slices.Clone(c.Blacklist)
+		for _, name := range cliBlacklist {
+			if !slices.Contains(next.Blacklist, name) {
+				next.Blacklist = append(next.Blacklist, name)
+			}
 		}
-		c = elem.Addr()
 	}
 
-	// assign
-	rc := reflect.Indirect(c)
-	rval := reflect.ValueOf(val)
-	if rc.Kind() != rval.Kind() {
-		panic(fmt.Errorf("%s type %s is not assignable to type %s", key, rc.Kind(), rval.Kind()))
+	return &next
+}
+
+func loadConfigFile(path string) (*CommonConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tree, err := toml.LoadReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// the defaults are set before decoding, so the zero values in the
+	// config file are kept, e.g. KeepaliveEnable = false.
+	c := &CommonConf{}
+	if err := SetDefaults(c); err != nil {
+		return nil, err
+	}
+
+	// lenient as the config files written for the older versions may have
+	// the unknown or miscased keys.
+	v := reflect.ValueOf(c).Elem()
+	if err := applyPatch(v, "", tree.ToMap(), true); err != nil {
+		return nil, err
 	}
 
-	rc.Set(rval)
-	log.Infof("Config: set %s = %v", key, val)
+	if err := setElemDefaults(v, ""); err != nil {
+		return nil, err
+	}
+
+	if err := Validate(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// PrintDefaultConfig writes the default configuration in TOML, with the
+// comments of the fields.
+func PrintDefaultConfig(w io.Writer) error {
+	c := &CommonConf{}
+	if err := SetDefaults(c); err != nil {
+		return err
+	}
+
+	return toml.NewEncoder(w).Order(toml.OrderPreserve).Encode(c)
+}
+
+// Get return the global configuration obj
+func Get() *CommonConf {
+	return config.Load()
 }
 
 // Sync write config data to file
@@ -303,14 +440,18 @@ func Sync() error {
 	}
 	defer f.Close()
 
+	lock.Lock()
+	c := *base
+	lock.Unlock()
+
 	encoder := toml.NewEncoder(f)
-	return encoder.Encode(config)
+	return encoder.Encode(&c)
 }
 
 // KnownIssueSearch search the known issue pattern in
 // the stack and return pattern name if found.
 func KnownIssueSearch(srcPattern, srcMatching1, srcMatching2 string) (issueName string, inKnownList uint64) {
-	for _, p := range config.WarningFilter.PatternList {
+	for _, p := range Get().WarningFilter.PatternList {
 		if len(p) < 2 {
 			log.Infof("Invalid configuration, please check the config file!")
 			return "", 0
@@ -0,0 +1,211 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the tag of the default value, the elements of slices are separated by ",",
+// and the entries of maps are "key:value" separated by ",", e.g.
+//
+//	Sinks   []string          `default:"elasticsearch,localfile"`
+//	Headers map[string]string `default:"Content-Type:application/json"`
+const tagDefault = "default"
+
+// SetDefaults sets the zero fields of v, a pointer to struct, by the default
+// tags. The nested structs are set, and so are the struct elements of slices
+// and maps.
+func SetDefaults(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%T is not a pointer to struct", v)
+	}
+
+	return setDefaults(rv.Elem(), "")
+}
+
+func setDefaults(v reflect.Value, key string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field, sf := v.Field(i), v.Type().Field(i)
+			if !field.CanSet() {
+				continue
+			}
+
+			fieldKey := joinKey(key, sf.Name)
+			if tag, ok := sf.Tag.Lookup(tagDefault); ok && field.IsZero() {
+				if err := parseDefault(field, tag); err != nil {
+					return fmt.Errorf("invalid default of %s: %w", fieldKey, err)
+				}
+			}
+
+			if err := setDefaults(field, fieldKey); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := setDefaults(v.Index(i), fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !isStructType(v.Type().Elem()) {
+			return nil
+		}
+
+		// the map elements are not addressable
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := setDefaults(elem, fmt.Sprintf("%s[%v]", key, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return setDefaults(v.Elem(), key)
+		}
+	}
+
+	return nil
+}
+
+// setElemDefaults sets the struct elements of slices and maps, which are
+// decoded from the config file, by the default tags.
+func setElemDefaults(v reflect.Value, key string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+
+			if err := setElemDefaults(v.Field(i), joinKey(key, v.Type().Field(i).Name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if isStructType(v.Type().Elem()) {
+			return setDefaults(v, key)
+		}
+	}
+
+	return nil
+}
+
+func isStructType(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Struct
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func parseDefault(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := splitDefault(s)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := parseDefault(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, item := range splitDefault(s) {
+			k, val, ok := strings.Cut(item, ":")
+			if !ok {
+				return fmt.Errorf("invalid map entry %q", item)
+			}
+
+			mk := reflect.New(v.Type().Key()).Elem()
+			if err := parseDefault(mk, strings.TrimSpace(k)); err != nil {
+				return err
+			}
+
+			mv := reflect.New(v.Type().Elem()).Elem()
+			if err := parseDefault(mv, strings.TrimSpace(val)); err != nil {
+				return err
+			}
+			m.SetMapIndex(mk, mv)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func splitDefault(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+
+	return items
+}
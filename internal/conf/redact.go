@@ -0,0 +1,179 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// the secret tag marks the credentials, which are masked in the logs and the
+// API, e.g.
+//
+//	Password string            `secret:"value"`
+//	Tokens   map[string]string `secret:"key"`
+//
+// "value" masks the string or the values of the map, "key" masks the keys.
+const (
+	tagSecret = "secret"
+
+	secretValue = "value"
+	secretKey   = "key"
+
+	// RedactedValue is shown instead of the secrets.
+	RedactedValue = "******"
+)
+
+// Redacted returns a copy of c whose secrets are masked, for the logs and
+// the API.
+func Redacted(c *CommonConf) *CommonConf {
+	r := *c
+	redactValue(reflect.ValueOf(&r).Elem())
+	return &r
+}
+
+func redactValue(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field, sf := v.Field(i), v.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			redactValue(field)
+			continue
+		}
+
+		if mode, ok := sf.Tag.Lookup(tagSecret); ok {
+			field.Set(redactedField(field, mode))
+		}
+	}
+}
+
+// redactedField returns the masked copy of the string or map[string]string.
+func redactedField(field reflect.Value, mode string) reflect.Value {
+	switch field.Kind() {
+	case reflect.String:
+		if field.Len() == 0 {
+			return field
+		}
+		return reflect.ValueOf(RedactedValue).Convert(field.Type())
+	case reflect.Map:
+		if field.IsNil() {
+			return field
+		}
+
+		masked := reflect.MakeMapWithSize(field.Type(), field.Len())
+		for i, key := range sortedKeys(field) {
+			val := field.MapIndex(key)
+			switch mode {
+			case secretKey:
+				masked.SetMapIndex(reflect.ValueOf(redactedKey(i)), val)
+			default:
+				masked.SetMapIndex(key, redactedField(val, mode))
+			}
+		}
+		return masked
+	default:
+		return field
+	}
+}
+
+// the masked keys are numbered in the order of the keys, so that they are
+// mapped back by restoreRedacted.
+func redactedKey(i int) string {
+	return fmt.Sprintf("%s%d", RedactedValue, i+1)
+}
+
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// restoreRedacted restores the secrets of next which are masked, e.g. the
+// config read by GET and posted back, from prev.
+func restoreRedacted(prev, next reflect.Value) {
+	for i := 0; i < next.NumField(); i++ {
+		field, sf := next.Field(i), next.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			restoreRedacted(prev.Field(i), field)
+			continue
+		}
+
+		mode, ok := sf.Tag.Lookup(tagSecret)
+		if !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() == RedactedValue {
+				field.Set(prev.Field(i))
+			}
+		case reflect.Map:
+			if !field.IsNil() {
+				field.Set(restoredMap(prev.Field(i), field, mode))
+			}
+		}
+	}
+}
+
+func restoredMap(prev, next reflect.Value, mode string) reflect.Value {
+	var prevKeys []reflect.Value
+	if !prev.IsNil() {
+		prevKeys = sortedKeys(prev)
+	}
+
+	restored := reflect.MakeMapWithSize(next.Type(), next.Len())
+	for _, key := range next.MapKeys() {
+		val := next.MapIndex(key)
+
+		switch mode {
+		case secretKey:
+			for i, prevKey := range prevKeys {
+				if key.String() == redactedKey(i) {
+					key = prevKey
+					break
+				}
+			}
+		default:
+			if prevVal := prev.MapIndex(key); val.String() == RedactedValue && prevVal.IsValid() {
+				val = prevVal
+			}
+		}
+
+		restored.SetMapIndex(key, val)
+	}
+
+	return restored
+}
+
+// secretKeyFields returns the fields whose map keys are secrets, e.g.
+// "APIServer.Tokens".
+func secretKeyFields(t reflect.Type, prefix string, fields map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := joinKey(prefix, sf.Name)
+
+		if sf.Type.Kind() == reflect.Struct {
+			secretKeyFields(sf.Type, key, fields)
+			continue
+		}
+
+		if sf.Tag.Get(tagSecret) == secretKey {
+			fields[key] = true
+		}
+	}
+}
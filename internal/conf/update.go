@@ -0,0 +1,193 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"huatuo-bamai/internal/log"
+)
+
+// Set is a function that modifies the configuration obj, the val is converted
+// into the type of the key, e.g. the JSON number float64 into int.
+//
+//	 @key: supported keys
+//			- "Key1"
+//			- "Key1.Key2"
+func Set(key string, val any) error {
+	_, err := Update(map[string]any{key: val})
+	return err
+}
+
+// Update modifies the configuration obj by the patch, the keys are in the
+// format of Set, or the nested objects, e.g. {"Tracing": {"Dload": {...}}}.
+// Nothing is modified if any key or value is invalid, and the changed keys
+// are returned.
+func Update(patch map[string]any) ([]string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the fields are replaced as a whole, the shallow copy is enough.
+	nextBase := *base
+	if err := applyPatch(reflect.ValueOf(&nextBase).Elem(), "", patch, false); err != nil {
+		return nil, err
+	}
+
+	// the masked secrets in the config got by the API are kept.
+	restoreRedacted(reflect.ValueOf(base).Elem(), reflect.ValueOf(&nextBase).Elem())
+
+	if err := Validate(&nextBase); err != nil {
+		return nil, err
+	}
+
+	next := withOverrides(&nextBase)
+	changed := Diff(config.Load(), next)
+	base = &nextBase
+	config.Store(next)
+
+	log.Infof("Config: update %v", changed)
+	return changed, nil
+}
+
+// Reload reloads the configuration obj from the config file, and returns
+// the changed keys.
+func Reload() ([]string, error) {
+	c, err := loadConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	next := withOverrides(c)
+	changed := Diff(config.Load(), next)
+	base = c
+	config.Store(next)
+
+	log.Infof("Config: reload %s, changed %v", configFile, changed)
+	return changed, nil
+}
+
+// Diff returns the keys of the leaf fields whose values are different,
+// e.g. "Tracing.Dload.ThresholdLoad".
+func Diff(prev, next *CommonConf) []string {
+	changed := []string{}
+	diffValue("", reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem(), &changed)
+	return changed
+}
+
+func diffValue(key string, prev, next reflect.Value, changed *[]string) {
+	if prev.Kind() == reflect.Struct {
+		for i := 0; i < prev.NumField(); i++ {
+			diffValue(joinKey(key, prev.Type().Field(i).Name), prev.Field(i), next.Field(i), changed)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(prev.Interface(), next.Interface()) {
+		*changed = append(*changed, key)
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+// applyPatch sets the fields by the patch. The unknown keys are rejected, or
+// ignored with a warning if lenient, and the keys are case-insensitive if
+// lenient as the config file decoded by go-toml.
+func applyPatch(v reflect.Value, prefix string, patch map[string]any, lenient bool) error {
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := joinKey(prefix, k)
+		field, err := lookupField(v, k, lenient)
+		if err != nil {
+			if lenient {
+				log.Warnf("Config: ignore the invalid key %s: %v", key, err)
+				continue
+			}
+			return fmt.Errorf("invalid key %s: %w", key, err)
+		}
+
+		if sub, ok := patch[k].(map[string]any); ok && field.Kind() == reflect.Struct {
+			if err := applyPatch(field, key, sub, lenient); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := assignValue(field, patch[k]); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func lookupField(v reflect.Value, key string, ignoreCase bool) (reflect.Value, error) {
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("%s is not a section", name)
+		}
+
+		field := v.FieldByName(name)
+		if !field.IsValid() && ignoreCase {
+			field = v.FieldByNameFunc(func(s string) bool {
+				return strings.EqualFold(s, name)
+			})
+		}
+
+		v = field
+		if !v.IsValid() || !v.CanSet() {
+			return reflect.Value{}, fmt.Errorf("%s not found", name)
+		}
+	}
+
+	return v, nil
+}
+
+// assignValue converts the val into the type of field by JSON, which rejects
+// the mismatched types, e.g. string into int or 1.5 into int.
+func assignValue(field reflect.Value, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	ptr := reflect.New(field.Type())
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(ptr.Interface()); err != nil {
+		return fmt.Errorf("%v is not assignable to type %s", val, field.Type())
+	}
+
+	field.Set(ptr.Elem())
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// the constraints of the fields are in the validate tags, e.g.
+//
+//	UploadInterval int `default:"10" validate:"gt=0"`
+var validate = validator.New()
+
+// the fields whose map keys are secrets, which are masked in the errors.
+var secretKeys = func() map[string]bool {
+	fields := make(map[string]bool)
+	secretKeyFields(reflect.TypeOf(CommonConf{}), "", fields)
+	return fields
+}()
+
+// Validate checks the fields of the configuration by the validate tags.
+func Validate(c *CommonConf) error {
+	err := validate.Struct(c)
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		// the namespace starts with the struct name, e.g. CommonConf.Tracing.
+		_, key, _ := strings.Cut(e.Namespace(), ".")
+		if field, _, ok := strings.Cut(key, "["); ok && secretKeys[field] {
+			key = field + "[" + RedactedValue + "]"
+		}
+		msgs = append(msgs, fmt.Sprintf("%s must be %s %s, got %v", key, e.Tag(), e.Param(), e.Value()))
+	}
+
+	return fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+}
@@ -17,6 +17,7 @@ package pod
 import (
 	"errors"
 	"fmt"
+	"net"
 	"sync"
 	"syscall"
 	"time"
@@ -42,6 +43,7 @@ type Container struct {
 	Type              ContainerType     `json:"type"`
 	Qos               ContainerQos      `json:"qos"`
 	IPAddress         string            `json:"ip_address"`
+	IPAddresses       []string          `json:"ip_addresses"` // all the IPv4 and IPv6 addresses
 	NetNamespaceInode uint64            `json:"net_namespace_inode"`
 	InitPid           int               `json:"init_pid"` // the pid-1 of container
 	CgroupSuffix      string            `json:"cgroup_suffix"`
@@ -74,7 +76,7 @@ func getContainers(typeMask ContainerType, minLevel ContainerQos) (map[string]*C
 	res := make(map[string]*Container)
 
 	if time.Since(lastUpdatedAt) > updatedStep {
-		if err := kubeletSyncContainers(); err != nil {
+		if err := syncContainers(); err != nil {
 			if errors.Is(err, syscall.ECONNREFUSED) { // ignore error of no connections
 				log.Debugf("failed to sync containers by ECONNREFUSED, err: %v", err)
 				return res, nil
@@ -135,8 +137,14 @@ func GetContainerByID(id string) (*Container, error) {
 	return nil, nil
 }
 
-// GetContainerByIPAddress returns the special container by the container ip address.
+// GetContainerByIPAddress returns the special container by the container ip address,
+// both IPv4 and IPv6 address are supported.
 func GetContainerByIPAddress(ip string) (*Container, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid ip address: %s", ip)
+	}
+
 	// only for normal
 	all, err := GetNormalContainers()
 	if err != nil {
@@ -144,9 +152,15 @@ func GetContainerByIPAddress(ip string) (*Container, error) {
 	}
 
 	for _, c := range all {
-		if c.IPAddress == ip {
+		if addr.Equal(net.ParseIP(c.IPAddress)) {
 			return c, nil
 		}
+
+		for _, s := range c.IPAddresses {
+			if addr.Equal(net.ParseIP(s)) {
+				return c, nil
+			}
+		}
 	}
 
 	return nil, nil
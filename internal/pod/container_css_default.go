@@ -57,6 +57,8 @@ func parseContainerCSS(containerID string) (map[string]uint64, error) {
 const (
 	cgroupSubsysCount             = 13
 	kubeletContainerIDKnodeMaxlen = 64
+	cgroupKnodeNameMaxlen         = 128
+	systemdScopeSuffix            = ".scope"
 )
 
 var (
@@ -75,6 +77,22 @@ func isValidKnodeName(name string) bool {
 	return !kubeletContainerIDRegexp.MatchString(name)
 }
 
+// knodeContainerID returns the container id of the cgroup knode name. The
+// systemd cgroup driver names the container cgroup as <prefix>-<id>.scope,
+// e.g. docker-<id>.scope, cri-containerd-<id>.scope and crio-<id>.scope.
+func knodeContainerID(name string) (string, bool) {
+	if strings.HasSuffix(name, systemdScopeSuffix) {
+		name = strings.TrimSuffix(name, systemdScopeSuffix)
+		name = name[strings.LastIndex(name, "-")+1:]
+	}
+
+	if len(name) != kubeletContainerIDKnodeMaxlen || !isValidKnodeName(name) {
+		return "", false
+	}
+
+	return name, true
+}
+
 type containerCssMetaData struct {
 	CSS         uint64
 	SubSys      string
@@ -90,7 +108,7 @@ type containerCssPerfEvent struct {
 	CgroupRoot  int32
 	CgroupLevel int32
 	CSS         [cgroupSubsysCount]uint64
-	KnodeName   [kubeletContainerIDKnodeMaxlen + 2]byte
+	KnodeName   [cgroupKnodeNameMaxlen]byte
 }
 
 func cgroupListCssDataByKnode(containerID string) []*containerCssMetaData {
@@ -107,8 +125,8 @@ func cgroupListCssDataByKnode(containerID string) []*containerCssMetaData {
 }
 
 func cgroupUpdateOrCreateCssData(data *containerCssPerfEvent) error {
-	knodeName := strings.TrimRight(string(data.KnodeName[:]), "\x00")
-	if !isValidKnodeName(knodeName) {
+	knodeName, ok := knodeContainerID(strings.TrimRight(string(data.KnodeName[:]), "\x00"))
+	if !ok {
 		return fmt.Errorf("knode name is not containterID")
 	}
 
@@ -135,8 +153,7 @@ func cgroupUpdateOrCreateCssData(data *containerCssPerfEvent) error {
 }
 
 func cgroupDeleteCssData(data *containerCssPerfEvent) error {
-	knodeName := strings.TrimRight(string(data.KnodeName[:]), "\x00")
-	if !isValidKnodeName(knodeName) {
+	if _, ok := knodeContainerID(strings.TrimRight(string(data.KnodeName[:]), "\x00")); !ok {
 		return fmt.Errorf("knode name is not containterID")
 	}
 
@@ -192,7 +209,11 @@ func cgroupRootNotify(realRoot, name string) error {
 			return err
 		}
 
-		if !d.IsDir() || len(d.Name()) != kubeletContainerIDKnodeMaxlen {
+		if !d.IsDir() {
+			return nil
+		}
+
+		if _, ok := knodeContainerID(d.Name()); !ok {
 			return nil
 		}
 
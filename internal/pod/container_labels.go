@@ -33,3 +33,15 @@ func parseContainerLabels(typ ContainerType, pod *corev1.Pod) (map[string]any, e
 
 	return labels, nil
 }
+
+func parseRuntimeContainerLabels(typ ContainerType, namespace string) (map[string]any, error) {
+	var err error
+	labels := make(map[string]any)
+
+	labels[labelHostNamespace], err = parseRuntimeContainerLabelHostNamespace(typ, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
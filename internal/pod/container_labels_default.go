@@ -21,3 +21,7 @@ import corev1 "k8s.io/api/core/v1"
 func parseContainerLabelHostNamespace(typ ContainerType, pod *corev1.Pod) (string, error) {
 	return pod.Namespace, nil
 }
+
+func parseRuntimeContainerLabelHostNamespace(typ ContainerType, namespace string) (string, error) {
+	return namespace, nil
+}
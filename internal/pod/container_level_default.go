@@ -50,6 +50,11 @@ func parseContainerQos(typ ContainerType, pod *corev1.Pod) (ContainerQos, error)
 	}
 }
 
+// the qos class is not exposed by the container runtime.
+func parseRuntimeContainerQos(typ ContainerType) (ContainerQos, error) {
+	return containerQosUnknown, nil
+}
+
 func (p ContainerQos) String() string {
 	switch p {
 	case containerQosBurstable:
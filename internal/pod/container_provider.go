@@ -0,0 +1,229 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/log"
+	"huatuo-bamai/internal/utils/procfsutil"
+)
+
+// The container discovery providers.
+const (
+	ContainerProviderKubelet = "kubelet"
+	ContainerProviderCRI     = "cri"
+	ContainerProviderDocker  = "docker"
+)
+
+// the labels set by kubelet on the containers and sandboxes of pods.
+const (
+	runtimeLabelPodName       = "io.kubernetes.pod.name"
+	runtimeLabelPodNamespace  = "io.kubernetes.pod.namespace"
+	runtimeLabelContainerName = "io.kubernetes.container.name"
+)
+
+// containerProvider discovers the running containers on this host.
+type containerProvider interface {
+	// listContainers returns the ids of all running containers.
+	listContainers() ([]string, error)
+	// parseContainer returns the container of the id, which is returned by
+	// the latest listContainers. The NetNamespaceInode, CSS and life resources
+	// are filled by syncContainers.
+	parseContainer(containerID string) (*Container, error)
+}
+
+// the container provider in use, kubelet as default.
+var currContainerProvider containerProvider = &kubeletProvider{}
+
+func syncContainers() error {
+	containerIDs, err := currContainerProvider.listContainers()
+	if err != nil {
+		// ignore all errors and remain old containers.
+		log.Debugf("failed to list containers: %v", err)
+		return nil
+	}
+
+	newContainers := make(map[string]struct{}, len(containerIDs))
+	for _, id := range containerIDs {
+		newContainers[id] = struct{}{}
+	}
+
+	for k := range containers {
+		// clear old containers which do not exist in newContainers.
+		if _, ok := newContainers[k]; !ok {
+			delete(containers, k)
+			continue
+		}
+
+		// skip the existing containers
+		delete(newContainers, k)
+	}
+
+	// update containers.
+	for newContainerID := range newContainers {
+		if err := updateContainer(newContainerID); err != nil {
+			log.Infof("failed to update container %s: %v", newContainerID, err)
+			continue
+		}
+	}
+
+	return nil
+}
+
+func updateContainer(containerID string) error {
+	container, err := currContainerProvider.parseContainer(containerID)
+	if err != nil {
+		return err
+	}
+
+	// net namespace
+	nsInode, err := procfsutil.NetNSInodeByPid(container.InitPid)
+	if err != nil {
+		return fmt.Errorf("failed to get net namespace inode by pid: %w", err)
+	}
+
+	css, err := parseContainerCSS(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to parse container css: %w", err)
+	}
+
+	container.NetNamespaceInode = nsInode
+	container.CSS = css
+	container.SyncedAt = time.Now()
+	container.lifeResouces = make(map[string]any)
+	containers[containerID] = container
+
+	// create container life resources
+	createContainerLifeResources(container)
+
+	log.Infof("update container %#v", container)
+	return nil
+}
+
+// runtimeContainer is the container reported by the container runtime.
+type runtimeContainer struct {
+	id        string
+	name      string
+	hostname  string
+	namespace string
+	ipAddress string
+	// the additional addresses, e.g. IPv6 of dual-stack
+	ipAddresses []string
+	initPid     int
+	startedAt   time.Time
+}
+
+func (c *runtimeContainer) toContainer() (*Container, error) {
+	containerType, err := parseRuntimeContainerType(c.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse type: %w", err)
+	}
+
+	containerQos, err := parseRuntimeContainerQos(containerType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse qos: %w", err)
+	}
+
+	labels, err := parseRuntimeContainerLabels(containerType, c.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse container labels: %w", err)
+	}
+
+	if c.initPid <= 0 {
+		return nil, fmt.Errorf("invalid pid %d", c.initPid)
+	}
+
+	cgroupSuffix, err := cgroupSuffixByPid(c.initPid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cgroup of pid %d: %w", c.initPid, err)
+	}
+
+	return &Container{
+		ID:           c.id,
+		Name:         c.name,
+		Hostname:     c.hostname,
+		Type:         containerType,
+		Qos:          containerQos,
+		IPAddress:    c.ipAddress,
+		IPAddresses:  c.allIPAddresses(),
+		InitPid:      c.initPid,
+		CgroupSuffix: cgroupSuffix,
+		StartedAt:    c.startedAt,
+		Labels:       labels,
+	}, nil
+}
+
+func (c *runtimeContainer) allIPAddresses() []string {
+	addrs := make([]string, 0, len(c.ipAddresses)+1)
+	if c.ipAddress != "" {
+		addrs = append(addrs, c.ipAddress)
+	}
+
+	for _, addr := range c.ipAddresses {
+		if addr != "" && addr != c.ipAddress {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// cgroupSuffixByPid returns the cgroup path of the process relative to the
+// subsystem root, reads the cpu controller in cgroupv1 or the unified
+// hierarchy in cgroupv2.
+func cgroupSuffixByPid(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// 4:cpu,cpuacct:/docker/<id>
+	// 0::/system.slice/docker-<id>.scope
+	var unified string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[0] == "0" && parts[1] == "" {
+			unified = parts[2]
+			continue
+		}
+
+		for _, subsys := range strings.Split(parts[1], ",") {
+			if subsys == "cpu" {
+				return parts[2], nil
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if unified == "" {
+		return "", fmt.Errorf("no cgroup of pid %d", pid)
+	}
+
+	return unified, nil
+}
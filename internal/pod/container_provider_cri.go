@@ -0,0 +1,139 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	internalapi "k8s.io/cri-api/pkg/apis"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	k8sremote "k8s.io/cri-client/pkg"
+)
+
+const (
+	criReqTimeout      = 5 * time.Second
+	criDefaultEndpoint = "unix:///run/containerd/containerd.sock"
+)
+
+// criProvider discovers the containers by the CRI runtime service, such as
+// containerd and CRI-O, without kubelet.
+type criProvider struct {
+	client internalapi.RuntimeService
+	// map: ContainerID -> *runtimeapi.Container
+	listed map[string]*runtimeapi.Container
+}
+
+func newCRIProvider(endpoint string) (*criProvider, error) {
+	if endpoint == "" {
+		endpoint = criDefaultEndpoint
+	}
+
+	client, err := k8sremote.NewRemoteRuntimeService(endpoint, criReqTimeout, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create cri client %s: %w", endpoint, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), criReqTimeout)
+	defer cancel()
+
+	if _, err := client.Version(ctx, ""); err != nil {
+		return nil, fmt.Errorf("cri version %s: %w", endpoint, err)
+	}
+
+	return &criProvider{client: client}, nil
+}
+
+func (p *criProvider) listContainers() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), criReqTimeout)
+	defer cancel()
+
+	list, err := p.client.ListContainers(ctx, &runtimeapi.ContainerFilter{
+		State: &runtimeapi.ContainerStateValue{
+			State: runtimeapi.ContainerState_CONTAINER_RUNNING,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cri list containers: %w", err)
+	}
+
+	listed := make(map[string]*runtimeapi.Container, len(list))
+	containerIDs := make([]string, 0, len(list))
+	for _, c := range list {
+		listed[c.Id] = c
+		containerIDs = append(containerIDs, c.Id)
+	}
+
+	p.listed = listed
+	return containerIDs, nil
+}
+
+func (p *criProvider) parseContainer(containerID string) (*Container, error) {
+	c, ok := p.listed[containerID]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found in cri list", containerID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), criReqTimeout)
+	defer cancel()
+
+	// the verbose info of containerd and CRI-O has the pid of container
+	status, err := p.client.ContainerStatus(ctx, containerID, true)
+	if err != nil {
+		return nil, fmt.Errorf("cri container status: %w", err)
+	}
+
+	info := struct {
+		Pid int `json:"pid"`
+	}{}
+	if err := json.Unmarshal([]byte(status.GetInfo()["info"]), &info); err != nil {
+		return nil, fmt.Errorf("unmarshal cri container info: %w", err)
+	}
+
+	name := c.GetLabels()[runtimeLabelContainerName]
+	if name == "" {
+		name = c.GetMetadata().GetName()
+	}
+
+	hostname := c.GetLabels()[runtimeLabelPodName]
+	if hostname == "" {
+		hostname = name
+	}
+
+	var ipAddress string
+	var ipAddresses []string
+	if sandbox, err := p.client.PodSandboxStatus(ctx, c.PodSandboxId, false); err == nil {
+		network := sandbox.GetStatus().GetNetwork()
+		ipAddress = network.GetIp()
+		for _, ip := range network.GetAdditionalIps() {
+			ipAddresses = append(ipAddresses, ip.GetIp())
+		}
+	}
+
+	rc := &runtimeContainer{
+		id:          containerID,
+		name:        name,
+		hostname:    hostname,
+		namespace:   c.GetLabels()[runtimeLabelPodNamespace],
+		ipAddress:   ipAddress,
+		ipAddresses: ipAddresses,
+		initPid:     info.Pid,
+		startedAt:   time.Unix(0, status.GetStatus().GetStartedAt()),
+	}
+
+	return rc.toContainer()
+}
@@ -0,0 +1,147 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerclient "github.com/docker/docker/client"
+)
+
+const dockerReqTimeout = 5 * time.Second
+
+// dockerProvider discovers the containers by the docker engine API, for the
+// plain docker hosts without kubelet.
+type dockerProvider struct {
+	client *dockerclient.Client
+}
+
+func newDockerProvider(apiVersion string) (*dockerProvider, error) {
+	client, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithVersion(apiVersion))
+	if err != nil {
+		return nil, fmt.Errorf("create docker client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerReqTimeout)
+	defer cancel()
+
+	if _, err := client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ping docker: %w", err)
+	}
+
+	return &dockerProvider{client: client}, nil
+}
+
+func (p *dockerProvider) listContainers() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerReqTimeout)
+	defer cancel()
+
+	// only the running containers as default
+	list, err := p.client.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("docker list containers: %w", err)
+	}
+
+	containerIDs := make([]string, 0, len(list))
+	for i := range list {
+		containerIDs = append(containerIDs, list[i].ID)
+	}
+
+	return containerIDs, nil
+}
+
+func (p *dockerProvider) parseContainer(containerID string) (*Container, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerReqTimeout)
+	defer cancel()
+
+	c, err := p.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect: %w", err)
+	}
+
+	if c.State == nil || c.Config == nil {
+		return nil, fmt.Errorf("docker inspect: invalid container %s", containerID)
+	}
+
+	startedAt, err := time.Parse(time.RFC3339Nano, c.State.StartedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse StartedAt %s: %w", c.State.StartedAt, err)
+	}
+
+	name := c.Config.Labels[runtimeLabelContainerName]
+	if name == "" {
+		name = strings.TrimPrefix(c.Name, "/")
+	}
+
+	hostname := c.Config.Labels[runtimeLabelPodName]
+	if hostname == "" {
+		hostname = c.Config.Hostname
+	}
+
+	var ipAddress string
+	ipAddresses := dockerContainerIPAddresses(c.NetworkSettings)
+	if len(ipAddresses) > 0 {
+		ipAddress = ipAddresses[0]
+	}
+
+	rc := &runtimeContainer{
+		id:          containerID,
+		name:        name,
+		hostname:    hostname,
+		namespace:   c.Config.Labels[runtimeLabelPodNamespace],
+		ipAddress:   ipAddress,
+		ipAddresses: ipAddresses,
+		initPid:     c.State.Pid,
+		startedAt:   startedAt,
+	}
+
+	return rc.toContainer()
+}
+
+// the IPv4 addresses are in front of the IPv6 addresses.
+func dockerContainerIPAddresses(settings *dockertypes.NetworkSettings) []string {
+	if settings == nil {
+		return nil
+	}
+
+	var ipv4, ipv6 []string
+	if settings.IPAddress != "" {
+		ipv4 = append(ipv4, settings.IPAddress)
+	}
+	if settings.GlobalIPv6Address != "" {
+		ipv6 = append(ipv6, settings.GlobalIPv6Address)
+	}
+
+	for _, network := range settings.Networks {
+		if network == nil {
+			continue
+		}
+
+		if network.IPAddress != "" {
+			ipv4 = append(ipv4, network.IPAddress)
+		}
+		if network.GlobalIPv6Address != "" {
+			ipv6 = append(ipv6, network.GlobalIPv6Address)
+		}
+	}
+
+	return append(ipv4, ipv6...)
+}
@@ -28,7 +28,6 @@ import (
 	"time"
 
 	"huatuo-bamai/internal/log"
-	"huatuo-bamai/internal/utils/procfsutil"
 
 	corev1 "k8s.io/api/core/v1"
 	kubeletconfig "k8s.io/kubelet/config/v1beta1"
@@ -50,11 +49,14 @@ var (
 )
 
 type PodContainerInitCtx struct {
+	Provider              string
 	PodListReadOnlyPort   string
 	PodListAuthorizedPort string
 	PodClientCertPath     string
 	podClientCertPath     string
 	podClientCertKey      string
+	CRIEndpoint           string
+	DockerAPIVersion      string
 }
 
 func kubeletHttpRequest(ctx *PodContainerInitCtx) (*http.Client, error) {
@@ -107,7 +109,35 @@ func kubeletPodListPortUpdate(ctx *PodContainerInitCtx) error {
 	return nil
 }
 
+// containerRuntimeProviderInit discovers the containers from the container
+// runtime directly, instead of the pod list of kubelet.
+func containerRuntimeProviderInit(ctx *PodContainerInitCtx) error {
+	switch ctx.Provider {
+	case ContainerProviderCRI:
+		provider, err := newCRIProvider(ctx.CRIEndpoint)
+		if err != nil {
+			return err
+		}
+		currContainerProvider = provider
+	case ContainerProviderDocker:
+		provider, err := newDockerProvider(ctx.DockerAPIVersion)
+		if err != nil {
+			return err
+		}
+		currContainerProvider = provider
+	default:
+		return fmt.Errorf("invalid container provider: %s", ctx.Provider)
+	}
+
+	log.Infof("container provider: %s", ctx.Provider)
+	return containerCgroupCssInit()
+}
+
 func ContainerPodMgrInit(ctx *PodContainerInitCtx) error {
+	if ctx.Provider != "" && ctx.Provider != ContainerProviderKubelet {
+		return containerRuntimeProviderInit(ctx)
+	}
+
 	if ctx.PodListReadOnlyPort == "" && ctx.PodListAuthorizedPort == "" {
 		log.Warnf("pod sync is not working, we manually turned off this.")
 		return nil
@@ -171,21 +201,25 @@ func ContainerPodMgrClose() {
 	}
 }
 
-func kubeletSyncContainers() error {
+type kubeletContainerInfo struct {
+	container       *corev1.Container
+	containerStatus *corev1.ContainerStatus
+	pod             *corev1.Pod
+}
+
+// kubeletProvider discovers the containers by the pod list of kubelet.
+type kubeletProvider struct {
+	// map: ContainerID -> *kubeletContainerInfo
+	infos map[string]*kubeletContainerInfo
+}
+
+func (p *kubeletProvider) listContainers() ([]string, error) {
 	podList, err := kubeletGetPodList()
 	if err != nil {
-		// ignore all errors and remain old containers.
-		return nil
-	}
-
-	type containerInfo struct {
-		container       *corev1.Container
-		containerStatus *corev1.ContainerStatus
-		pod             *corev1.Pod
+		return nil, err
 	}
 
-	// map: ContainerID -> *containerInfo
-	newContainers := make(map[string]*containerInfo)
+	infos := make(map[string]*kubeletContainerInfo)
 	for i := range podList.Items {
 		pod := &podList.Items[i]
 
@@ -210,10 +244,10 @@ func kubeletSyncContainers() error {
 			containerStatus := c[1].(*corev1.ContainerStatus)
 			containerID, err := parseContainerIDInPodStatus(containerStatus.ContainerID)
 			if err != nil {
-				return fmt.Errorf("failed to parse container id %s in pod %s status: %w", containerStatus.ContainerID, pod.Name, err)
+				return nil, fmt.Errorf("failed to parse container id %s in pod %s status: %w", containerStatus.ContainerID, pod.Name, err)
 			}
 
-			newContainers[containerID] = &containerInfo{
+			infos[containerID] = &kubeletContainerInfo{
 				container:       c[0].(*corev1.Container),
 				containerStatus: containerStatus,
 				pod:             pod,
@@ -221,30 +255,23 @@ func kubeletSyncContainers() error {
 		}
 	}
 
-	for k := range containers {
-		// clear old containers which do not exist in newContainers.
-		if _, ok := newContainers[k]; !ok {
-			delete(containers, k)
-			continue
-		}
+	p.infos = infos
 
-		// skip the existing containers
-		delete(newContainers, k)
+	containerIDs := make([]string, 0, len(infos))
+	for id := range infos {
+		containerIDs = append(containerIDs, id)
 	}
 
-	// update containers.
-	for newContainerID, newContainerInfo := range newContainers {
-		container := newContainerInfo.container
-		containerStatus := newContainerInfo.containerStatus
-		pod := newContainerInfo.pod
+	return containerIDs, nil
+}
 
-		if err := kubeletUpdateContainer(newContainerID, container, containerStatus, pod); err != nil {
-			log.Infof("failed to update container %s in pod %s: %v", newContainerID, pod.Name, err)
-			continue
-		}
+func (p *kubeletProvider) parseContainer(containerID string) (*Container, error) {
+	info, ok := p.infos[containerID]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found in pod list", containerID)
 	}
 
-	return nil
+	return kubeletParseContainer(containerID, info.container, info.containerStatus, info.pod)
 }
 
 func kubeletGetPodList() (corev1.PodList, error) {
@@ -280,74 +307,53 @@ func kubeletDoRequest(client *http.Client, kubeletPodListURL string) (corev1.Pod
 	return podList, nil
 }
 
-// func updateKubeletContainer(containerID string, container *corev1.Container, containerStatus *corev1.ContainerStatus, pod *corev1.Pod, css map[string]uint64) error {
-func kubeletUpdateContainer(containerID string, container *corev1.Container, containerStatus *corev1.ContainerStatus, pod *corev1.Pod) error {
+func kubeletParseContainer(containerID string, container *corev1.Container, containerStatus *corev1.ContainerStatus, pod *corev1.Pod) (*Container, error) {
 	// container type
 	containerType, err := parseContainerType(container, pod)
 	if err != nil {
-		return fmt.Errorf("failed to parse type: %w", err)
+		return nil, fmt.Errorf("failed to parse type: %w", err)
 	}
 
 	// container qos
 	containerQos, err := parseContainerQos(containerType, pod)
 	if err != nil {
-		return fmt.Errorf("failed to parse qos: %w", err)
+		return nil, fmt.Errorf("failed to parse qos: %w", err)
 	}
 
 	hostname, err := parseContainerHostname(containerType, pod)
 	if err != nil {
-		return fmt.Errorf("failed to parse hostname: %w", err)
+		return nil, fmt.Errorf("failed to parse hostname: %w", err)
 	}
 
 	// fetch InitPid
 	initPid, err := containerInitPid(containerID)
 	if err != nil {
-		return fmt.Errorf("failed to get InitPid: %w", err)
-	}
-
-	// net namespace
-	nsInode, err := procfsutil.NetNSInodeByPid(initPid)
-	if err != nil {
-		return fmt.Errorf("failed to get net namespace inode by pid: %w", err)
+		return nil, fmt.Errorf("failed to get InitPid: %w", err)
 	}
 
 	labels, err := parseContainerLabels(containerType, pod)
 	if err != nil {
-		return fmt.Errorf("failed to parse container labels: %w", err)
+		return nil, fmt.Errorf("failed to parse container labels: %w", err)
 	}
 
 	startedAt, err := time.Parse(time.RFC3339, containerStatus.State.Running.StartedAt.Format(time.RFC3339))
 	if err != nil {
-		return fmt.Errorf("failed to parse StartedAt %s: %w", containerStatus.State.Running.StartedAt, err)
-	}
-
-	css, err := parseContainerCSS(containerID)
-	if err != nil {
-		return fmt.Errorf("failed to parse container css: %w", err)
-	}
-
-	containers[containerID] = &Container{
-		ID:                containerID,
-		Name:              container.Name,
-		Hostname:          hostname,
-		Type:              containerType,
-		Qos:               containerQos,
-		IPAddress:         parseContainerIPAddress(pod),
-		NetNamespaceInode: nsInode,
-		InitPid:           initPid,
-		CgroupSuffix:      containerCgroupSuffix(containerID, pod),
-		CSS:               css,
-		StartedAt:         startedAt,
-		SyncedAt:          time.Now(),
-		lifeResouces:      make(map[string]any),
-		Labels:            labels,
-	}
-
-	// create container life resources
-	createContainerLifeResources(containers[containerID])
-
-	log.Infof("update container %#v", containers[containerID])
-	return nil
+		return nil, fmt.Errorf("failed to parse StartedAt %s: %w", containerStatus.State.Running.StartedAt, err)
+	}
+
+	return &Container{
+		ID:           containerID,
+		Name:         container.Name,
+		Hostname:     hostname,
+		Type:         containerType,
+		Qos:          containerQos,
+		IPAddress:    parseContainerIPAddress(pod),
+		IPAddresses:  parseContainerIPAddresses(pod),
+		InitPid:      initPid,
+		CgroupSuffix: containerCgroupSuffix(containerID, pod),
+		StartedAt:    startedAt,
+		Labels:       labels,
+	}, nil
 }
 
 func parseContainerIDInPodStatus(data string) (string, error) {
@@ -370,6 +376,16 @@ func parseContainerIPAddress(pod *corev1.Pod) string {
 	return pod.Status.PodIP
 }
 
+// the IPv4 and IPv6 addresses of the dual-stack pod.
+func parseContainerIPAddresses(pod *corev1.Pod) []string {
+	addrs := make([]string, 0, len(pod.Status.PodIPs))
+	for _, ip := range pod.Status.PodIPs {
+		addrs = append(addrs, ip.IP)
+	}
+
+	return addrs
+}
+
 func isRuningPod(pod *corev1.Pod) bool {
 	// The Pod has been bound to a node, and all of the containers have been created.
 	// At least one container is still running, or is in the process of starting or
@@ -53,3 +53,11 @@ func parseContainerType(container *corev1.Container, pod *corev1.Pod) (Container
 	// CloneSet
 	return ContainerTypeNormal, nil
 }
+
+func parseRuntimeContainerType(name string) (ContainerType, error) {
+	if strings.Contains(sidecarModules, name) {
+		return ContainerTypeSidecar, nil
+	}
+
+	return ContainerTypeNormal, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/symbol"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// SampleType is the type and unit of the stack counts in the pprof profile,
+// e.g. samples/count of the on-cpu stacks.
+type SampleType struct {
+	Type string
+	Unit string
+}
+
+// WritePprof writes the stacks as a gzipped pprof profile, which can be opened
+// by `go tool pprof`. The frames are the same as Frames without the kernel
+// addresses, and the samples are labelled with the pid and comm.
+func WritePprof(w io.Writer, stacks []StackCount, u *symbol.Usym, sampleType SampleType, duration time.Duration) error {
+	b := newPprofBuilder()
+
+	prof := &profilev1.Profile{
+		SampleType: []*profilev1.ValueType{{
+			Type: b.str(sampleType.Type),
+			Unit: b.str(sampleType.Unit),
+		}},
+		TimeNanos:     time.Now().Add(-duration).UnixNano(),
+		DurationNanos: duration.Nanoseconds(),
+	}
+
+	for i := range stacks {
+		key := &stacks[i].Key
+		sample := &profilev1.Sample{
+			Value: []int64{int64(stacks[i].Count)},
+			Label: []*profilev1.Label{
+				{Key: b.str("pid"), Num: int64(key.Pid)},
+				{Key: b.str("comm"), Str: b.str(strings.TrimRight(string(key.Comm[:]), "\x00"))},
+			},
+		}
+
+		for _, frame := range Frames(&stacks[i], u) {
+			sample.LocationId = append(sample.LocationId, b.location(frameName(frame)))
+		}
+		prof.Sample = append(prof.Sample, sample)
+	}
+
+	prof.Location = b.locations
+	prof.Function = b.functions
+	prof.StringTable = b.strings
+
+	data, err := prof.MarshalVT()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// pprofBuilder dedups the strings, and the functions and locations of the
+// frames, one location per function as the frames are symbolized already.
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]int64
+	locations []*profilev1.Location
+	locIDs    map[string]uint64
+	functions []*profilev1.Function
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		// the first string of the table must be ""
+		strings:   []string{""},
+		stringIDs: map[string]int64{"": 0},
+		locIDs:    map[string]uint64{},
+	}
+}
+
+func (b *pprofBuilder) str(s string) int64 {
+	id, ok := b.stringIDs[s]
+	if !ok {
+		id = int64(len(b.strings))
+		b.stringIDs[s] = id
+		b.strings = append(b.strings, s)
+	}
+
+	return id
+}
+
+func (b *pprofBuilder) location(frame string) uint64 {
+	if id, ok := b.locIDs[frame]; ok {
+		return id
+	}
+
+	// the ids of the functions and locations start from 1
+	id := uint64(len(b.locations) + 1)
+	name := b.str(frame)
+	b.functions = append(b.functions, &profilev1.Function{Id: id, Name: name, SystemName: name})
+	b.locations = append(b.locations, &profilev1.Location{Id: id, Line: []*profilev1.Line{{FunctionId: id}}})
+	b.locIDs[frame] = id
+
+	return id
+}
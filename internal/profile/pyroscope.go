@@ -0,0 +1,141 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPushTimeout = 10 * time.Second
+	pyroscopeSpyName   = "huatuo-bamai"
+)
+
+// Profile is the folded stacks sampled in [From, Until)
+type Profile struct {
+	// Name the application name, e.g. huatuo-bamai.cpu
+	Name       string
+	Labels     map[string]string
+	From       time.Time
+	Until      time.Time
+	SampleRate uint64
+	Folded     []byte
+}
+
+// Pusher pushes the profiles into the ingest API of pyroscope, or the
+// compatible servers.
+type Pusher struct {
+	client    *http.Client
+	ingestURL string
+	authToken string
+}
+
+// NewPusher creates a pusher of the server, e.g. http://127.0.0.1:4040, the
+// authToken is sent as the bearer token if not empty.
+func NewPusher(serverURL, authToken string, timeout time.Duration) (*Pusher, error) {
+	if serverURL == "" {
+		return nil, errors.New("profiling server url is empty")
+	}
+
+	if timeout <= 0 {
+		timeout = defaultPushTimeout
+	}
+
+	ingestURL, err := url.JoinPath(serverURL, "ingest")
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url %s: %w", serverURL, err)
+	}
+
+	return &Pusher{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 10,
+				DialContext:         (&net.Dialer{Timeout: timeout}).DialContext,
+			},
+		},
+		ingestURL: ingestURL,
+		authToken: authToken,
+	}, nil
+}
+
+// Push posts the profile in the folded format.
+func (p *Pusher) Push(ctx context.Context, prof *Profile) error {
+	query := url.Values{}
+	query.Set("name", appName(prof.Name, prof.Labels))
+	query.Set("from", strconv.FormatInt(prof.From.Unix(), 10))
+	query.Set("until", strconv.FormatInt(prof.Until.Unix(), 10))
+	query.Set("sampleRate", strconv.FormatUint(prof.SampleRate, 10))
+	query.Set("spyName", pyroscopeSpyName)
+	query.Set("units", "samples")
+	query.Set("aggregationType", "sum")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.ingestURL+"?"+query.Encode(), bytes.NewReader(prof.Folded))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+	if p.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.authToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push profile failed with status: %s, response: %s", resp.Status, string(body))
+	}
+
+	return nil
+}
+
+var labelReplacer = strings.NewReplacer(",", "_", "=", "_", "{", "_", "}", "_")
+
+// appName returns the name with labels, e.g. huatuo-bamai.cpu{hostname=a,region=b}
+func appName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labelReplacer.Replace(labels[k]))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profile converts the stacks sampled by the perf bpf program into
+// the profiles, and pushes them to the profiling server.
+package profile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"huatuo-bamai/internal/bpf"
+	"huatuo-bamai/internal/symbol"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+)
+
+const (
+	// DefaultStackDepth is the default depth of the kernel and user stacks.
+	DefaultStackDepth = 64
+
+	// StackAll captures both the user and kernel stacks.
+	StackAll = "all"
+	// StackUser captures the user stacks only.
+	StackUser = "user"
+	// StackKernel captures the kernel stacks only.
+	StackKernel = "kernel"
+
+	countsMap      = "counts"
+	stackTracesMap = "stack_traces"
+)
+
+const kernelFrameSuffix = "_[k]"
+
+var foldedReplacer = strings.NewReplacer(";", "_", " ", "_")
+
+// the max depth of the stacks, kernel.perf_event_max_stack
+var maxStackDepthPath = "/proc/sys/kernel/perf_event_max_stack"
+
+// LoadOption is the options of loading perf.c.
+type LoadOption struct {
+	CSS        uint64 // the cpu css of the tasks, all tasks if 0
+	Pid        uint64 // the tgid of the tasks, all tasks if 0
+	StackDepth int    // DefaultStackDepth if 0
+	Stack      string // StackAll if empty, StackUser or StackKernel
+}
+
+// Consts returns the constants and the map options to load perf.c, the
+// stack_traces map is sized by the stack depth.
+func (o *LoadOption) Consts() (map[string]any, []bpf.MapOption, error) {
+	depth := o.StackDepth
+	if depth == 0 {
+		depth = DefaultStackDepth
+	}
+
+	if maxDepth := maxStackDepth(); depth < 0 || depth > maxDepth {
+		return nil, nil, fmt.Errorf("invalid stack depth %d, must be in [1, %d]", depth, maxDepth)
+	}
+
+	var userStack, kernelStack uint32
+	switch o.Stack {
+	case "", StackAll:
+		userStack, kernelStack = 1, 1
+	case StackUser:
+		userStack = 1
+	case StackKernel:
+		kernelStack = 1
+	default:
+		return nil, nil, fmt.Errorf("invalid stack %q, must be %s, %s or %s", o.Stack, StackAll, StackUser, StackKernel)
+	}
+
+	consts := map[string]any{
+		"css":          o.CSS,
+		"pid":          o.Pid,
+		"user_stack":   userStack,
+		"kernel_stack": kernelStack,
+	}
+
+	return consts, []bpf.MapOption{{Name: stackTracesMap, ValueSize: uint32(depth * 8)}}, nil
+}
+
+func maxStackDepth() int {
+	data, err := os.ReadFile(maxStackDepthPath)
+	if err != nil {
+		return 127
+	}
+
+	depth, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 127
+	}
+
+	return depth
+}
+
+// StackKey is the key of the stack counts map in perf.c
+type StackKey struct {
+	UstackID int32 // the id in the stack_traces map, negative if not captured
+	KstackID int32
+	CSS      uint64 // the cpu css of the task
+	Pid      uint32
+	Comm     [16]byte
+	_        uint32
+}
+
+// StackCount is a stack and the samples of it
+type StackCount struct {
+	Key    StackKey
+	Ustack []uint64 // the user stack from the leaf
+	Kstack []uint64 // the kernel stack from the leaf
+	Count  uint64
+}
+
+// ReadStackCounts dumps the stack counts of perf.c, sorted by the count, and
+// the dumped counts and stacks are deleted if clear, for the next interval.
+//
+// NOTICE: a stack shared by the counts dumped and the counts updated later is
+// deleted as well, and these later counts lose the stack.
+func ReadStackCounts(b bpf.BPF, clear bool) ([]StackCount, error) {
+	items, err := b.DumpMapByName(countsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := make([]StackCount, 0, len(items))
+	stackTraces := map[int32][]uint64{}
+	for _, item := range items {
+		var sc StackCount
+		if err := binary.Read(bytes.NewReader(item.Key), binary.LittleEndian, &sc.Key); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(bytes.NewReader(item.Value), binary.LittleEndian, &sc.Count); err != nil {
+			return nil, err
+		}
+
+		sc.Ustack = readStackTrace(b, stackTraces, sc.Key.UstackID)
+		sc.Kstack = readStackTrace(b, stackTraces, sc.Key.KstackID)
+		stacks = append(stacks, sc)
+	}
+
+	if clear && len(items) > 0 {
+		keys := make([][]byte, 0, len(items))
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+
+		if err := b.DeleteMapItems(b.MapIDByName(countsMap), keys); err != nil {
+			return nil, fmt.Errorf("delete map %s: %w", countsMap, err)
+		}
+
+		keys = keys[:0]
+		for id := range stackTraces {
+			keys = append(keys, binary.LittleEndian.AppendUint32(nil, uint32(id)))
+		}
+
+		if err := b.DeleteMapItems(b.MapIDByName(stackTracesMap), keys); err != nil {
+			return nil, fmt.Errorf("delete map %s: %w", stackTracesMap, err)
+		}
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].Count < stacks[j].Count
+	})
+
+	return stacks, nil
+}
+
+// readStackTrace reads the addresses of the stack id, and caches the stack,
+// nil if the stack is not captured or lost.
+func readStackTrace(b bpf.BPF, cache map[int32][]uint64, id int32) []uint64 {
+	if id < 0 {
+		return nil
+	}
+
+	if stack, ok := cache[id]; ok {
+		return stack
+	}
+
+	value, err := b.ReadMap(b.MapIDByName(stackTracesMap), binary.LittleEndian.AppendUint32(nil, uint32(id)))
+	if err != nil {
+		cache[id] = nil
+		return nil
+	}
+
+	stack := make([]uint64, 0, len(value)/8)
+	for i := 0; i+8 <= len(value); i += 8 {
+		addr := binary.LittleEndian.Uint64(value[i:])
+		if addr == 0 {
+			break
+		}
+		stack = append(stack, addr)
+	}
+
+	cache[id] = stack
+	return stack
+}
+
+// Frames returns the symbolized frames of the stack from the leaf to the
+// root, the kernel frames are suffixed with "_[k]", and the root is the comm.
+func Frames(stack *StackCount, u *symbol.Usym) []string {
+	frames := []string{}
+
+	if len(stack.Kstack) > 0 {
+		for _, frame := range symbol.DumpKernelBackTrace(stack.Kstack, len(stack.Kstack)).BackTrace {
+			frames = append(frames, frame+kernelFrameSuffix)
+		}
+	}
+
+	for _, addr := range stack.Ustack {
+		if usym := u.ResolveUstack(addr, stack.Key.Pid); usym != "" {
+			frames = append(frames, usym)
+		}
+	}
+
+	return append(frames, strings.TrimRight(string(stack.Key.Comm[:]), "\x00"))
+}
+
+// StacktraceSamples converts the stacks into the pyroscope samples, and the
+// function names the samples refer to.
+func StacktraceSamples(stacks []StackCount, u *symbol.Usym) ([]*ingestv1.StacktraceSample, []string) {
+	samples := make([]*ingestv1.StacktraceSample, 0, len(stacks))
+	names := []string{}
+	nameIDs := map[string]int32{}
+
+	for i := range stacks {
+		sample := &ingestv1.StacktraceSample{Value: int64(stacks[i].Count)}
+		for _, frame := range Frames(&stacks[i], u) {
+			id, ok := nameIDs[frame]
+			if !ok {
+				id = int32(len(names))
+				nameIDs[frame] = id
+				names = append(names, frame)
+			}
+			sample.FunctionIds = append(sample.FunctionIds, id)
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples, names
+}
+
+// WriteFolded writes the stacks in the folded format, one "root;...;leaf count"
+// per line, the same stacks of the different processes are merged.
+func WriteFolded(w io.Writer, stacks []StackCount, u *symbol.Usym) error {
+	folded := map[string]uint64{}
+	for i := range stacks {
+		frames := Frames(&stacks[i], u)
+		for l, r := 0, len(frames)-1; l < r; l, r = l+1, r-1 {
+			frames[l], frames[r] = frames[r], frames[l]
+		}
+
+		for j, frame := range frames {
+			frames[j] = foldedFrame(frame)
+		}
+		folded[strings.Join(frames, ";")] += stacks[i].Count
+	}
+
+	lines := make([]string, 0, len(folded))
+	for stack := range folded {
+		lines = append(lines, stack)
+	}
+	sort.Strings(lines)
+
+	for _, stack := range lines {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, folded[stack]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ';' is the separator of the frames, and ' ' of the count.
+func foldedFrame(frame string) string {
+	return foldedReplacer.Replace(frameName(frame))
+}
+
+// frameName trims the address of the kernel frame, e.g.
+// "tcp_v4_rcv/ffffffff81b2c3d0 [kernel]_[k]" is trimmed into "tcp_v4_rcv_[k]".
+func frameName(frame string) string {
+	if strings.HasSuffix(frame, kernelFrameSuffix) {
+		if name, _, ok := strings.Cut(frame, "/"); ok {
+			frame = name + kernelFrameSuffix
+		}
+	}
+
+	return frame
+}
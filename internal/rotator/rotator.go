@@ -15,69 +15,463 @@
 package rotator
 
 import (
+	"compress/gzip"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"gopkg.in/natefinch/lumberjack.v2"
+	"github.com/klauspost/compress/zstd"
 )
 
-type fileRotator struct {
-	// Filename is the file to write logs to.  Backup log files will be retained
-	// in the same directory.  It uses <processname>-lumberjack.log in
-	// os.TempDir() if empty.
-	// Backups use the log file name given to Logger, in the form
-	// `name-timestamp.ext` where name is the filename without the extension,
-	// timestamp is the time at which the log was rotated formatted with the
-	// time.Time format of `2006-01-02T15-04-05.000` and the extension is the
-	// original extension.  For example, if your Logger.Filename is
-	// `/var/log/foo/server.log`, a backup created at 6:30pm on Nov 11 2016 would
-	// use the filename `/var/log/foo/server-2016-11-04T18-30-00.000.log`
-	//
-	// Filename string `json:"filename" yaml:"filename"`
-
-	// MaxSize is the maximum size in megabytes of the log file before it gets
-	// rotated. It defaults to 100 megabytes.
-	// MaxSize int `json:"maxsize" yaml:"maxsize"`
-
-	// MaxAge is the maximum number of days to retain old log files based on the
-	// timestamp encoded in their filename.  Note that a day is defined as 24
-	// hours and may not exactly correspond to calendar days due to daylight
-	// savings, leap seconds, etc. The default is not to remove old log files
-	// based on age.
-	// MaxAge int `json:"maxage" yaml:"maxage"`
-
-	// MaxBackups is the maximum number of old log files to retain.  The default
-	// is to retain all old log files (though MaxAge may still cause them to get
-	// deleted.)
-	// MaxBackups int `json:"maxbackups" yaml:"maxbackups"`
-
-	// LocalTime determines if the time used for formatting the timestamps in
-	// backup files is the computer's local time.  The default is to use UTC
-	// time.
-	// LocalTime bool `json:"localtime" yaml:"localtime"`
-
-	// Compress determines if the rotated log files should be compressed
-	// using gzip. The default is not to perform compression.
-	// Compress bool `json:"compress" yaml:"compress"`
-	logger *lumberjack.Logger
-}
-
-// NewSizeRotator create a rotatable logger
-func NewSizeRotator(path string, maxRotation, rotationSize int) io.WriteCloser {
-	return &fileRotator{
-		&lumberjack.Logger{
-			Filename:   path,
-			MaxSize:    rotationSize,
-			MaxBackups: maxRotation,
-			LocalTime:  true,
-			Compress:   false,
-		},
-	}
-}
-
-func (r *fileRotator) Write(data []byte) (n int, err error) {
-	return r.logger.Write(data)
-}
-
-func (r *fileRotator) Close() error {
-	return r.logger.Close()
+const (
+	// BackupTimeFormat is the time format in the name of the rotated files,
+	// in the form `name-timestamp.ext`, e.g. `oom-2025-01-02T15-04-05.000`.
+	BackupTimeFormat = "2006-01-02T15-04-05.000"
+
+	megabyte = 1024 * 1024
+)
+
+// Compression is the algorithm to compress the rotated files.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// Suffix returns the file suffix of the compression.
+func (c Compression) Suffix() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// ParseCompression validates the compression name.
+func ParseCompression(name string) (Compression, error) {
+	switch c := Compression(name); c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return c, nil
+	default:
+		return CompressionNone, fmt.Errorf("invalid compression %q, must be one of gzip, zstd or empty", name)
+	}
+}
+
+// Option configures the size rotator.
+type Option func(r *sizeRotator)
+
+// WithCompression compresses the rotated files.
+func WithCompression(c Compression) Option {
+	return func(r *sizeRotator) {
+		r.compression = c
+	}
+}
+
+// WithDiskBudget limits the total size of the files in the same directory,
+// the budget can be shared by the rotators.
+func WithDiskBudget(b *DiskBudget) Option {
+	return func(r *sizeRotator) {
+		r.budget = b
+	}
+}
+
+type sizeRotator struct {
+	mu          sync.Mutex
+	filename    string
+	maxSize     int64
+	maxBackups  int
+	compression Compression
+	budget      *DiskBudget
+
+	file *os.File
+	size int64
+
+	// the rotated files are compressed and removed in background.
+	millCh   chan struct{}
+	millOnce sync.Once
+}
+
+// NewSizeRotator creates a writer which rotates the file when it is larger
+// than rotationSize megabytes, and keeps maxRotation rotated files at most.
+func NewSizeRotator(path string, maxRotation, rotationSize int, opts ...Option) io.WriteCloser {
+	r := &sizeRotator{
+		filename:   path,
+		maxSize:    int64(rotationSize) * megabyte,
+		maxBackups: maxRotation,
+		millCh:     make(chan struct{}, 1),
+	}
+
+	if r.maxSize <= 0 {
+		r.maxSize = 100 * megabyte
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *sizeRotator) Write(data []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if int64(len(data)) > r.maxSize {
+		return 0, fmt.Errorf("write length %d exceeds maximum file size %d", len(data), r.maxSize)
+	}
+
+	if r.file == nil {
+		if err := r.openExistingOrNew(len(data)); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = r.file.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *sizeRotator) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.close()
+}
+
+func (r *sizeRotator) close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *sizeRotator) openExistingOrNew(writeLen int) error {
+	info, err := os.Stat(r.filename)
+	if os.IsNotExist(err) {
+		return r.openNew()
+	}
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	if info.Size()+int64(writeLen) >= r.maxSize {
+		return r.rotate()
+	}
+
+	file, err := os.OpenFile(r.filename, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return r.openNew()
+	}
+
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *sizeRotator) openNew() error {
+	if err := os.MkdirAll(filepath.Dir(r.filename), 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	file, err := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+
+	r.file, r.size = file, 0
+	return nil
+}
+
+func (r *sizeRotator) rotate() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(r.filename); err == nil {
+		if err := os.Rename(r.filename, backupName(r.filename, time.Now())); err != nil {
+			return fmt.Errorf("rename file: %w", err)
+		}
+	}
+
+	if err := r.openNew(); err != nil {
+		return err
+	}
+
+	r.mill()
+	return nil
+}
+
+func backupName(name string, t time.Time) string {
+	dir := filepath.Dir(name)
+	filename := filepath.Base(name)
+	ext := filepath.Ext(filename)
+	prefix := filename[:len(filename)-len(ext)]
+
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, t.Format(BackupTimeFormat), ext))
+}
+
+// mill triggers the compressing and removing of the rotated files.
+func (r *sizeRotator) mill() {
+	r.millOnce.Do(func() {
+		go func() {
+			for range r.millCh {
+				r.millRun()
+			}
+		}()
+	})
+
+	select {
+	case r.millCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *sizeRotator) millRun() {
+	backups, err := r.backups()
+	if err != nil {
+		return
+	}
+
+	// the newest first
+	for i, b := range backups {
+		if r.maxBackups > 0 && i >= r.maxBackups {
+			_ = os.Remove(b.path)
+			continue
+		}
+
+		if r.compression != CompressionNone && b.compression == CompressionNone {
+			_ = compressFile(b.path, b.path+r.compression.Suffix(), r.compression)
+		}
+	}
+
+	if r.budget != nil {
+		r.budget.enforce()
+	}
+}
+
+// backups returns the rotated files of this rotator, the newest first.
+func (r *sizeRotator) backups() ([]backupFile, error) {
+	dir := filepath.Dir(r.filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(r.filename)
+	var backups []backupFile
+	for _, entry := range entries {
+		b, ok := parseBackup(dir, entry)
+		if ok && b.base == base {
+			backups = append(backups, b)
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	return backups, nil
+}
+
+type backupFile struct {
+	path        string
+	base        string // the name of the active file
+	time        time.Time
+	size        int64
+	compression Compression
+}
+
+// parseBackup parses the rotated file name `name-timestamp.ext[.gz|.zst]`.
+func parseBackup(dir string, entry os.DirEntry) (backupFile, bool) {
+	if !entry.Type().IsRegular() {
+		return backupFile{}, false
+	}
+
+	filename := entry.Name()
+	compression := CompressionNone
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		if strings.HasSuffix(filename, c.Suffix()) {
+			filename, compression = strings.TrimSuffix(filename, c.Suffix()), c
+			break
+		}
+	}
+
+	base, t, ok := ParseBackupName(filename)
+	if !ok {
+		return backupFile{}, false
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return backupFile{}, false
+	}
+
+	return backupFile{
+		path:        filepath.Join(dir, entry.Name()),
+		base:        base,
+		time:        t,
+		size:        info.Size(),
+		compression: compression,
+	}, true
+}
+
+// ParseBackupName returns the name of the active file and the rotated time
+// of the uncompressed backup file name.
+func ParseBackupName(filename string) (string, time.Time, bool) {
+	// the file without extension, or `name-timestamp.ext`
+	for _, ext := range []string{"", filepath.Ext(filename)} {
+		prefix := strings.TrimSuffix(filename, ext)
+		sep := len(prefix) - len(BackupTimeFormat) - 1
+		if sep <= 0 || prefix[sep] != '-' {
+			continue
+		}
+
+		t, err := time.ParseInLocation(BackupTimeFormat, prefix[sep+1:], time.Local)
+		if err != nil {
+			continue
+		}
+
+		return prefix[:sep] + ext, t, true
+	}
+
+	return "", time.Time{}, false
+}
+
+func compressFile(src, dst string, c Compression) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var w io.WriteCloser
+	switch c {
+	case CompressionGzip:
+		w = gzip.NewWriter(out)
+	case CompressionZstd:
+		w, err = zstd.NewWriter(out)
+		if err != nil {
+			out.Close()
+			_ = os.Remove(dst)
+			return err
+		}
+	default:
+		out.Close()
+		_ = os.Remove(dst)
+		return fmt.Errorf("unknown compression %q", c)
+	}
+
+	_, err = io.Copy(w, in)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// NewDecompressReader returns the reader of the file by its compression suffix.
+func NewDecompressReader(path string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, CompressionGzip.Suffix()):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(path, CompressionZstd.Suffix()):
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// DiskBudget limits the total size of the files in a directory, the oldest
+// rotated files are removed when the budget is exceeded, the active files
+// are never removed.
+type DiskBudget struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+}
+
+// NewDiskBudget creates a budget of maxSize megabytes for the directory.
+func NewDiskBudget(dir string, maxSize int) *DiskBudget {
+	return &DiskBudget{dir: dir, maxSize: int64(maxSize) * megabyte}
+}
+
+func (b *DiskBudget) enforce() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return
+	}
+
+	var (
+		total   int64
+		backups []backupFile
+	)
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		if bf, ok := parseBackup(b.dir, entry); ok {
+			backups = append(backups, bf)
+			total += bf.size
+			continue
+		}
+
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+	}
+
+	// the oldest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.Before(backups[j].time)
+	})
+
+	for _, bf := range backups {
+		if total <= b.maxSize {
+			break
+		}
+
+		if err := os.Remove(bf.path); err == nil {
+			total -= bf.size
+		}
+	}
 }
@@ -0,0 +1,228 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rotator
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRotator creates a rotator whose size limit is in bytes.
+func newTestRotator(t *testing.T, path string, maxBackups int, maxSize int64, opts ...Option) *sizeRotator {
+	t.Helper()
+
+	r := NewSizeRotator(path, maxBackups, 1, opts...).(*sizeRotator)
+	r.maxSize = maxSize
+	t.Cleanup(func() { _ = r.Close() })
+	return r
+}
+
+func mustWrite(t *testing.T, w io.Writer, data string) {
+	t.Helper()
+
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// rotateN writes n records, each of them fills a whole file, the backups
+// are named by the time in milliseconds, so sleep to not reuse the names.
+func rotateN(t *testing.T, r *sizeRotator, n int, record string) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		mustWrite(t, r, record)
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// waitFor polls the condition, the rotated files are handled in background.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the rotated files")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func listBackups(t *testing.T, r *sizeRotator) []backupFile {
+	t.Helper()
+
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	return backups
+}
+
+func TestSizeRotatorMaxBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oom.log")
+	r := newTestRotator(t, path, 2, 10)
+
+	rotateN(t, r, 5, "0123456789")
+
+	waitFor(t, func() bool { return len(listBackups(t, r)) == 2 })
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read active file: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Fatalf("active file = %q, want the last record", data)
+	}
+
+	for _, b := range listBackups(t, r) {
+		if b.compression != CompressionNone {
+			t.Fatalf("backup %s is compressed without compression", b.path)
+		}
+	}
+}
+
+func TestSizeRotatorCompression(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "oom.log")
+			r := newTestRotator(t, path, 0, 10, WithCompression(c))
+
+			rotateN(t, r, 2, "0123456789")
+
+			waitFor(t, func() bool {
+				backups := listBackups(t, r)
+				return len(backups) == 1 && backups[0].compression == c
+			})
+
+			b := listBackups(t, r)[0]
+			if !strings.HasSuffix(b.path, c.Suffix()) {
+				t.Fatalf("backup %s without suffix %s", b.path, c.Suffix())
+			}
+			if _, err := os.Stat(strings.TrimSuffix(b.path, c.Suffix())); !os.IsNotExist(err) {
+				t.Fatalf("uncompressed backup is not removed: %v", err)
+			}
+
+			f, err := os.Open(b.path)
+			if err != nil {
+				t.Fatalf("open backup: %v", err)
+			}
+			defer f.Close()
+
+			dr, err := NewDecompressReader(b.path, f)
+			if err != nil {
+				t.Fatalf("decompress reader: %v", err)
+			}
+			defer dr.Close()
+
+			data, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if string(data) != "0123456789" {
+				t.Fatalf("decompressed %q, want the first record", data)
+			}
+		})
+	}
+}
+
+func TestDiskBudget(t *testing.T) {
+	dir := t.TempDir()
+	budget := NewDiskBudget(dir, 1)
+	budget.maxSize = 35
+
+	oom := newTestRotator(t, filepath.Join(dir, "oom.log"), 0, 10, WithDiskBudget(budget))
+	dload := newTestRotator(t, filepath.Join(dir, "dload.log"), 0, 10, WithDiskBudget(budget))
+
+	// 3 backups of oom, the older than the backups of dload.
+	rotateN(t, oom, 4, "0123456789")
+	rotateN(t, dload, 3, "0123456789")
+
+	// 2 active files and 5 backups of 10 bytes, only the newest backup
+	// of dload is kept within 35 bytes.
+	waitFor(t, func() bool {
+		return len(listBackups(t, oom)) == 0 && len(listBackups(t, dload)) == 1
+	})
+
+	for _, name := range []string{"oom.log", "dload.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("active file %s is removed: %v", name, err)
+		}
+	}
+}
+
+func TestSizeRotatorReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oom.log")
+
+	r := newTestRotator(t, path, 0, 10)
+	mustWrite(t, r, "01234")
+	if err := r.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// the existing file is appended after restart.
+	r = newTestRotator(t, path, 0, 10)
+	mustWrite(t, r, "567")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read active file: %v", err)
+	}
+	if string(data) != "01234567" {
+		t.Fatalf("active file = %q, want the appended data", data)
+	}
+
+	// the size of the existing file is counted.
+	mustWrite(t, r, "89a")
+	waitFor(t, func() bool { return len(listBackups(t, r)) == 1 })
+
+	data, err = os.ReadFile(listBackups(t, r)[0].path)
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if !bytes.Equal(data, []byte("01234567")) {
+		t.Fatalf("backup = %q, want the data before restart", data)
+	}
+}
+
+func TestParseBackupName(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local)
+
+	tests := []struct {
+		filename string
+		base     string
+		ok       bool
+	}{
+		{"oom-2025-01-02T15-04-05.000.log", "oom.log", true},
+		{"oom-2025-01-02T15-04-05.000", "oom", true},
+		{"oom.log", "", false},
+		{"oom-2025-01-02.log", "", false},
+	}
+
+	for _, tt := range tests {
+		base, got, ok := ParseBackupName(tt.filename)
+		if ok != tt.ok || base != tt.base {
+			t.Fatalf("ParseBackupName(%q) = %q, %v, want %q, %v", tt.filename, base, ok, tt.base, tt.ok)
+		}
+		if ok && !got.Equal(ts) {
+			t.Fatalf("ParseBackupName(%q) time = %v, want %v", tt.filename, got, ts)
+		}
+	}
+}
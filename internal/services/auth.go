@@ -0,0 +1,275 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"huatuo-bamai/internal/command/container"
+	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role is the permission of the API callers, the admin has all the
+// permissions of the viewer.
+type Role string
+
+const (
+	// RoleViewer reads the metrics, events and the tasks.
+	RoleViewer Role = "viewer"
+	// RoleAdmin changes the config, starts and stops the tasks and tracers.
+	RoleAdmin Role = "admin"
+)
+
+// ServerOption is the options of the API server.
+type ServerOption struct {
+	Addr string
+	// CertFile and KeyFile serve HTTPS if both set.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile verifies the client certificates, mTLS, if set.
+	ClientCAFile string
+	// Tokens are the bearer tokens and their roles, the authentication is
+	// disabled if empty.
+	Tokens map[string]string
+}
+
+func (o *ServerOption) tlsEnabled() bool {
+	return o.CertFile != "" && o.KeyFile != ""
+}
+
+// authenticator checks the client certificates and the bearer tokens, the
+// tokens are replaced as a whole when changed in the config.
+type authenticator struct {
+	tokens        atomic.Pointer[map[string]Role]
+	requireCert   bool
+	tlsEnabled    bool
+	internalToken string
+}
+
+func parseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleViewer, RoleAdmin:
+		return Role(s), nil
+	default:
+		return "", fmt.Errorf("invalid role %q, must be %s or %s", s, RoleViewer, RoleAdmin)
+	}
+}
+
+func newAuthenticator(opt *ServerOption) (*authenticator, error) {
+	if opt.ClientCAFile != "" && !opt.tlsEnabled() {
+		return nil, fmt.Errorf("the client CA requires the CertFile and KeyFile")
+	}
+
+	a := &authenticator{
+		requireCert: opt.ClientCAFile != "",
+		tlsEnabled:  opt.tlsEnabled(),
+	}
+
+	if err := a.setTokens(opt.Tokens); err != nil {
+		return nil, err
+	}
+
+	// the tools started by the agent call back the API on the loopback, which
+	// have no client certificates.
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	a.internalToken = hex.EncodeToString(buf)
+
+	return a, nil
+}
+
+// setTokens replaces the bearer tokens.
+func (a *authenticator) setTokens(tokens map[string]string) error {
+	parsed := make(map[string]Role, len(tokens))
+	for token, s := range tokens {
+		role, err := parseRole(s)
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return fmt.Errorf("empty token of role %s", role)
+		}
+		parsed[token] = role
+	}
+
+	if len(parsed) != 0 && !a.tlsEnabled {
+		log.Warnf("the bearer tokens are sent in plaintext, set APIServer.TLS to serve HTTPS")
+	}
+
+	a.tokens.Store(&parsed)
+	return nil
+}
+
+func (a *authenticator) enabled() bool {
+	return len(*a.tokens.Load()) != 0 || a.requireCert
+}
+
+// tokenRole returns the role of the token, the tokens are compared in
+// constant time.
+func (a *authenticator) tokenRole(token string) (Role, bool) {
+	var (
+		role  Role
+		found bool
+	)
+
+	for t, r := range *a.tokens.Load() {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			role, found = r, true
+		}
+	}
+
+	return role, found
+}
+
+func isLoopback(ctx *gin.Context) bool {
+	ip := net.ParseIP(ctx.RemoteIP())
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize returns the middleware requiring the role.
+func (a *authenticator) authorize(required Role) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !a.enabled() {
+			ctx.Next()
+			return
+		}
+
+		token, hasToken := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+
+		// the tools started by the agent are viewers.
+		if hasToken && isLoopback(ctx) &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(a.internalToken)) == 1 {
+			if required != RoleViewer {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		if a.requireCert && (ctx.Request.TLS == nil || len(ctx.Request.TLS.VerifiedChains) == 0) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client certificate required"})
+			return
+		}
+
+		// the verified clients have all the permissions if no tokens.
+		if len(*a.tokens.Load()) == 0 {
+			ctx.Next()
+			return
+		}
+
+		role, ok := a.tokenRole(token)
+		if !hasToken || !ok {
+			ctx.Header("WWW-Authenticate", `Bearer realm="huatuo-bamai"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if required == RoleAdmin && role != RoleAdmin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// ReloadConfig rebuilds the bearer tokens if they are changed in the config,
+// the other keys of APIServer take effect after the restart.
+func ReloadConfig(changedKeys []string) {
+	if instance == nil || instance.auth == nil {
+		return
+	}
+
+	if !slices.Contains(changedKeys, "APIServer.Tokens") {
+		return
+	}
+
+	if err := instance.auth.setTokens(conf.Get().APIServer.Tokens); err != nil {
+		log.Errorf("reload the api tokens: %v", err)
+		return
+	}
+
+	log.Infof("reload the api tokens")
+}
+
+// exportEnv exports the address and the token of the API server to the tools
+// started by the agent.
+func (a *authenticator) exportEnv(opt *ServerOption) error {
+	scheme := "http"
+	if opt.tlsEnabled() {
+		scheme = "https"
+	}
+
+	host, port, err := net.SplitHostPort(opt.Addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	if err := os.Setenv(container.EnvServerAddr, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))); err != nil {
+		return err
+	}
+	return os.Setenv(container.EnvServerToken, a.internalToken)
+}
+
+// tlsConfig loads the certificates, the client certificates are verified if
+// given, and required by the authorize except the tools of the agent.
+func tlsConfig(opt *ServerOption) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(opt.CertFile, opt.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load the key pair: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if opt.ClientCAFile != "" {
+		data, err := os.ReadFile(opt.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read the client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in the client CA %s", opt.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"net/http"
+
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CapabilitiesResp represents the capabilities response.
+type CapabilitiesResp struct {
+	Host *capability.Host `json:"host"`
+	// Skipped the tracers not registered, and the reasons.
+	Skipped map[string]string `json:"skipped"`
+}
+
+// Capabilities handles the host capabilities request.
+func Capabilities(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, &CapabilitiesResp{
+		Host:    capability.HostCapabilities(),
+		Skipped: tracing.SkippedTracing(),
+	})
+}
@@ -15,8 +15,8 @@
 package config
 
 import (
+	"encoding/json"
 	"net/http"
-	"reflect"
 
 	"huatuo-bamai/internal/conf"
 	"huatuo-bamai/internal/log"
@@ -30,24 +30,68 @@ type Request struct {
 	Config map[string]any `json:"config"`
 }
 
+// Response is the result of the config updated.
+type Response struct {
+	Changed   []string `json:"changed"`
+	Restarted []string `json:"restarted"`
+}
+
+// ReloadFunc reloads the tracers whose config are changed, and returns the
+// names of the restarted tracers.
+type ReloadFunc func(changedKeys []string) []string
+
+// Dump returns the effective config, the secrets are masked.
+func Dump(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, conf.Redacted(conf.Get()))
+}
+
 // Config set config param and sync to file
-func Config(ctx *gin.Context) {
-	req := Request{}
-	if err := ctx.BindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, request.ErrorResponse{Message: err.Error()})
-		return
+func Config(reload ReloadFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req := Request{}
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, request.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		if _, ok := update(ctx, req.Config, reload); ok {
+			ctx.JSON(http.StatusNoContent, nil)
+		}
 	}
+}
 
-	for k, v := range req.Config {
-		if reflect.ValueOf(v).Kind() == reflect.Float64 {
-			v = int(v.(float64))
+// Patch updates the config by the keys, e.g. {"Tracing.Dload.MonitorGap": 10},
+// or the nested objects, e.g. {"Tracing": {"Dload": {"MonitorGap": 10}}}.
+func Patch(reload ReloadFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		patch := map[string]any{}
+
+		// keep the precision of uint64
+		d := json.NewDecoder(ctx.Request.Body)
+		d.UseNumber()
+		if err := d.Decode(&patch); err != nil {
+			ctx.JSON(http.StatusBadRequest, request.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		if resp, ok := update(ctx, patch, reload); ok {
+			ctx.JSON(http.StatusOK, resp)
 		}
-		conf.Set(k, v)
 	}
+}
+
+func update(ctx *gin.Context, patch map[string]any, reload ReloadFunc) (*Response, bool) {
+	changed, err := conf.Update(patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, request.ErrorResponse{Message: err.Error()})
+		return nil, false
+	}
+
 	if err := conf.Sync(); err != nil {
 		log.Warnf("config sync error: %v", err)
 		ctx.JSON(http.StatusInternalServerError, request.ErrorResponse{Message: err.Error()})
-		return
+		return nil, false
 	}
-	ctx.JSON(http.StatusNoContent, nil)
+
+	return &Response{Changed: changed, Restarted: reload(changed)}, true
 }
@@ -0,0 +1,91 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"huatuo-bamai/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = storage.MaxQueryLimit
+)
+
+// parseEventsTime parses the time in RFC3339, unix seconds, or the duration
+// before now, e.g. 30m.
+func parseEventsTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC3339, unix seconds or duration", s)
+}
+
+// EventsList returns the tracer records saved in the local files.
+func EventsList(ctx *gin.Context) {
+	since, err := parseEventsTime(ctx.Query("since"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "since: " + err.Error()})
+		return
+	}
+
+	until, err := parseEventsTime(ctx.Query("until"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "until: " + err.Error()})
+		return
+	}
+
+	limit := defaultEventsLimit
+	if s := ctx.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxEventsLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be in [1, %d]", maxEventsLimit)})
+			return
+		}
+	}
+
+	docs, err := storage.QueryLocalFiles(&storage.Query{
+		TracerName:  ctx.Query("tracer"),
+		ContainerID: ctx.Query("container_id"),
+		Since:       since,
+		Until:       until,
+		Limit:       limit,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"events": docs})
+}
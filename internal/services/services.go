@@ -15,6 +15,7 @@
 package services
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -36,6 +37,8 @@ var instance *Server
 // Server http server instance
 type Server struct {
 	server       *gin.Engine
+	tlsConfig    *tls.Config
+	auth         *authenticator
 	mgrTracing   *tracing.MgrTracingEvent
 	promRegistry *prometheus.Registry
 }
@@ -66,23 +69,36 @@ func (rl *RateLimiter) Limit() gin.HandlerFunc {
 }
 
 // NewServer new http instance
-func NewServer() *Server {
+func NewServer(opt *ServerOption) (*Server, error) {
 	gin.SetMode(gin.ReleaseMode)
 
+	auth, err := newAuthenticator(opt)
+	if err != nil {
+		return nil, err
+	}
+
 	instance = &Server{
 		server: gin.New(),
+		auth:   auth,
+	}
+
+	if opt.tlsEnabled() {
+		if instance.tlsConfig, err = tlsConfig(opt); err != nil {
+			return nil, err
+		}
 	}
 
 	// middleware: log, recovery, pprof
-	pprof.Register(instance.server)
+	pprof.Register(instance.server.Group("", auth.authorize(RoleAdmin)))
 	limiter := NewRateLimiter(200, 200)
 	instance.server.Use(gin.Logger(), gin.Recovery(), limiter.Limit())
-	return instance
+	return instance, nil
 }
 
-// AddHandler add a new handler
-func (s *Server) AddHandler(method, path string, handlerFunc gin.HandlerFunc) {
-	s.server.Handle(method, path, handlerFunc)
+// AddHandler add a new handler, which requires the role if the authentication
+// is enabled.
+func (s *Server) AddHandler(method, path string, role Role, handlerFunc gin.HandlerFunc) {
+	s.server.Handle(method, path, s.auth.authorize(role), handlerFunc)
 }
 
 // Run tcp server
@@ -110,7 +126,11 @@ func (s *Server) Run(addr string) error {
 		return fmt.Errorf("set sockopt %w", err)
 	}
 
-	if err := s.server.RunListener(tcpListener); err != nil {
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(tcpListener, s.tlsConfig)
+	}
+
+	if err := s.server.RunListener(listener); err != nil {
 		log.Errorf("Server error: %s", err.Error())
 		return err
 	}
@@ -118,27 +138,48 @@ func (s *Server) Run(addr string) error {
 }
 
 // Start start API service
-func Start(addr string, mgrTracing *tracing.MgrTracingEvent, promRegistry *prometheus.Registry) {
-	s := NewServer()
+func Start(opt *ServerOption, mgrTracing *tracing.MgrTracingEvent, promRegistry *prometheus.Registry) error {
+	s, err := NewServer(opt)
+	if err != nil {
+		return err
+	}
 	s.mgrTracing = mgrTracing
 	s.promRegistry = promRegistry
 
-	s.AddHandler("POST", "/config", config.Config)
-	s.AddHandler("GET", "/metrics", CollectorDo)
-	s.AddHandler("POST", "/task/start", NewTask)
-	s.AddHandler("GET", "/task/result", TaskResult)
-	s.AddHandler("POST", "/task/stop", TaskStop)
-	s.AddHandler("GET", "/containers/json", ContainersList)
+	if err := s.auth.exportEnv(opt); err != nil {
+		return fmt.Errorf("export the api server env: %w", err)
+	}
+
+	// the config includes the credentials of the storages.
+	s.AddHandler("GET", "/config", RoleAdmin, config.Dump)
+	reload := func(changedKeys []string) []string {
+		ReloadConfig(changedKeys)
+		return mgrTracing.MgrTracingEventRestartByConfig(changedKeys)
+	}
+	s.AddHandler("POST", "/config", RoleAdmin, config.Config(reload))
+	s.AddHandler("PATCH", "/config", RoleAdmin, config.Patch(reload))
+	s.AddHandler("GET", "/metrics", RoleViewer, CollectorDo)
+	s.AddHandler("POST", "/task/start", RoleAdmin, NewTask)
+	s.AddHandler("GET", "/task/result", RoleViewer, TaskResult)
+	s.AddHandler("GET", "/task/stream", RoleViewer, TaskStream)
+	s.AddHandler("GET", "/task/list", RoleViewer, TaskList)
+	s.AddHandler("GET", "/task/catalog", RoleViewer, TaskCatalog)
+	s.AddHandler("POST", "/task/stop", RoleAdmin, TaskStop)
+	s.AddHandler("GET", "/containers/json", RoleViewer, ContainersList)
+	s.AddHandler("GET", "/events", RoleViewer, EventsList)
+	s.AddHandler("GET", "/capabilities", RoleViewer, Capabilities)
 
 	// will be removed
-	s.AddHandler("GET", "/tracer", TracerList)
-	s.AddHandler("POST", "/tracer/start", TracerStart)
-	s.AddHandler("POST", "/tracer/stop", TracerStop)
-	s.AddHandler("POST", "/tracer/stop_all", TracerStopAll)
+	s.AddHandler("GET", "/tracer", RoleViewer, TracerList)
+	s.AddHandler("POST", "/tracer/start", RoleAdmin, TracerStart)
+	s.AddHandler("POST", "/tracer/stop", RoleAdmin, TracerStop)
+	s.AddHandler("POST", "/tracer/stop_all", RoleAdmin, TracerStopAll)
 
 	go func() {
-		if err := s.Run(addr); err != nil {
+		if err := s.Run(opt.Addr); err != nil {
 			log.Errorf("start tcp api server: %v", err)
 		}
 	}()
+
+	return nil
 }
@@ -16,22 +16,27 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"huatuo-bamai/internal/conf"
+	"huatuo-bamai/internal/storage"
 	"huatuo-bamai/pkg/tracing"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 )
 
 // NewTaskReq represents the structure of the request body for creating a new task.
 type NewTaskReq struct {
-	TracerName string   `json:"tracer_name" binding:"required"`            // Name of the tracer, required field
-	Timeout    int      `json:"timeout" binding:"required,number,lt=3600"` // Timeout in seconds, must be less than 3600s(1 hour)
-	DataType   string   `json:"data_type" binding:"required"`              // Type of data to be handled, required field
-	TracerArgs []string `json:"trace_args" binding:"omitempty"`            // Additional arguments for the tracer, optional field
+	TracerName string         `json:"tracer_name" binding:"required"`             // Name of the tool in the task catalogue, required field
+	Timeout    int            `json:"timeout" binding:"omitempty,number,lt=3600"` // Timeout in seconds, must be less than 3600s(1 hour), the default of the tool if 0, no shorter than the duration of the tool
+	DataType   string         `json:"data_type" binding:"required"`               // Type of data to be handled, required field
+	Args       map[string]any `json:"args" binding:"omitempty"`                   // Arguments of the tool by the name, optional field
+	TracerArgs []string       `json:"trace_args" binding:"omitempty"`             // Arguments in the command line, e.g. --mode=offcpu, optional field
 }
 
 // StopTaskReq represents the structure of the request body for stopping a task.
@@ -68,11 +73,51 @@ func NewTask(ctx *gin.Context) {
 		storageDefault = tracing.TaskStorageStdout
 	}
 
-	id := tracing.NewTask(req.TracerName, time.Duration(req.Timeout)*time.Second, storageDefault, req.TracerArgs)
+	tool, err := tracing.LookupTaskTool(req.TracerName)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := req.Args
+	if len(req.TracerArgs) != 0 {
+		if len(args) != 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "args and trace_args are exclusive"})
+			return
+		}
+
+		if args, err = tool.ParseArgs(req.TracerArgs); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	id, err := tracing.NewTask(tool.Name, time.Duration(req.Timeout)*time.Second, storageDefault, args)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	ctx.JSON(200, gin.H{"task_id": id})
 }
 
+// TaskCatalog returns the tools in the task catalogue, and their arguments.
+func TaskCatalog(ctx *gin.Context) {
+	tools := make([]gin.H, 0)
+	for _, tool := range tracing.TaskCatalog() {
+		tools = append(tools, gin.H{
+			"name":            tool.Name,
+			"description":     tool.Description,
+			"default_timeout": int(tool.DefaultTimeout.Seconds()),
+			"format":          tool.Format,
+			"format_arg":      tool.FormatArg,
+			"args":            tool.Args,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"tools": tools})
+}
+
 // TaskResult retrieves the result of a task.
 func TaskResult(ctx *gin.Context) {
 	id := ctx.Query("id")
@@ -88,13 +133,112 @@ func TaskResult(ctx *gin.Context) {
 
 	switch result.TaskStatus {
 	case tracing.StatusCompleted:
-		response["data"] = string(result.TaskData)
+		data, encoding := storage.EncodeOutput(result.TaskData)
+		response["data"] = data
+		response["format"] = result.TaskFormat
+		if encoding != "" {
+			response["encoding"] = encoding
+		}
+		if len(result.TaskStderr) != 0 {
+			response["stderr"] = string(result.TaskStderr)
+		}
 	case tracing.StatusNotExist, tracing.StatusFailed:
 		response["error"] = result.TaskErr.Error()
 	}
 	ctx.JSON(httpStatus, response)
 }
 
+// TaskStream streams the stdout and stderr of a task as the Server-Sent Events,
+// the event id is the index of the output chunk, and the stream is resumed from
+// the Last-Event-ID. The last event is the status of the task.
+func TaskStream(ctx *gin.Context) {
+	id := ctx.Query("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "missing task id"})
+		return
+	}
+
+	start := 0
+	if lastID := ctx.GetHeader("Last-Event-ID"); lastID != "" {
+		index, err := strconv.Atoi(lastID)
+		if err != nil || index < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+		start = index + 1
+	}
+
+	if result := tracing.Result(id); result.TaskStatus == tracing.StatusNotExist {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": result.TaskErr.Error()})
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+
+	result, err := tracing.StreamTask(ctx.Request.Context(), id, start, func(index int, chunk *tracing.TaskOutputChunk) error {
+		data, encoding := storage.EncodeOutput(chunk.Data)
+		event := gin.H{"data": data}
+		if encoding != "" {
+			event["encoding"] = encoding
+		}
+
+		ctx.Render(-1, sse.Event{Id: strconv.Itoa(index), Event: chunk.Stream, Data: event})
+		ctx.Writer.Flush()
+		return ctx.Request.Context().Err()
+	})
+	if err != nil {
+		return
+	}
+
+	status := gin.H{"status": result.TaskStatus, "format": result.TaskFormat}
+	if result.TaskErr != nil {
+		status["error"] = result.TaskErr.Error()
+	}
+	ctx.SSEvent("status", status)
+	ctx.Writer.Flush()
+}
+
+const (
+	defaultTaskListLimit = 100
+	maxTaskListLimit     = 1000
+)
+
+// TaskList returns the tasks in the task history, filtered by the name, status
+// and the created time.
+func TaskList(ctx *gin.Context) {
+	since, err := parseEventsTime(ctx.Query("since"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "since: " + err.Error()})
+		return
+	}
+
+	until, err := parseEventsTime(ctx.Query("until"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "until: " + err.Error()})
+		return
+	}
+
+	limit := defaultTaskListLimit
+	if s := ctx.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxTaskListLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be in [1, %d]", maxTaskListLimit)})
+			return
+		}
+	}
+
+	tasks := tracing.ListTasks(&tracing.TaskListQuery{
+		Name:   ctx.Query("name"),
+		Status: tracing.Status(ctx.Query("status")),
+		Since:  since,
+		Until:  until,
+		Limit:  limit,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"tasks": tasks})
+}
+
 // TaskStop stops a running task.
 func TaskStop(ctx *gin.Context) {
 	var req StopTaskReq
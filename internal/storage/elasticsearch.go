@@ -15,33 +15,123 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"strings"
+	"slices"
+	"sync/atomic"
 	"time"
 
+	"huatuo-bamai/internal/log"
+
+	"github.com/cloudflare/backoff"
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 )
 
 const (
+	esSinkName     = "elasticsearch"
 	defaultESIndex = "huatuo_bamai"
+
+	defaultESBatchSize     = 500
+	defaultESFlushInterval = 5 * time.Second
+	defaultESQueueSize     = 10000
+	defaultESMaxSpillSize  = 100 // MB
+
+	esBulkTimeout        = 30 * time.Second
+	esRetryBackoffMax    = 30 * time.Second
+	esRetryBackoffPeriod = 1 * time.Second
+
+	// the time to flush the queue on shutdown, a bulk request at least.
+	esCloseTimeout = esBulkTimeout + 10*time.Second
 )
 
+var errESQueueFull = errors.New("elasticsearch queue is full")
+
+func init() {
+	registerWriter(esSinkName, func(ctx context.Context, initCtx *InitContext) (writer, error) {
+		// only the names of the missing fields are logged, never the secrets.
+		var missing []string
+		for _, field := range []struct{ name, val string }{
+			{"EsAddresses", initCtx.EsAddresses},
+			{"EsUsername", initCtx.EsUsername},
+			{"EsPassword", initCtx.EsPassword},
+		} {
+			if field.val == "" {
+				missing = append(missing, field.name)
+			}
+		}
+
+		if len(missing) > 0 {
+			log.Warnf("elasticsearch storage config invalid, missing %v, use null device", missing)
+			return &null{}, nil
+		}
+		return newESClient(ctx, initCtx)
+	})
+}
+
+// esClient writes the documents to elasticsearch asynchronously. The
+// documents are queued in memory and sent by the bulk API in batches, the
+// batches failed after retries and the documents overflowed the queue are
+// spilled to disk, and replayed when elasticsearch is available again.
 type esClient struct {
-	client  *elasticsearch.Client
-	esIndex string
+	client        *elasticsearch.Client
+	esIndex       string
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	queue         chan []byte
+	spill         *esSpill
+	done          chan struct{} // closed when the run loop exits
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// the elasticsearch writer in use, for the statistic.
+var esWriter atomic.Pointer[esClient]
+
+// WriterStats is the statistic of the asynchronous writer.
+type WriterStats struct {
+	Queued  uint64 // documents in the memory queue
+	Spilled uint64 // documents in the spill files
+	Sent    uint64 // documents indexed successfully
+	Dropped uint64 // documents dropped as the queue and spill files are full
+	Failed  uint64 // documents rejected by elasticsearch or failed to spill
 }
 
-func newESClient(addr, username, password, index string) (*esClient, error) {
+// ESWriterStats returns the statistic of the elasticsearch writer, false
+// if the elasticsearch storage is not enabled.
+func ESWriterStats() (WriterStats, bool) {
+	e := esWriter.Load()
+	if e == nil {
+		return WriterStats{}, false
+	}
+
+	stats := WriterStats{
+		Queued:  uint64(len(e.queue)),
+		Sent:    e.sent.Load(),
+		Dropped: e.dropped.Load(),
+		Failed:  e.failed.Load(),
+	}
+	if e.spill != nil {
+		stats.Spilled = e.spill.count()
+	}
+
+	return stats, true
+}
+
+func newESClient(ctx context.Context, initCtx *InitContext) (*esClient, error) {
 	cfg := elasticsearch.Config{
-		Addresses: []string{addr},
-		Username:  username,
-		Password:  password,
+		Addresses: []string{initCtx.EsAddresses},
+		Username:  initCtx.EsUsername,
+		Password:  initCtx.EsPassword,
 		Transport: &http.Transport{
 			MaxIdleConnsPerHost:   10,
 			ResponseHeaderTimeout: 10 * time.Second,
@@ -54,53 +144,304 @@ func newESClient(addr, username, password, index string) (*esClient, error) {
 		return nil, fmt.Errorf("new client: %w", err)
 	}
 
-	// ping/check es server ...
-	res, err := client.Info()
-	if err != nil {
-		return nil, err
+	// ping/check es server, the documents are spilled if it is down.
+	if res, err := client.Info(); err != nil {
+		log.Warnf("elasticsearch %s is unavailable: %v", initCtx.EsAddresses, err)
+	} else {
+		res.Body.Close()
+		if res.IsError() {
+			log.Warnf("elasticsearch return statuscode: %d", res.StatusCode)
+		}
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return nil, fmt.Errorf("elasticsearch return statuscode: %d", res.StatusCode)
+	e := &esClient{
+		client:        client,
+		esIndex:       initCtx.EsIndex,
+		batchSize:     initCtx.EsBatchSize,
+		flushInterval: initCtx.EsFlushInterval,
+		maxRetries:    initCtx.EsMaxRetries,
+		done:          make(chan struct{}),
 	}
 
-	if index == "" {
-		index = defaultESIndex
+	if e.esIndex == "" {
+		e.esIndex = defaultESIndex
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultESBatchSize
 	}
-	return &esClient{client: client, esIndex: index}, nil
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultESFlushInterval
+	}
+	// 0 means no retry, the failed batch is spilled at once.
+	if e.maxRetries < 0 {
+		e.maxRetries = 0
+	}
+
+	queueSize := initCtx.EsQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultESQueueSize
+	}
+	e.queue = make(chan []byte, queueSize)
+
+	if initCtx.EsSpillPath != "" {
+		maxSpillSize := initCtx.EsMaxSpillSize
+		if maxSpillSize <= 0 {
+			maxSpillSize = defaultESMaxSpillSize
+		}
+
+		e.spill, err = newESSpill(initCtx.EsSpillPath, int64(maxSpillSize)*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	esWriter.Store(e)
+	go e.run(ctx)
+
+	return e, nil
 }
 
-// Write the data into ES.
+// Write queues the document, it never blocks the tracers.
 func (e *esClient) Write(doc *document) error {
 	data, err := json.Marshal(doc)
 	if err != nil {
 		return fmt.Errorf("json Marshal: %w", err)
 	}
 
-	req := esapi.IndexRequest{
-		Index:      e.esIndex,
-		DocumentID: "",
-		Body:       strings.NewReader(string(data)),
+	select {
+	case e.queue <- data:
+		return nil
+	default:
+	}
+
+	// elasticsearch is too slow or down.
+	if e.spill != nil {
+		if err := e.spill.write([][]byte{data}); err == nil {
+			return nil
+		}
+	}
+
+	e.dropped.Add(1)
+	return errESQueueFull
+}
+
+func (e *esClient) run(ctx context.Context) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, e.batchSize)
+	for {
+		select {
+		case data := <-e.queue:
+			batch = append(batch, data)
+			if len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			e.drain(batch)
+			return
+		}
+
+		if len(batch) > 0 && !e.flush(batch) {
+			batch = batch[:0]
+			continue
+		}
+
+		batch = batch[:0]
+		e.replaySpill()
+	}
+}
+
+// drain sends the batch and the queued documents on shutdown without retry,
+// the rest are spilled after a failed bulk, and replayed by the next running.
+func (e *esClient) drain(batch [][]byte) {
+	// the run loop is the only reader of the queue.
+	for len(e.queue) > 0 {
+		batch = append(batch, <-e.queue)
+	}
+
+	for len(batch) > 0 {
+		n := min(e.batchSize, len(batch))
+
+		pending, err := e.bulk(batch[:n])
+		if len(pending) != 0 {
+			log.Infof("failed to bulk %d documents into es on shutdown: %v", len(pending), err)
+			rest := slices.Concat(pending, batch[n:])
+			if e.spill == nil || e.spill.write(rest) != nil {
+				e.failed.Add(uint64(len(rest)))
+			}
+			break
+		}
+
+		batch = batch[n:]
+	}
+
+	if e.spill != nil {
+		e.spill.close()
+	}
+}
+
+// Close waits for the queued documents to be flushed after the storage
+// context is cancelled.
+func (e *esClient) Close() error {
+	select {
+	case <-e.done:
+		return nil
+	case <-time.After(esCloseTimeout):
+		return fmt.Errorf("flush the queue timeout, %d documents left", len(e.queue))
+	}
+}
+
+// flush sends the batch with retry, and returns true if all the documents
+// are accepted by elasticsearch.
+func (e *esClient) flush(batch [][]byte) bool {
+	bo := backoff.New(esRetryBackoffMax, esRetryBackoffPeriod)
+
+	pending := batch
+	for retry := 0; ; retry++ {
+		var err error
+
+		pending, err = e.bulk(pending)
+		if len(pending) == 0 {
+			return true
+		}
+
+		if retry >= e.maxRetries {
+			log.Infof("failed to bulk %d documents into es after %d retries: %v", len(pending), retry, err)
+			break
+		}
+
+		time.Sleep(bo.Duration())
+	}
+
+	if e.spill != nil {
+		if err := e.spill.write(pending); err == nil {
+			return false
+		}
+	}
+
+	e.failed.Add(uint64(len(pending)))
+	return false
+}
+
+// replaySpill sends the oldest spill file, one file at a time to not block
+// the new documents too long.
+func (e *esClient) replaySpill() {
+	if e.spill == nil || e.spill.count() == 0 {
+		return
 	}
 
-	res, err := req.Do(context.Background(), e.client)
+	name, docs, err := e.spill.oldest()
 	if err != nil {
-		return fmt.Errorf("index document: %w", err)
+		log.Infof("failed to read es spill file %s: %v", name, err)
+		if name != "" {
+			e.failed.Add(uint64(e.spill.remove(name)))
+		}
+		return
+	}
+
+	for len(docs) > 0 {
+		n := min(e.batchSize, len(docs))
+
+		pending, err := e.bulk(docs[:n])
+		if len(pending) != 0 {
+			// es is still unavailable, keep the file and try it later.
+			log.Debugf("failed to replay es spill file %s: %v", name, err)
+			return
+		}
+
+		// drop the sent documents from the file, they are not replayed
+		// again if the later chunks fail.
+		docs = docs[n:]
+		if err := e.spill.truncate(name, docs); err != nil {
+			log.Infof("failed to truncate es spill file %s: %v", name, err)
+			e.failed.Add(uint64(e.spill.remove(name)))
+			return
+		}
+	}
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func esRetriable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// bulk indexes the documents, and returns the documents need to retry.
+func (e *esClient) bulk(docs [][]byte) ([][]byte, error) {
+	body := &bytes.Buffer{}
+	for _, doc := range docs {
+		body.WriteString("{\"index\":{}}\n")
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), esBulkTimeout)
+	defer cancel()
+
+	req := esapi.BulkRequest{
+		Index: e.esIndex,
+		Body:  body,
+	}
+
+	res, err := req.Do(ctx, e.client)
+	if err != nil {
+		return docs, fmt.Errorf("bulk documents: %w", err)
 	}
 	defer res.Body.Close()
 
-	// Check the response status code
 	if res.IsError() {
 		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("index document failed with status: %s, response: %s; error: %s",
-			res.Status(), res.String(), string(body))
+		err := fmt.Errorf("bulk documents failed with status: %s, response: %s", res.Status(), string(body))
+		if esRetriable(res.StatusCode) {
+			return docs, err
+		}
+
+		e.failed.Add(uint64(len(docs)))
+		return nil, err
 	}
 
-	var r map[string]any
+	var r esBulkResponse
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		return fmt.Errorf("parse response body: %w", err)
+		return docs, fmt.Errorf("parse response body: %w", err)
+	}
+
+	if !r.Errors {
+		e.sent.Add(uint64(len(docs)))
+		return nil, nil
+	}
+
+	var retry [][]byte
+	for i, item := range r.Items {
+		if i >= len(docs) {
+			break
+		}
+
+		for _, result := range item {
+			switch {
+			case esRetriable(result.Status):
+				retry = append(retry, docs[i])
+			case result.Status >= http.StatusMultipleChoices:
+				e.failed.Add(1)
+				log.Infof("es rejected document with status %d: %s", result.Status, string(result.Error))
+			default:
+				e.sent.Add(1)
+			}
+		}
+	}
+
+	if len(retry) != 0 {
+		return retry, fmt.Errorf("%d documents need to retry", len(retry))
 	}
 
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,264 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	esSpillFileSuffix = ".ndjson"
+	// the spill file is rotated when larger than it.
+	esSpillFileSize = 4 * 1024 * 1024
+)
+
+var errESSpillFull = errors.New("elasticsearch spill files are full")
+
+// esSpill stores the documents in the files, one JSON document per line.
+// The files are named by the created time, and replayed from the oldest.
+type esSpill struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+	docs    map[string]uint64 // documents per file
+
+	cur     *os.File
+	curName string
+	curSize int64
+}
+
+func newESSpill(dir string, maxSize int64) (*esSpill, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir es spill dir: %w", err)
+	}
+
+	s := &esSpill{
+		dir:     dir,
+		maxSize: maxSize,
+		docs:    make(map[string]uint64),
+	}
+
+	// the files spilled by the last running.
+	names, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read es spill file: %w", err)
+		}
+
+		s.size += int64(len(data))
+		s.docs[name] = uint64(bytes.Count(data, []byte{'\n'}))
+	}
+
+	return s, nil
+}
+
+func (s *esSpill) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read es spill dir: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), esSpillFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *esSpill) write(docs [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, doc := range docs {
+		n += int64(len(doc)) + 1
+	}
+
+	if s.size+n > s.maxSize {
+		return errESSpillFull
+	}
+
+	if s.cur == nil || s.curSize >= esSpillFileSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(s.cur)
+	for _, doc := range docs {
+		_, _ = w.Write(doc)
+		_ = w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write es spill file: %w", err)
+	}
+
+	s.size += n
+	s.curSize += n
+	s.docs[s.curName] += uint64(len(docs))
+	return nil
+}
+
+func (s *esSpill) rotate() error {
+	if s.cur != nil {
+		_ = s.cur.Close()
+		s.cur = nil
+	}
+
+	name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), esSpillFileSuffix)
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("create es spill file: %w", err)
+	}
+
+	s.cur, s.curName, s.curSize = f, name, 0
+	return nil
+}
+
+// close closes the file in writing.
+func (s *esSpill) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cur != nil {
+		_ = s.cur.Close()
+		s.cur = nil
+	}
+}
+
+// count returns the number of the spilled documents.
+func (s *esSpill) count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n uint64
+	for _, docs := range s.docs {
+		n += docs
+	}
+	return n
+}
+
+// oldest returns the documents of the oldest spill file, the file in
+// writing is closed, and the new documents are written into a new file.
+func (s *esSpill) oldest() (string, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.files()
+	if err != nil || len(names) == 0 {
+		return "", nil, err
+	}
+
+	name := names[0]
+	if name == s.curName && s.cur != nil {
+		_ = s.cur.Close()
+		s.cur = nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return name, nil, err
+	}
+
+	var docs [][]byte
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) != 0 {
+			docs = append(docs, line)
+		}
+	}
+
+	return name, docs, nil
+}
+
+// remove deletes the spill file, and returns the number of its documents.
+func (s *esSpill) remove(name string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.dir, name)
+	if info, err := os.Stat(path); err == nil {
+		s.size -= info.Size()
+	}
+	_ = os.Remove(path)
+
+	if name == s.curName && s.cur != nil {
+		_ = s.cur.Close()
+		s.cur = nil
+	}
+
+	n := s.docs[name]
+	delete(s.docs, name)
+	return n
+}
+
+// truncate rewrites the spill file to keep only the unsent documents, so a
+// replay broken off does not send the documents already indexed again.
+func (s *esSpill) truncate(name string, docs [][]byte) error {
+	if len(docs) == 0 {
+		s.remove(name)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		buf.Write(doc)
+		buf.WriteByte('\n')
+	}
+
+	path := filepath.Join(s.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat es spill file: %w", err)
+	}
+
+	// write a temporary file without the suffix, and rename it to replace
+	// the spill file atomically.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write es spill file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("rename es spill file: %w", err)
+	}
+
+	s.size += int64(buf.Len()) - info.Size()
+	s.docs[name] = uint64(len(docs))
+	return nil
+}
@@ -0,0 +1,509 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A minimal producer of the kafka protocol, only the Metadata v1 and
+// Produce v3 (RecordBatch v2) are used, which are supported by the kafka
+// 0.11+ brokers and the most kafka-compatible message buses.
+//
+// ref: https://kafka.apache.org/protocol.html
+
+const (
+	kafkaSinkName = "kafka"
+
+	kafkaAPIProduce     int16 = 0
+	kafkaAPIMetadata    int16 = 3
+	kafkaProduceVersion int16 = 3
+	kafkaMetaVersion    int16 = 1
+
+	kafkaRecordBatchMagic = 2
+
+	defaultKafkaClientID = "huatuo-bamai"
+	defaultKafkaTimeout  = 10 * time.Second
+
+	// the max size of a response, avoid the OOM by a broken response.
+	kafkaMaxResponseSize = 16 * 1024 * 1024
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	registerWriter(kafkaSinkName, func(_ context.Context, initCtx *InitContext) (writer, error) {
+		acks, err := kafkaRequiredAcks(initCtx.KafkaRequiredAcks)
+		if err != nil {
+			return nil, err
+		}
+		return newKafkaProducer(initCtx.KafkaBrokers, initCtx.KafkaTopic, initCtx.KafkaClientID,
+			acks, initCtx.KafkaTimeout)
+	})
+}
+
+// kafkaRequiredAcks converts the acks name to the value of Produce request,
+// the leader is used by default.
+func kafkaRequiredAcks(name string) (int16, error) {
+	switch name {
+	case "none":
+		return 0, nil
+	case "", "leader":
+		return 1, nil
+	case "all":
+		return -1, nil
+	default:
+		return 0, fmt.Errorf("kafka required acks %q invalid, must be one of none, leader, all", name)
+	}
+}
+
+type kafkaPartition struct {
+	id     int32
+	leader int32
+}
+
+// kafkaProducer sends the documents to the topic, one record per request.
+// The partition is chosen by the tracer name and container id, so the
+// documents of the same tracer and container are in order.
+type kafkaProducer struct {
+	mu            sync.Mutex
+	bootstrap     []string
+	topic         string
+	clientID      string
+	acks          int16
+	timeout       time.Duration
+	correlationID int32
+
+	brokers    map[int32]string
+	partitions []kafkaPartition
+	conns      map[string]net.Conn
+}
+
+func newKafkaProducer(brokers []string, topic, clientID string, acks int16, timeout time.Duration) (*kafkaProducer, error) {
+	if len(brokers) == 0 || topic == "" {
+		return nil, errors.New("kafka brokers or topic is empty")
+	}
+
+	if clientID == "" {
+		clientID = defaultKafkaClientID
+	}
+
+	if timeout <= 0 {
+		timeout = defaultKafkaTimeout
+	}
+
+	p := &kafkaProducer{
+		bootstrap: brokers,
+		topic:     topic,
+		clientID:  clientID,
+		acks:      acks,
+		timeout:   timeout,
+		conns:     make(map[string]net.Conn),
+	}
+
+	// check the brokers and topic.
+	if err := p.refreshMetadata(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *kafkaProducer) Write(doc *document) error {
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("json Marshal: %w", err)
+	}
+
+	key := []byte(doc.TracerName + "/" + doc.ContainerID)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// retry once with the new metadata, the leader may be changed.
+	for retry := 0; ; retry++ {
+		if err = p.produce(key, value); err == nil || retry > 0 {
+			return err
+		}
+
+		p.reset()
+	}
+}
+
+func (p *kafkaProducer) produce(key, value []byte) error {
+	if len(p.partitions) == 0 {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	partition := p.partitions[h.Sum32()%uint32(len(p.partitions))]
+
+	addr, ok := p.brokers[partition.leader]
+	if !ok {
+		return fmt.Errorf("leader %d of partition %d not found", partition.leader, partition.id)
+	}
+
+	e := &kafkaEncoder{}
+	e.putInt16(-1) // transactional_id
+	e.putInt16(p.acks)
+	e.putInt32(int32(p.timeout / time.Millisecond))
+	e.putInt32(1) // topics
+	e.putString(p.topic)
+	e.putInt32(1) // partitions
+	e.putInt32(partition.id)
+	e.putBytes(encodeRecordBatch(key, value, time.Now()))
+
+	resp, err := p.roundTrip(addr, kafkaAPIProduce, kafkaProduceVersion, e.buf, p.acks != 0)
+	if err != nil || p.acks == 0 {
+		return err
+	}
+
+	d := &kafkaDecoder{buf: resp}
+	for range d.arrayLen() {
+		_ = d.string()
+		for range d.arrayLen() {
+			id, code := d.int32(), d.int16()
+			_, _ = d.int64(), d.int64() // base_offset, log_append_time
+			if d.err == nil && code != 0 {
+				return fmt.Errorf("produce to %s-%d: kafka error code %d", p.topic, id, code)
+			}
+		}
+	}
+
+	return d.err
+}
+
+// refreshMetadata fetches the brokers and the partition leaders of the topic.
+func (p *kafkaProducer) refreshMetadata() error {
+	e := &kafkaEncoder{}
+	e.putInt32(1)
+	e.putString(p.topic)
+
+	var errs []error
+	for _, addr := range p.bootstrap {
+		resp, err := p.roundTrip(addr, kafkaAPIMetadata, kafkaMetaVersion, e.buf, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		return p.parseMetadata(resp)
+	}
+
+	return fmt.Errorf("kafka metadata: %w", errors.Join(errs...))
+}
+
+func (p *kafkaProducer) parseMetadata(resp []byte) error {
+	d := &kafkaDecoder{buf: resp}
+
+	brokers := make(map[int32]string)
+	for range d.arrayLen() {
+		id, host, port := d.int32(), d.string(), d.int32()
+		_ = d.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	_ = d.int32() // controller_id
+
+	var partitions []kafkaPartition
+	for range d.arrayLen() {
+		code, name := d.int16(), d.string()
+		_ = d.int8() // is_internal
+		if d.err == nil && code != 0 {
+			return fmt.Errorf("kafka metadata of topic %s: error code %d", name, code)
+		}
+
+		for range d.arrayLen() {
+			_ = d.int16() // partition error, e.g. the leader is not available
+			id, leader := d.int32(), d.int32()
+			d.skipInt32Array() // replica_nodes
+			d.skipInt32Array() // isr_nodes
+			if leader >= 0 {
+				partitions = append(partitions, kafkaPartition{id: id, leader: leader})
+			}
+		}
+	}
+
+	if d.err != nil {
+		return fmt.Errorf("parse kafka metadata: %w", d.err)
+	}
+
+	if len(partitions) == 0 {
+		return fmt.Errorf("no available partition of topic %s", p.topic)
+	}
+
+	p.brokers, p.partitions = brokers, partitions
+	return nil
+}
+
+func (p *kafkaProducer) conn(addr string) (net.Conn, error) {
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+
+	c, err := net.DialTimeout("tcp", addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p.conns[addr] = c
+	return c, nil
+}
+
+// Close closes the connections to the brokers, the records are sent
+// synchronously, nothing to flush.
+func (p *kafkaProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reset()
+	return nil
+}
+
+// reset closes the connections and drops the metadata.
+func (p *kafkaProducer) reset() {
+	for addr, c := range p.conns {
+		_ = c.Close()
+		delete(p.conns, addr)
+	}
+	p.partitions = nil
+}
+
+func (p *kafkaProducer) roundTrip(addr string, apiKey, apiVersion int16, body []byte, expectResponse bool) ([]byte, error) {
+	c, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.doRoundTrip(c, apiKey, apiVersion, body, expectResponse)
+	if err != nil {
+		_ = c.Close()
+		delete(p.conns, addr)
+		return nil, fmt.Errorf("kafka broker %s: %w", addr, err)
+	}
+
+	return resp, nil
+}
+
+func (p *kafkaProducer) doRoundTrip(c net.Conn, apiKey, apiVersion int16, body []byte, expectResponse bool) ([]byte, error) {
+	p.correlationID++
+
+	// request header v1
+	e := &kafkaEncoder{}
+	e.putInt32(0) // size, fill later
+	e.putInt16(apiKey)
+	e.putInt16(apiVersion)
+	e.putInt32(p.correlationID)
+	e.putString(p.clientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	if err := c.SetDeadline(time.Now().Add(p.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.Write(e.buf); err != nil {
+		return nil, err
+	}
+
+	if !expectResponse {
+		return nil, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", n)
+	}
+
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+
+	// response header v0
+	if id := int32(binary.BigEndian.Uint32(resp)); id != p.correlationID {
+		return nil, fmt.Errorf("correlation id %d mismatch, expect %d", id, p.correlationID)
+	}
+
+	return resp[4:], nil
+}
+
+// encodeRecordBatch encodes one record in the RecordBatch v2.
+func encodeRecordBatch(key, value []byte, now time.Time) []byte {
+	var record []byte
+	record = append(record, 0)                // attributes
+	record = binary.AppendVarint(record, 0)   // timestamp_delta
+	record = binary.AppendVarint(record, 0)   // offset_delta
+	record = appendVarintBytes(record, key)   // key
+	record = appendVarintBytes(record, value) // value
+	record = binary.AppendVarint(record, 0)   // headers
+
+	ms := uint64(now.UnixMilli())
+
+	// the fields from attributes to the end are covered by crc.
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, 0)          // attributes
+	body = binary.BigEndian.AppendUint32(body, 0)          // last_offset_delta
+	body = binary.BigEndian.AppendUint64(body, ms)         // base_timestamp
+	body = binary.BigEndian.AppendUint64(body, ms)         // max_timestamp
+	body = binary.BigEndian.AppendUint64(body, ^uint64(0)) // producer_id: -1
+	body = binary.BigEndian.AppendUint16(body, ^uint16(0)) // producer_epoch: -1
+	body = binary.BigEndian.AppendUint32(body, ^uint32(0)) // base_sequence: -1
+	body = binary.BigEndian.AppendUint32(body, 1)          // records count
+	body = binary.AppendVarint(body, int64(len(record)))
+	body = append(body, record...)
+
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, 0) // base_offset
+	// batch_length: partition_leader_epoch + magic + crc + body
+	batch = binary.BigEndian.AppendUint32(batch, uint32(4+1+4+len(body)))
+	batch = binary.BigEndian.AppendUint32(batch, ^uint32(0)) // partition_leader_epoch: -1
+	batch = append(batch, kafkaRecordBatchMagic)
+	batch = binary.BigEndian.AppendUint32(batch, crc32.Checksum(body, crc32cTable))
+	return append(batch, body...)
+}
+
+func appendVarintBytes(b, data []byte) []byte {
+	if data == nil {
+		return binary.AppendVarint(b, -1)
+	}
+
+	b = binary.AppendVarint(b, int64(len(data)))
+	return append(b, data...)
+}
+
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) putInt16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *kafkaEncoder) putInt32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *kafkaEncoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) putBytes(b []byte) {
+	e.putInt32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder decodes the response, the first error is kept in err and
+// the zero values are returned after that.
+type kafkaDecoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || d.off+n > len(d.buf) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) string() string {
+	return d.nullableString()
+}
+
+// arrayLen returns 0 for the null array and errors.
+func (d *kafkaDecoder) arrayLen() int {
+	n := d.int32()
+	if d.err != nil || n < 0 {
+		return 0
+	}
+
+	// every element takes one byte at least.
+	if int(n) > len(d.buf)-d.off {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(n)
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	n := d.arrayLen()
+	d.next(4 * n)
+}
@@ -0,0 +1,413 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testKafkaTopic = "huatuo"
+
+// kafka error codes used by the tests.
+const (
+	kafkaErrNotLeaderForPartition int16 = 6
+	kafkaErrMessageTooLarge       int16 = 10
+)
+
+type fakeRecord struct {
+	partition int32
+	key       string
+	value     string
+}
+
+// fakeKafkaBroker is a single kafka broker which leads all the partitions of
+// the topic, the Produce requests are decoded and answered with the error
+// code of produceCode.
+type fakeKafkaBroker struct {
+	t          *testing.T
+	ln         net.Listener
+	partitions int32
+
+	mu          sync.Mutex
+	produceCode func(n int) int16 // n is the count of the Produce requests
+	metadatas   int
+	produces    int
+	conns       int
+	records     []fakeRecord
+}
+
+func newFakeKafkaBroker(t *testing.T, partitions int32, produceCode func(n int) int16) *fakeKafkaBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	b := &fakeKafkaBroker{t: t, ln: ln, partitions: partitions, produceCode: produceCode}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go b.serve()
+	return b
+}
+
+func (b *fakeKafkaBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeKafkaBroker) serve() {
+	for {
+		c, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		b.conns++
+		b.mu.Unlock()
+
+		go b.serveConn(c)
+	}
+}
+
+func (b *fakeKafkaBroker) serveConn(c net.Conn) {
+	defer c.Close()
+
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{buf: req}
+		apiKey, _, correlationID := d.int16(), d.int16(), d.int32()
+		_ = d.string() // client_id
+
+		var body []byte
+		switch apiKey {
+		case kafkaAPIMetadata:
+			body = b.metadata()
+		case kafkaAPIProduce:
+			var acks int16
+			body, acks = b.produce(d)
+			if acks == 0 {
+				continue
+			}
+		default:
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+
+		if d.err != nil {
+			b.t.Errorf("decode request of api %d: %v", apiKey, d.err)
+			return
+		}
+
+		e := &kafkaEncoder{}
+		e.putInt32(int32(4 + len(body)))
+		e.putInt32(correlationID)
+		e.buf = append(e.buf, body...)
+		if _, err := c.Write(e.buf); err != nil {
+			return
+		}
+	}
+}
+
+// metadata encodes the Metadata v1 response.
+func (b *fakeKafkaBroker) metadata() []byte {
+	b.mu.Lock()
+	b.metadatas++
+	b.mu.Unlock()
+
+	host, port, _ := net.SplitHostPort(b.addr())
+	portNum, _ := strconv.Atoi(port)
+
+	e := &kafkaEncoder{}
+	e.putInt32(1) // brokers
+	e.putInt32(0) // node_id
+	e.putString(host)
+	e.putInt32(int32(portNum))
+	e.putInt16(-1) // rack
+	e.putInt32(0)  // controller_id
+	e.putInt32(1)  // topics
+	e.putInt16(0)  // error_code
+	e.putString(testKafkaTopic)
+	e.buf = append(e.buf, 0) // is_internal
+	e.putInt32(b.partitions)
+	for id := range b.partitions {
+		e.putInt16(0) // error_code
+		e.putInt32(id)
+		e.putInt32(0) // leader
+		e.putInt32(1) // replica_nodes
+		e.putInt32(0)
+		e.putInt32(1) // isr_nodes
+		e.putInt32(0)
+	}
+	return e.buf
+}
+
+// produce decodes the Produce v3 request and encodes the response.
+func (b *fakeKafkaBroker) produce(d *kafkaDecoder) ([]byte, int16) {
+	_ = d.nullableString() // transactional_id
+	acks := d.int16()
+	_ = d.int32() // timeout
+
+	if n := d.arrayLen(); n != 1 {
+		b.t.Errorf("produce topics %d, expect 1", n)
+	}
+	if topic := d.string(); topic != testKafkaTopic {
+		b.t.Errorf("produce topic %q, expect %q", topic, testKafkaTopic)
+	}
+	if n := d.arrayLen(); n != 1 {
+		b.t.Errorf("produce partitions %d, expect 1", n)
+	}
+	partition := d.int32()
+	batch := d.next(int(d.int32()))
+
+	b.mu.Lock()
+	b.produces++
+	code := b.produceCode(b.produces)
+	if code == 0 {
+		key, value := b.decodeRecordBatch(batch)
+		b.records = append(b.records, fakeRecord{partition: partition, key: key, value: value})
+	}
+	b.mu.Unlock()
+
+	e := &kafkaEncoder{}
+	e.putInt32(1) // topics
+	e.putString(testKafkaTopic)
+	e.putInt32(1) // partitions
+	e.putInt32(partition)
+	e.putInt16(code)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, 0)          // base_offset
+	e.buf = binary.BigEndian.AppendUint64(e.buf, ^uint64(0)) // log_append_time: -1
+	e.putInt32(0)                                            // throttle_time_ms
+	return e.buf, acks
+}
+
+// decodeRecordBatch checks the RecordBatch v2 of one record and returns it.
+func (b *fakeKafkaBroker) decodeRecordBatch(batch []byte) (key, value string) {
+	d := &kafkaDecoder{buf: batch}
+	_ = d.int64() // base_offset
+	if n := int(d.int32()); n != len(batch)-12 {
+		b.t.Errorf("batch_length %d, expect %d", n, len(batch)-12)
+	}
+	_ = d.int32() // partition_leader_epoch
+	if magic := d.int8(); magic != kafkaRecordBatchMagic {
+		b.t.Errorf("magic %d, expect %d", magic, kafkaRecordBatchMagic)
+	}
+	if crc := uint32(d.int32()); d.err == nil && crc != crc32.Checksum(batch[d.off:], crc32cTable) {
+		b.t.Errorf("crc %x mismatch", crc)
+	}
+	d.next(2 + 4 + 8 + 8 + 8 + 2 + 4) // attributes ... base_sequence
+	if n := d.int32(); n != 1 {
+		b.t.Errorf("records count %d, expect 1", n)
+	}
+	if d.err != nil {
+		b.t.Errorf("decode record batch: %v", d.err)
+		return "", ""
+	}
+
+	r := bytes.NewReader(batch[d.off:])
+	varint := func() int64 {
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			b.t.Errorf("read varint: %v", err)
+		}
+		return v
+	}
+	varintBytes := func() string {
+		buf := make([]byte, varint())
+		if _, err := io.ReadFull(r, buf); err != nil {
+			b.t.Errorf("read varint bytes: %v", err)
+		}
+		return string(buf)
+	}
+
+	if length := varint(); int(length) != r.Len() {
+		b.t.Errorf("record length %d, expect %d", length, r.Len())
+	}
+	_, _ = r.ReadByte() // attributes
+	_ = varint()        // timestamp_delta
+	_ = varint()        // offset_delta
+	key, value = varintBytes(), varintBytes()
+	if headers := varint(); headers != 0 {
+		b.t.Errorf("record headers %d, expect 0", headers)
+	}
+	return key, value
+}
+
+func (b *fakeKafkaBroker) stats() (metadatas, produces, conns int, records []fakeRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.metadatas, b.produces, b.conns, append([]fakeRecord(nil), b.records...)
+}
+
+func newTestKafkaProducer(t *testing.T, b *fakeKafkaBroker, acks int16) *kafkaProducer {
+	t.Helper()
+
+	p, err := newKafkaProducer([]string{b.addr()}, testKafkaTopic, "", acks, time.Second)
+	if err != nil {
+		t.Fatalf("new kafka producer: %v", err)
+	}
+	t.Cleanup(func() {
+		p.mu.Lock()
+		p.reset()
+		p.mu.Unlock()
+	})
+	return p
+}
+
+func TestKafkaProducerWrite(t *testing.T) {
+	b := newFakeKafkaBroker(t, 3, func(int) int16 { return 0 })
+	p := newTestKafkaProducer(t, b, 1)
+
+	docs := []*document{
+		{TracerName: "softirq", ContainerID: "c1", TracerTime: "t1"},
+		{TracerName: "softirq", ContainerID: "c2", TracerTime: "t2"},
+		{TracerName: "oom", ContainerID: "c1", TracerTime: "t3"},
+		{TracerName: "softirq", ContainerID: "c1", TracerTime: "t4"},
+	}
+	for _, doc := range docs {
+		if err := p.Write(doc); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	metadatas, produces, conns, records := b.stats()
+	if metadatas != 1 || produces != len(docs) || conns != 1 {
+		t.Fatalf("metadata %d, produce %d, conns %d; expect 1, %d, 1", metadatas, produces, conns, len(docs))
+	}
+
+	partitions := make(map[string]int32)
+	for i, r := range records {
+		doc := docs[i]
+		if key := doc.TracerName + "/" + doc.ContainerID; r.key != key {
+			t.Errorf("record %d key %q, expect %q", i, r.key, key)
+		}
+		if !strings.Contains(r.value, `"tracer_time":"`+doc.TracerTime+`"`) {
+			t.Errorf("record %d value %s, expect the tracer_time %s", i, r.value, doc.TracerTime)
+		}
+
+		// the records of the same key are in the same partition.
+		if id, ok := partitions[r.key]; ok && id != r.partition {
+			t.Errorf("record %d of key %q in partition %d, expect %d", i, r.key, r.partition, id)
+		}
+		partitions[r.key] = r.partition
+	}
+}
+
+func TestKafkaProducerWriteNoAcks(t *testing.T) {
+	b := newFakeKafkaBroker(t, 1, func(int) int16 { return 0 })
+	p := newTestKafkaProducer(t, b, 0)
+
+	for range 3 {
+		if err := p.Write(&document{TracerName: "oom"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	// no response of the Produce, wait for the broker to receive them.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, produces, _, _ := b.stats(); produces == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the records are not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKafkaProducerRetry(t *testing.T) {
+	// the leader is moved at the first Produce.
+	b := newFakeKafkaBroker(t, 1, func(n int) int16 {
+		if n == 1 {
+			return kafkaErrNotLeaderForPartition
+		}
+		return 0
+	})
+	p := newTestKafkaProducer(t, b, 1)
+
+	if err := p.Write(&document{TracerName: "oom"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// the metadata is refreshed on a new connection before the retry.
+	metadatas, produces, conns, records := b.stats()
+	if metadatas != 2 || produces != 2 || conns != 2 || len(records) != 1 {
+		t.Fatalf("metadata %d, produce %d, conns %d, records %d; expect 2, 2, 2, 1",
+			metadatas, produces, conns, len(records))
+	}
+}
+
+func TestKafkaProducerError(t *testing.T) {
+	b := newFakeKafkaBroker(t, 1, func(int) int16 { return kafkaErrMessageTooLarge })
+	p := newTestKafkaProducer(t, b, 1)
+
+	err := p.Write(&document{TracerName: "oom"})
+	if err == nil || !strings.Contains(err.Error(), "kafka error code 10") {
+		t.Fatalf("write error %v, expect the kafka error code 10", err)
+	}
+
+	// retried once only.
+	if _, produces, _, records := b.stats(); produces != 2 || len(records) != 0 {
+		t.Fatalf("produce %d, records %d; expect 2, 0", produces, len(records))
+	}
+
+	// the broker is down.
+	_ = b.ln.Close()
+	p.mu.Lock()
+	p.reset()
+	p.mu.Unlock()
+
+	if err := p.Write(&document{TracerName: "oom"}); err == nil {
+		t.Fatal("write to the closed broker, expect an error")
+	}
+}
+
+func TestNewKafkaProducerError(t *testing.T) {
+	if _, err := newKafkaProducer(nil, testKafkaTopic, "", 1, time.Second); err == nil {
+		t.Fatal("no brokers, expect an error")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	_, err = newKafkaProducer([]string{addr}, testKafkaTopic, "", 1, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "kafka metadata") {
+		t.Fatalf("new producer error %v, expect the kafka metadata error", err)
+	}
+}
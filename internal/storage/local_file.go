@@ -16,7 +16,9 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -33,15 +35,54 @@ type localFileStorage struct {
 	localPath         string
 	localRotationSize int
 	localMaxRotation  int
+	format            string
+	rotatorOpts       []rotator.Option
 }
 
+const (
+	localFileSinkName = "localfile"
+
+	// LocalFormatText is a title line followed by the indented JSON document.
+	LocalFormatText = "text"
+	// LocalFormatJSONLines is one compact JSON document per line.
+	LocalFormatJSONLines = "jsonl"
+)
+
 var fileWriterMap sync.Map
 
-func newLocalFileStorage(path string, maxRotation, rotationSize int) (*localFileStorage, error) {
+func init() {
+	registerWriter(localFileSinkName, func(_ context.Context, initCtx *InitContext) (writer, error) {
+		compression, err := rotator.ParseCompression(initCtx.LocalCompression)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := []rotator.Option{rotator.WithCompression(compression)}
+		if initCtx.LocalMaxTotalSize > 0 {
+			opts = append(opts, rotator.WithDiskBudget(rotator.NewDiskBudget(initCtx.LocalPath, initCtx.LocalMaxTotalSize)))
+		}
+
+		return newLocalFileStorage(initCtx.LocalPath, initCtx.LocalMaxRotation, initCtx.LocalRotationSize,
+			initCtx.LocalFormat, opts...)
+	})
+}
+
+func newLocalFileStorage(path string, maxRotation, rotationSize int, format string, opts ...rotator.Option) (*localFileStorage, error) {
+	switch format {
+	case "":
+		format = LocalFormatText
+	case LocalFormatText, LocalFormatJSONLines:
+	default:
+		return nil, fmt.Errorf("invalid local file format %q, must be one of %s, %s",
+			format, LocalFormatText, LocalFormatJSONLines)
+	}
+
 	return &localFileStorage{
 		localPath:         path,
 		localMaxRotation:  maxRotation,
 		localRotationSize: rotationSize,
+		format:            format,
+		rotatorOpts:       opts,
 		files:             make(map[string]io.Writer),
 	}, nil
 }
@@ -52,7 +93,7 @@ func (f *localFileStorage) newFileWriter(filename string) io.Writer {
 
 	writer, ok := fileWriterMap.Load(filepath)
 	if !ok {
-		writer = rotator.NewSizeRotator(filepath, f.localMaxRotation, f.localRotationSize)
+		writer = rotator.NewSizeRotator(filepath, f.localMaxRotation, f.localRotationSize, f.rotatorOpts...)
 		fileWriterMap.Store(filepath, writer)
 	}
 
@@ -75,6 +116,22 @@ func (f *localFileStorage) fileWriter(tracerName string) io.Writer {
 	return f.newFileWriter(tracerName)
 }
 
+// Close closes the files of the tracers.
+func (f *localFileStorage) Close() error {
+	f.fileLock.Lock()
+	defer f.fileLock.Unlock()
+
+	var errs []error
+	for name, w := range f.files {
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close %s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (f *localFileStorage) write(tracerName string, content []byte) error {
 	_, err := f.fileWriter(tracerName).Write(content)
 	return err
@@ -112,6 +169,22 @@ func (f *localFileStorage) writeDocument(tracerName string, doc *document) error
 	return f.write(tracerName, buffer.Bytes())
 }
 
+// writeJSONLine writes the document as one JSON line into the tracerName file.
+func (f *localFileStorage) writeJSONLine(tracerName string, doc *document) error {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+
+	// disable escapeHTML
+	encoder.SetEscapeHTML(false)
+
+	// one document per line, the newline is appended by Encode.
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("json Marshal by %s: %w", tracerName, err)
+	}
+
+	return f.write(tracerName, buffer.Bytes())
+}
+
 // Write the data into local file.
 //
 // The datas format:
@@ -123,9 +196,16 @@ func (f *localFileStorage) writeDocument(tracerName string, doc *document) error
 //
 //	<Time> Host=<Host> Region=<Region> ContainerHost=<Container Hostname> ContainerID=<Container ID>
 //		ContainerType=<Container Type> ContainerLevel=<Container Level>
+//
+// In the jsonl format, the datas are one compact JSON document per line
+// without the title, the title fields are in the document.
 func (f *localFileStorage) Write(doc *document) error {
 	tracerName := doc.TracerName
 
+	if f.format == LocalFormatJSONLines {
+		return f.writeJSONLine(tracerName, doc)
+	}
+
 	// write title.
 	if err := f.writeTitle(tracerName, doc); err != nil {
 		return err
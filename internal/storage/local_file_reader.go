@@ -0,0 +1,275 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"huatuo-bamai/internal/rotator"
+)
+
+// the max size of a line in the record files.
+const maxRecordLineSize = 16 * 1024 * 1024
+
+// MaxQueryLimit is the max documents returned by a query.
+const MaxQueryLimit = 1000
+
+// Query filters the documents in the local files.
+type Query struct {
+	TracerName  string // all the tracers if empty
+	ContainerID string // the short or full container id
+	Since       time.Time
+	Until       time.Time
+	Limit       int // MaxQueryLimit if not in [1, MaxQueryLimit]
+}
+
+type recordFile struct {
+	tracerName string
+	path       string
+	modTime    time.Time
+}
+
+type recordMeta struct {
+	TracerName  string `json:"tracer_name"`
+	TracerTime  string `json:"tracer_time"`
+	ContainerID string `json:"container_id"`
+}
+
+type record struct {
+	time time.Time
+	seq  int // the order scanned, for the records of the same time
+	data json.RawMessage
+}
+
+// recordHeap keeps the newest records, the oldest one on the top.
+type recordHeap []record
+
+func (h recordHeap) Len() int { return len(h) }
+
+func (h recordHeap) Less(i, j int) bool {
+	if h[i].time.Equal(h[j].time) {
+		return h[i].seq > h[j].seq
+	}
+	return h[i].time.Before(h[j].time)
+}
+
+func (h recordHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *recordHeap) Push(x any) { *h = append(*h, x.(record)) }
+
+func (h *recordHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// QueryLocalFiles returns the documents saved in the local files, including
+// the rotated ones, the newest first.
+func QueryLocalFiles(q *Query) ([]json.RawMessage, error) {
+	files, err := recordFiles(storageInitCtx.LocalPath, q.TracerName)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 || limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	// keep the newest limit records only while scanning, the files may be
+	// much larger than the memory.
+	records := make(recordHeap, 0, limit)
+	seq := 0
+	for _, f := range files {
+		// the file is not written after since.
+		if !q.Since.IsZero() && f.modTime.Before(q.Since) {
+			continue
+		}
+
+		if err := readRecordFile(f.path, func(data []byte) {
+			r, ok := q.match(data)
+			if !ok {
+				return
+			}
+
+			r.seq = seq
+			seq++
+
+			heap.Push(&records, r)
+			if records.Len() > limit {
+				heap.Pop(&records)
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.path, err)
+		}
+	}
+
+	// the newest first
+	docs := make([]json.RawMessage, records.Len())
+	for i := len(docs) - 1; i >= 0; i-- {
+		docs[i] = heap.Pop(&records).(record).data
+	}
+
+	return docs, nil
+}
+
+func (q *Query) match(data []byte) (record, bool) {
+	var meta recordMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return record{}, false
+	}
+
+	if q.TracerName != "" && meta.TracerName != q.TracerName {
+		return record{}, false
+	}
+
+	if q.ContainerID != "" {
+		if meta.ContainerID == "" ||
+			!(strings.HasPrefix(q.ContainerID, meta.ContainerID) || strings.HasPrefix(meta.ContainerID, q.ContainerID)) {
+			return record{}, false
+		}
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05.000 -0700", meta.TracerTime)
+	if err != nil {
+		return record{}, false
+	}
+
+	if (!q.Since.IsZero() && t.Before(q.Since)) || (!q.Until.IsZero() && t.After(q.Until)) {
+		return record{}, false
+	}
+
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, data); err != nil {
+		return record{}, false
+	}
+
+	return record{time: t, data: buf.Bytes()}, true
+}
+
+// recordFiles returns the record files of the tracer, and the rotated ones
+// named as <tracer>-<rotator.BackupTimeFormat>[.gz|.zst].
+func recordFiles(dir, tracerName string) ([]recordFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []recordFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		name := recordTracerName(entry.Name())
+		if tracerName != "" && name != tracerName {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, recordFile{
+			tracerName: name,
+			path:       filepath.Join(dir, entry.Name()),
+			modTime:    info.ModTime(),
+		})
+	}
+
+	return files, nil
+}
+
+// recordTracerName returns the tracer name of the record file, which may
+// be rotated and compressed.
+func recordTracerName(filename string) string {
+	for _, c := range []rotator.Compression{rotator.CompressionGzip, rotator.CompressionZstd} {
+		if name, ok := strings.CutSuffix(filename, c.Suffix()); ok {
+			filename = name
+			break
+		}
+	}
+
+	if name, _, ok := rotator.ParseBackupName(filename); ok {
+		return name
+	}
+
+	return filename
+}
+
+// readRecordFile parses the record file written by localFileStorage, in the
+// text or JSON Lines format, the compressed rotated files are supported.
+func readRecordFile(path string, fn func(data []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := rotator.NewDecompressReader(path, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return readRecords(r, fn)
+}
+
+func readRecords(r io.Reader, fn func(data []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordLineSize)
+
+	var (
+		doc    bytes.Buffer
+		inBody bool
+	)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		switch {
+		case !inBody && len(line) > 1 && line[0] == '{':
+			// the JSON Lines
+			fn(line)
+		case !inBody && bytes.Equal(line, []byte("{")):
+			inBody = true
+			doc.Reset()
+			doc.Write(line)
+		case inBody:
+			doc.Write(line)
+			doc.WriteByte('\n')
+			if bytes.Equal(line, []byte("}")) {
+				inBody = false
+				fn(doc.Bytes())
+			}
+		}
+	}
+
+	return scanner.Err()
+}
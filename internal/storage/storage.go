@@ -15,8 +15,14 @@
 package storage
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"os"
+	"sort"
 	"time"
+	"unicode/utf8"
 
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/internal/pod"
@@ -47,17 +53,39 @@ type writer interface {
 	Write(doc *document) error
 }
 
+// writerFactory creates a storage sink by the InitContext. The sinks which
+// buffer the documents flush them when the ctx is cancelled, and implement
+// io.Closer to wait for that.
+type writerFactory func(ctx context.Context, initCtx *InitContext) (writer, error)
+
+type sink struct {
+	name   string
+	writer writer
+}
+
 const (
 	docTracerRunAuto = "auto"
 	docTracerRunTask = "task"
 )
 
 var (
-	esExporter        writer
-	localFileExporter writer
-	storageInitCtx    InitContext
+	writerFactories = make(map[string]writerFactory)
+	sinks           []sink
+	storageInitCtx  InitContext
+	storageCancel   context.CancelFunc
 )
 
+// defaultSinks are used when no sinks are configured.
+var defaultSinks = []string{esSinkName, localFileSinkName}
+
+// registerWriter registers a storage sink, it should be called in init().
+func registerWriter(name string, factory writerFactory) {
+	if _, ok := writerFactories[name]; ok {
+		panic(fmt.Sprintf("storage sink %s is already registered", name))
+	}
+	writerFactories[name] = factory
+}
+
 func createBaseDocument(tracerName, containerID string, tracerTime time.Time, tracerData any) *document {
 	// TODO: support for !didi.
 	doc := &document{
@@ -96,45 +124,114 @@ func createBaseDocument(tracerName, containerID string, tracerTime time.Time, tr
 }
 
 type InitContext struct {
+	// Sinks is the names of the enabled storage sinks, defaultSinks if empty.
+	Sinks []string
+
 	EsAddresses string // Elasticsearch nodes to use.
 	EsUsername  string // Username for HTTP Basic Authentication.
 	EsPassword  string // Password for HTTP Basic Authentication.
 	EsIndex     string
 
+	EsBatchSize     int           // documents per bulk request.
+	EsFlushInterval time.Duration // the max interval of the bulk requests.
+	EsQueueSize     int           // the max documents queued in memory.
+	EsMaxRetries    int           // the max retries of a failed bulk request, 0 to not retry.
+	EsSpillPath     string        // the documents are spilled into it when es is down, disabled if empty.
+	EsMaxSpillSize  int           // the max size in Megabytes of the spill files.
+
 	LocalPath         string
 	LocalRotationSize int
 	LocalMaxRotation  int
-	Region            string
-	Hostname          string
+	LocalFormat       string // text or jsonl
+	LocalCompression  string // compress the rotated files by gzip or zstd, disabled if empty.
+	LocalMaxTotalSize int    // the max size in Megabytes of all the files, unlimited if 0.
+
+	WebhookURL     string
+	WebhookHeaders map[string]string
+	WebhookTimeout time.Duration
+
+	KafkaBrokers      []string
+	KafkaTopic        string
+	KafkaClientID     string
+	KafkaRequiredAcks string // none, leader or all
+	KafkaTimeout      time.Duration
+
+	TaskHistoryPath string // the task history is kept in memory only if empty.
+	TaskMaxHistory  int    // the max tasks in the history.
+
+	Region   string
+	Hostname string
 }
 
-// InitDefaultClients initializes the default clients, that includes local-file, elasticsearch.
-func InitDefaultClients(initCtx *InitContext) (err error) {
-	// the es client
-	if initCtx.EsAddresses == "" || initCtx.EsUsername == "" || initCtx.EsPassword == "" {
-		esExporter = &null{}
-		log.Warnf("elasticsearch storage config invalid, use null device: %+v", initCtx)
-	} else {
-		esExporter, err = newESClient(initCtx.EsAddresses, initCtx.EsUsername, initCtx.EsPassword, initCtx.EsIndex)
+// InitDefaultClients initializes the configured sinks, that includes local-file, elasticsearch by default.
+func InitDefaultClients(initCtx *InitContext) error {
+	names := initCtx.Sinks
+	if len(names) == 0 {
+		names = defaultSinks
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var enabled []sink
+	for _, name := range names {
+		factory, ok := writerFactories[name]
+		if !ok {
+			cancel()
+			return fmt.Errorf("unknown storage sink %q, supported: %v", name, supportedSinks())
+		}
+
+		w, err := factory(ctx, initCtx)
 		if err != nil {
-			return err
+			cancel()
+			return fmt.Errorf("storage sink %s: %w", name, err)
 		}
+		enabled = append(enabled, sink{name: name, writer: w})
 	}
 
-	// the local-file client
-	localFileExporter, err = newLocalFileStorage(initCtx.LocalPath, initCtx.LocalMaxRotation, initCtx.LocalRotationSize)
-	if err != nil {
+	if err := initTaskHistory(initCtx.TaskHistoryPath, initCtx.TaskMaxHistory); err != nil {
+		cancel()
 		return err
 	}
 
+	sinks = enabled
+	storageCancel = cancel
 	storageInitCtx = *initCtx
 	storageInitCtx.Hostname, _ = os.Hostname()
 
-	log.Info("InitDefaultClients includes engines: elasticsearch, local-file")
+	log.Infof("InitDefaultClients includes engines: %v", names)
 	return nil
 }
 
-// Save data to the default clients.
+// Close cancels the storage context, and waits for the sinks to flush the
+// buffered documents and close, it should be called before the agent exits.
+func Close() {
+	if storageCancel == nil {
+		return
+	}
+	storageCancel()
+
+	for _, s := range sinks {
+		c, ok := s.writer.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		if err := c.Close(); err != nil {
+			log.Infof("failed to close the storage sink %s: %v", s.name, err)
+		}
+	}
+}
+
+func supportedSinks() []string {
+	names := make([]string, 0, len(writerFactories))
+	for name := range writerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save data to the configured sinks.
 func Save(tracerName, containerID string, tracerTime time.Time, tracerData any) {
 	document := createBaseDocument(tracerName, containerID, tracerTime, tracerData)
 	if document == nil {
@@ -143,24 +240,38 @@ func Save(tracerName, containerID string, tracerTime time.Time, tracerData any)
 
 	document.TracerRunType = docTracerRunAuto
 
-	// save into es.
-	if err := esExporter.Write(document); err != nil {
-		log.Infof("failed to save %#v into es: %v", document, err)
-	}
-
-	// save into local-file.
-	if err := localFileExporter.Write(document); err != nil {
-		log.Infof("failed to save %#v into local-file: %v", document, err)
+	for _, s := range sinks {
+		if err := s.writer.Write(document); err != nil {
+			log.Infof("failed to save %#v into %s: %v", document, s.name, err)
+		}
 	}
 }
 
 type TracerBasicData struct {
 	Output string `json:"output"`
+	// Format is the format of the output, e.g. text, pprof.
+	Format string `json:"format,omitempty"`
+	// Encoding is "base64" if the output is binary, e.g. pprof.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// EncodeOutput encodes the binary output in base64, and returns the encoding,
+// the text output is returned as it is.
+func EncodeOutput(output []byte) (data, encoding string) {
+	if utf8.Valid(output) {
+		return string(output), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(output), "base64"
 }
 
-// SaveTaskOutput saves the tracer output data
-func SaveTaskOutput(tracerName, tracerID, containerID string, tracerTime time.Time, tracerData string) {
-	document := createBaseDocument(tracerName, containerID, tracerTime, &TracerBasicData{Output: tracerData})
+// SaveTaskOutput saves the tracer output data with its format, the local-file
+// is skipped.
+func SaveTaskOutput(tracerName, tracerID, containerID string, tracerTime time.Time, format string, tracerData []byte) {
+	basicData := &TracerBasicData{Format: format}
+	basicData.Output, basicData.Encoding = EncodeOutput(tracerData)
+
+	document := createBaseDocument(tracerName, containerID, tracerTime, basicData)
 	if document == nil {
 		return
 	}
@@ -168,8 +279,12 @@ func SaveTaskOutput(tracerName, tracerID, containerID string, tracerTime time.Ti
 	document.TracerRunType = docTracerRunTask
 	document.TracerID = tracerID
 
-	// save into es.
-	if err := esExporter.Write(document); err != nil {
-		log.Infof("failed to save %#v into es: %v", document, err)
+	for _, s := range sinks {
+		if s.name == localFileSinkName {
+			continue
+		}
+		if err := s.writer.Write(document); err != nil {
+			log.Infof("failed to save %#v into %s: %v", document, s.name, err)
+		}
 	}
 }
@@ -0,0 +1,308 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"huatuo-bamai/internal/log"
+)
+
+const (
+	taskHistoryFile       = "history.jsonl"
+	taskOutputSuffix      = ".out"
+	defaultTaskMaxHistory = 1000
+)
+
+// ErrTaskOutputNotFound is returned when the output of the task is not persisted.
+var ErrTaskOutputNotFound = errors.New("task output not found")
+
+// TaskRecord is the metadata of a task in the task history.
+type TaskRecord struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Args         []string   `json:"args,omitempty"`
+	Status       string     `json:"status"`
+	Format       string     `json:"format,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Stderr       string     `json:"stderr,omitempty"`
+	OutputSize   int        `json:"output_size"`
+	CreatedTime  time.Time  `json:"created_time"`
+	FinishedTime *time.Time `json:"finished_time,omitempty"`
+}
+
+// TaskQuery filters the task history.
+type TaskQuery struct {
+	Name   string // all the tasks if empty
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// taskHistory keeps the task records in memory, and appends them into the
+// history file in the JSON Lines format, the last one of the same id wins.
+// The file is rewritten when it has too many stale lines.
+type taskHistory struct {
+	mu         sync.Mutex
+	dir        string // not persisted if empty
+	maxRecords int
+	records    map[string]*TaskRecord
+	order      []string // the ids, the oldest first
+	file       *os.File
+	lines      int
+}
+
+var tasks = newTaskHistory("", defaultTaskMaxHistory)
+
+func newTaskHistory(dir string, maxRecords int) *taskHistory {
+	if maxRecords <= 0 {
+		maxRecords = defaultTaskMaxHistory
+	}
+
+	return &taskHistory{
+		dir:        dir,
+		maxRecords: maxRecords,
+		records:    make(map[string]*TaskRecord),
+	}
+}
+
+// initTaskHistory loads the task history from the dir, the history is kept
+// in memory only if the dir is empty.
+func initTaskHistory(dir string, maxRecords int) error {
+	h := newTaskHistory(dir, maxRecords)
+	if dir != "" {
+		if err := h.load(); err != nil {
+			return fmt.Errorf("load task history %s: %w", dir, err)
+		}
+	}
+
+	tasks = h
+	return nil
+}
+
+func (h *taskHistory) load() error {
+	if err := os.MkdirAll(h.dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, taskHistoryFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if f != nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxRecordLineSize)
+		for scanner.Scan() {
+			var r TaskRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.ID == "" {
+				continue
+			}
+			h.lines++
+			h.update(&r)
+		}
+		f.Close()
+
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	h.evict()
+	return h.compact()
+}
+
+// update replaces the record in memory.
+func (h *taskHistory) update(r *TaskRecord) {
+	if _, ok := h.records[r.ID]; !ok {
+		h.order = append(h.order, r.ID)
+	}
+	h.records[r.ID] = r
+}
+
+// evict removes the oldest records and their outputs.
+func (h *taskHistory) evict() {
+	for len(h.order) > h.maxRecords {
+		id := h.order[0]
+		h.order = h.order[1:]
+		delete(h.records, id)
+
+		if h.dir != "" {
+			_ = os.Remove(h.outputPath(id))
+		}
+	}
+}
+
+// compact rewrites the history file with the records in memory.
+func (h *taskHistory) compact() error {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+
+	path := filepath.Join(h.dir, taskHistoryFile)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, id := range h.order {
+		if err := encoder.Encode(h.records[id]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	h.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	h.lines = len(h.order)
+	return err
+}
+
+func (h *taskHistory) outputPath(id string) string {
+	return filepath.Join(h.dir, id+taskOutputSuffix)
+}
+
+func (h *taskHistory) save(r *TaskRecord, output []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.update(r)
+	h.evict()
+
+	if h.dir == "" {
+		return nil
+	}
+
+	if output != nil {
+		if err := os.WriteFile(h.outputPath(r.ID), output, 0o644); err != nil {
+			return err
+		}
+	}
+
+	if h.lines > 2*h.maxRecords || h.file == nil {
+		return h.compact()
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	h.lines++
+	return nil
+}
+
+// SaveTaskRecord saves the task record into the task history, and the output
+// if not nil, the record of the same id is replaced.
+func SaveTaskRecord(r *TaskRecord, output []byte) {
+	record := *r
+	if err := tasks.save(&record, output); err != nil {
+		log.Infof("failed to save the task %s into history: %v", r.ID, err)
+	}
+}
+
+// TaskRecordByID returns the task record in the task history.
+func TaskRecordByID(id string) (*TaskRecord, bool) {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	r, ok := tasks.records[id]
+	if !ok {
+		return nil, false
+	}
+
+	record := *r
+	return &record, true
+}
+
+// TaskRecordOutput returns the output of the task saved by SaveTaskRecord.
+func TaskRecordOutput(id string) ([]byte, error) {
+	tasks.mu.Lock()
+	dir := tasks.dir
+	_, ok := tasks.records[id]
+	tasks.mu.Unlock()
+
+	if !ok || dir == "" {
+		return nil, ErrTaskOutputNotFound
+	}
+
+	data, err := os.ReadFile(tasks.outputPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrTaskOutputNotFound
+	}
+
+	return data, err
+}
+
+// QueryTaskRecords returns the task records in the task history, the newest
+// first.
+func QueryTaskRecords(q *TaskQuery) []*TaskRecord {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+
+	var records []*TaskRecord
+	for _, r := range tasks.records {
+		if q.Name != "" && r.Name != q.Name {
+			continue
+		}
+		if q.Status != "" && r.Status != q.Status {
+			continue
+		}
+		if (!q.Since.IsZero() && r.CreatedTime.Before(q.Since)) ||
+			(!q.Until.IsZero() && r.CreatedTime.After(q.Until)) {
+			continue
+		}
+
+		record := *r
+		records = append(records, &record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedTime.After(records[j].CreatedTime)
+	})
+
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+
+	return records
+}
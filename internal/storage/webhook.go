@@ -0,0 +1,97 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookSinkName       = "webhook"
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+func init() {
+	registerWriter(webhookSinkName, func(_ context.Context, initCtx *InitContext) (writer, error) {
+		return newWebhookClient(initCtx.WebhookURL, initCtx.WebhookHeaders, initCtx.WebhookTimeout)
+	})
+}
+
+// webhookClient posts the documents to a HTTP endpoint in JSON.
+type webhookClient struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func newWebhookClient(url string, headers map[string]string, timeout time.Duration) (*webhookClient, error) {
+	if url == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &webhookClient{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 10,
+				DialContext:         (&net.Dialer{Timeout: timeout}).DialContext,
+			},
+		},
+		url:     url,
+		headers: headers,
+	}, nil
+}
+
+func (w *webhookClient) Write(doc *document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("json Marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post document failed with status: %s, response: %s", resp.Status, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// the directory of the separate debug files
+var debugFileDir = "/usr/lib/debug"
+
+// loadDebugSymbols loads the symbols of the separate debug file of the
+// stripped file, looked up by the build-id first and then .gnu_debuglink, in
+// the mount namespace of the process and then the host.
+//
+// NOTICE: the crc of .gnu_debuglink is not checked, reading the whole debug
+// file is too expensive.
+func loadDebugSymbols(f *elf.File, root, path string) ([]symbol, error) {
+	var candidates []string
+
+	if buildID := elfBuildID(f); len(buildID) > 2 {
+		candidates = append(candidates,
+			filepath.Join(debugFileDir, ".build-id", buildID[:2], buildID[2:]+".debug"))
+	}
+
+	if link := elfDebugLink(f); link != "" {
+		dir := filepath.Dir(path)
+		candidates = append(candidates,
+			filepath.Join(dir, link),
+			filepath.Join(dir, ".debug", link),
+			filepath.Join(debugFileDir, dir, link))
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New("no build-id or .gnu_debuglink")
+	}
+
+	for _, r := range []string{root, "/"} {
+		for _, candidate := range candidates {
+			debugPath := filepath.Join(r, candidate)
+			if debugPath == filepath.Join(root, path) {
+				continue
+			}
+
+			if _, err := os.Stat(debugPath); err != nil {
+				continue
+			}
+
+			df, err := elf.Open(debugPath)
+			if err != nil {
+				continue
+			}
+
+			symbols := getElfSymbols(df)
+			df.Close()
+			return symbols, nil
+		}
+	}
+
+	return nil, fmt.Errorf("debug file not found in %v", candidates)
+}
+
+// elfBuildID returns the hex of the build-id in .note.gnu.build-id.
+func elfBuildID(f *elf.File) string {
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return ""
+	}
+
+	data, err := sec.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+
+	// namesz, descsz, type, name "GNU\0" aligned to 4, desc
+	namesz := f.ByteOrder.Uint32(data[0:4])
+	descsz := f.ByteOrder.Uint32(data[4:8])
+	typ := f.ByteOrder.Uint32(data[8:12])
+	descOff := 12 + (uint64(namesz)+3)&^3
+	if typ != 3 || descOff+uint64(descsz) > uint64(len(data)) { // NT_GNU_BUILD_ID
+		return ""
+	}
+
+	return hex.EncodeToString(data[descOff : descOff+uint64(descsz)])
+}
+
+// elfDebugLink returns the file name in .gnu_debuglink, which is followed by
+// the crc32 of the debug file.
+func elfDebugLink(f *elf.File) string {
+	sec := f.Section(".gnu_debuglink")
+	if sec == nil {
+		return ""
+	}
+
+	data, err := sec.Data()
+	if err != nil {
+		return ""
+	}
+
+	name, _, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return ""
+	}
+
+	return string(name)
+}
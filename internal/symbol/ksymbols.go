@@ -17,22 +17,29 @@ package symbol
 import (
 	"bufio"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
-	ksymbolCounter = 0
-	ksymbolPath    = "/proc/kallsyms"
-	ksymbolCache   = []Symbol{}
-	ksymbolIsInit  = false
-	ksymbolMax     = 300000
-	ksymbolLock    = sync.Mutex{}
-	moduleKernel   = "[kernel]"
-	defaultSymbol  = Symbol{0, "", "[unknown]"}
+	ksymbolPath   = "/proc/kallsyms"
+	ksymbolCache  = []Symbol{}
+	ksymbolIsInit = false
+	ksymbolLock   = sync.Mutex{}
+	moduleKernel  = "[kernel]"
+	defaultSymbol = Symbol{Name: "", Module: "[unknown]"}
+
+	// the symbols are reloaded when the modules are loaded or unloaded,
+	// which is checked at most once in the interval.
+	ksymbolModulesPath   = "/proc/modules"
+	ksymbolModulesHash   uint64
+	ksymbolModulesCheck  time.Time
+	ksymbolCheckInterval = 10 * time.Second
 )
 
 const (
@@ -50,38 +57,25 @@ type Symbol struct {
 	Addr   uint64
 	Name   string
 	Module string
+	// Size is up to the next symbol, 0 for the last symbol of the module.
+	Size uint64
 }
 
 func (s Symbol) String() string {
 	return fmt.Sprintf("{Addr: %x Name: %s Module: %s}", s.Addr, s.Name, s.Module)
 }
 
-type symbolSort []Symbol
-
-func (s symbolSort) Len() int {
-	return ksymbolCounter
-}
-
-func (s symbolSort) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s symbolSort) Less(i, j int) bool {
-	return s[i].Addr < s[j].Addr
-}
-
 func loadKAllSymbols() error {
-	ksymbolCache = make([]Symbol, ksymbolMax)
-	// default
-	ksymbolCache[0] = defaultSymbol
-	ksymbolCounter = 1
-
 	f, err := os.Open(ksymbolPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	// default
+	symbols := make([]Symbol, 1, len(ksymbolCache)+1)
+	symbols[0] = defaultSymbol
+
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -106,51 +100,145 @@ func loadKAllSymbols() error {
 		}
 
 		if len(words) == 3 {
-			ksymbolCache[ksymbolCounter] = Symbol{Addr: addr, Name: words[2], Module: moduleKernel}
+			symbols = append(symbols, Symbol{Addr: addr, Name: words[2], Module: moduleKernel})
 		} else {
-			ksymbolCache[ksymbolCounter] = Symbol{Addr: addr, Name: words[2], Module: words[3]}
+			symbols = append(symbols, Symbol{Addr: addr, Name: words[2], Module: words[3]})
 		}
-		ksymbolCounter++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
 	// sort
-	sort.Sort(symbolSort(ksymbolCache))
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Addr < symbols[j].Addr
+	})
 
+	for i := 1; i < len(symbols)-1; i++ {
+		if symbols[i].Module == symbols[i+1].Module {
+			symbols[i].Size = symbols[i+1].Addr - symbols[i].Addr
+		}
+	}
+
+	ksymbolCache = symbols
 	ksymbolIsInit = true
 
 	return nil
 }
 
+// modulesHash hashes the names and the addresses of the loaded modules, the
+// use counts are ignored.
+func modulesHash() uint64 {
+	data, err := os.ReadFile(ksymbolModulesPath)
+	if err != nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	for _, line := range strings.Split(string(data), "\n") {
+		// bonding 196608 0 - Live 0xffffffffc0a3e000
+		words := strings.Fields(line)
+		if len(words) < 6 {
+			continue
+		}
+
+		h.Write([]byte(words[0]))
+		h.Write([]byte(words[5]))
+	}
+
+	return h.Sum64()
+}
+
+// ksymbolLoad loads the symbols if not initialized, and reloads them when the
+// modules are changed. The caller holds ksymbolLock.
+func ksymbolLoad() {
+	if ksymbolIsInit && time.Since(ksymbolModulesCheck) < ksymbolCheckInterval {
+		return
+	}
+
+	ksymbolModulesCheck = time.Now()
+	hash := modulesHash()
+	if ksymbolIsInit && hash == ksymbolModulesHash {
+		return
+	}
+
+	if err := loadKAllSymbols(); err != nil {
+		return
+	}
+	ksymbolModulesHash = hash
+}
+
 func ksymbolSearch(key uint64) Symbol {
 	ksymbolLock.Lock()
 	defer ksymbolLock.Unlock()
 
-	if !ksymbolIsInit {
-		_ = loadKAllSymbols()
-	}
+	ksymbolLoad()
 
-	i, j := 0, ksymbolCounter
-	for i < j {
-		h := (i + j) >> 1 // avoid overflow when computing h
-		if ksymbolCache[h].Addr == key {
-			i = h
-			break
-		} else if ksymbolCache[h].Addr < key {
-			i = h + 1
-		} else {
-			j = h
-		}
+	if len(ksymbolCache) == 0 {
+		return defaultSymbol
 	}
 
-	if ksymbolCache[i].Addr == key {
-		return ksymbolCache[i]
-	}
+	i := sort.Search(len(ksymbolCache), func(i int) bool {
+		return ksymbolCache[i].Addr > key
+	})
 	if i == 0 {
 		return ksymbolCache[0]
 	}
 	return ksymbolCache[i-1]
 }
 
+// KernelSymbolExists checks whether the kernel text symbol exists, which is
+// useful to select the kprobe symbol on different kernel versions.
+func KernelSymbolExists(name string) bool {
+	ksymbolLock.Lock()
+	defer ksymbolLock.Unlock()
+
+	ksymbolLoad()
+
+	for i := range ksymbolCache {
+		if ksymbolCache[i].Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveKernelAddr resolves the address into "name+0xoff/0xsize [module]",
+// the format of the kernel stacks, e.g. /proc/<pid>/stack. The module is
+// omitted for the core kernel symbols, and the size for the last symbol of
+// the module.
+func ResolveKernelAddr(addr uint64) string {
+	sym := ksymbolSearch(addr)
+	if sym.Name == "" {
+		return fmt.Sprintf("0x%x", addr)
+	}
+
+	s := fmt.Sprintf("%s+0x%x", sym.Name, addr-sym.Addr)
+	if sym.Size != 0 {
+		s += fmt.Sprintf("/0x%x", sym.Size)
+	}
+	if sym.Module != moduleKernel {
+		s += " " + sym.Module
+	}
+	return s
+}
+
+// DumpKernelBackTraceOffset converts the kernel stack addresses into the
+// symbols with the offsets and modules, see ResolveKernelAddr.
+func DumpKernelBackTraceOffset(stack []uint64, maxDepth int) Stack {
+	var s Stack
+
+	for i, addr := range stack {
+		if addr == 0 || i >= maxDepth {
+			break
+		}
+		s.BackTrace = append(s.BackTrace, ResolveKernelAddr(addr))
+	}
+	return s
+}
+
 // DumpKernelBackTrace converts the kernel stack address to the kernel symbol
 // and returns the Stack structure
 func DumpKernelBackTrace(stack []uint64, maxDepth int) Stack {
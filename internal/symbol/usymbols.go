@@ -23,15 +23,22 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"huatuo-bamai/internal/log"
+
+	"github.com/hashicorp/golang-lru/simplelru"
 )
 
 const (
-	// elftype elf type
-	elftype int = 0xc
-	// libtype lib type
-	libtype int = 0xd
+	// the processes and files cached, the least recently used are evicted.
+	usymMaxProcs = 1024
+	usymMaxFiles = 256
+	// the maps of the process are reloaded at most once in the interval,
+	// when an address is not found in them, e.g. a library dlopen-ed later.
+	usymMapsReloadInterval = 10 * time.Second
+	// the perf map is checked for changes at most once in the interval.
+	usymJitCheckInterval = time.Second
 )
 
 type symbol struct {
@@ -40,34 +47,67 @@ type symbol struct {
 	size  uint64
 }
 
-type section struct {
-	name        string
-	start       uint64
-	end         uint64
-	sectiontype int
+// mapping is a file mapped in the process, /proc/<pid>/maps.
+type mapping struct {
+	start  uint64
+	end    uint64
+	offset uint64
+	dev    string
+	inode  uint64
+	path   string // the path in the mount namespace of the process
+}
+
+// procCache is the mappings and the JIT symbols of a process.
+type procCache struct {
+	root     string // /proc/<pid>/root, the root of the mount namespace
+	mappings []mapping
+	loadTime time.Time
+	jit      *jitSymbols
 }
 
-// elfcache elf slice
-type elfcache struct {
-	sections  []section
-	symcaches []symbol
+// elfSymbols is the function symbols of an elf file, and the loadable
+// segments to convert the file offset into the virtual address.
+type elfSymbols struct {
+	symbols []symbol
+	loads   []elf.ProgHeader
+}
+
+// jitSymbols is the symbols of /tmp/perf-<pid>.map written by the JIT
+// runtimes, e.g. java with perf-map-agent, node --perf-basic-prof.
+type jitSymbols struct {
+	path      string
+	checkTime time.Time
+	modTime   time.Time
+	size      int64
+	symbols   []symbol
 }
 
 // Usym User mode stack information
 type Usym struct {
-	elfcaches map[uint32]elfcache
-	libcaches map[string][]symbol
+	procs *simplelru.LRU // pid -> *procCache
+	files *simplelru.LRU // dev:inode -> *elfSymbols
 }
 
 // NewUsym creates a new Usym object
 func NewUsym() *Usym {
-	return &Usym{
-		elfcaches: make(map[uint32]elfcache),
-		libcaches: make(map[string][]symbol),
+	procs, _ := simplelru.NewLRU(usymMaxProcs, nil)
+	files, _ := simplelru.NewLRU(usymMaxFiles, nil)
+
+	return &Usym{procs: procs, files: files}
+}
+
+var backedArr = []string{"anon_inode:[perf_event]", "[stack]", "[vvar]", "[vdso]", "[vsyscall]", "[heap]", "//anon", "/dev/zero", "/anon_hugepage", "/SYSV"}
+
+func isInBacked(str string) bool {
+	for _, item := range backedArr {
+		if strings.HasPrefix(str, item) {
+			return true
+		}
 	}
+	return false
 }
 
-func (m *Usym) getElfSymbols(f *elf.File) []symbol {
+func getElfSymbols(f *elf.File) []symbol {
 	tabSym := []symbol{}
 	dynsymbols, err := f.DynamicSymbols()
 	if err != nil {
@@ -93,191 +133,280 @@ func (m *Usym) getElfSymbols(f *elf.File) []symbol {
 	return tabSym
 }
 
-var backedArr = []string{"anon_inode:[perf_event]", "[stack]", "[vvar]", "[vdso]", "[vsyscall]", "[heap]", "//anon", "/dev/zero", "/anon_hugepage", "/SYSV"}
+func loadProcCache(pid uint32) (*procCache, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-func (m *Usym) isInBacked(str string) bool {
-	for _, item := range backedArr {
-		if item == str {
-			return true
+	proc := &procCache{
+		root:     fmt.Sprintf("/proc/%d/root", pid),
+		loadTime: time.Now(),
+	}
+
+	// 7f0c1c000000-7f0c1c021000 r-xp 00001000 fd:01 1234 /usr/lib/libc.so.6
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		field := strings.Fields(scanner.Text())
+		if len(field) < 6 || !strings.HasPrefix(field[5], "/") {
+			continue
+		}
+
+		// only the executable mappings have the frames
+		if !strings.Contains(field[1], "x") {
+			continue
 		}
+
+		start, end, ok := strings.Cut(field[0], "-")
+		if !ok {
+			continue
+		}
+
+		var mp mapping
+		mp.start, _ = strconv.ParseUint(start, 16, 64)
+		mp.end, _ = strconv.ParseUint(end, 16, 64)
+		mp.offset, _ = strconv.ParseUint(field[2], 16, 64)
+		mp.dev = field[3]
+		mp.inode, _ = strconv.ParseUint(field[4], 10, 64)
+		mp.path = strings.Join(field[5:], " ")
+		if mp.inode == 0 || isInBacked(mp.path) {
+			continue
+		}
+
+		proc.mappings = append(proc.mappings, mp)
 	}
-	return false
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(proc.mappings, func(i, j int) bool { return proc.mappings[i].start < proc.mappings[j].start })
+	log.Debugf("Usym pid %d mappings: %v", pid, proc.mappings)
+
+	proc.jit = &jitSymbols{path: filepath.Join(proc.root, "tmp", fmt.Sprintf("perf-%d.map", nsPid(pid)))}
+	return proc, nil
 }
 
-func (m *Usym) getExePath(pid uint32) (string, error) {
-	progpath := fmt.Sprintf("/proc/%d/exe", pid)
-	binpath, err := os.Readlink(progpath)
+// nsPid returns the pid in the pid namespace of the process, which names the
+// perf map of the JIT runtimes in the container.
+func nsPid(pid uint32) uint32 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
 	if err != nil {
-		return "", err
+		return pid
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, "NSpid:")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			break
+		}
+
+		nspid, err := strconv.ParseUint(fields[len(fields)-1], 10, 32)
+		if err != nil {
+			break
+		}
+		return uint32(nspid)
 	}
-	res := filepath.Join(fmt.Sprintf("/proc/%d/root", pid), binpath)
-	log.Debugf("Usym path: %v", res)
-	return res, nil
+
+	return pid
 }
 
-func (m *Usym) loadElfCaches(addr uint64, pid uint32) error {
-	if _, ok := m.elfcaches[pid]; ok {
-		return nil
+func (m *Usym) procCache(pid uint32) (*procCache, error) {
+	if v, ok := m.procs.Get(pid); ok {
+		return v.(*procCache), nil
 	}
-	// load sections
-	sectionArray := []section{}
 
-	path, err := m.getExePath(pid)
+	proc, err := loadProcCache(pid)
 	if err != nil {
-		return err
-	}
-	if path == "" {
-		return fmt.Errorf("exepath is nil")
+		return nil, err
 	}
 
-	f, err := elf.Open(path)
-	if err != nil {
-		return err
+	m.procs.Add(pid, proc)
+	return proc, nil
+}
+
+func (p *procCache) searchMapping(addr uint64) *mapping {
+	index := sort.Search(len(p.mappings), func(i int) bool {
+		return p.mappings[i].start > addr
+	}) - 1
+
+	if index >= 0 && addr < p.mappings[index].end {
+		return &p.mappings[index]
 	}
-	defer f.Close()
-	sections := f.Sections
-	for _, s := range sections {
-		sectionArray = append(sectionArray, section{name: s.Name, start: s.Addr, end: s.Addr + s.Size, sectiontype: elftype})
+	return nil
+}
+
+// elfSymbols loads the symbols of the mapped file, and the symbols of the
+// separate debug file if the file is stripped.
+func (m *Usym) elfSymbols(root string, mp *mapping) (*elfSymbols, error) {
+	key := fmt.Sprintf("%s:%d", mp.dev, mp.inode)
+	if v, ok := m.files.Get(key); ok {
+		return v.(*elfSymbols), nil
 	}
 
-	// load maps
-	mapPath := fmt.Sprintf("/proc/%d/maps", pid)
-	file, err := os.Open(mapPath)
+	f, err := elf.Open(filepath.Join(root, strings.TrimSuffix(mp.path, " (deleted)")))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
+	defer f.Close()
 
-	scanner := bufio.NewScanner(file)
-	if err = scanner.Err(); err != nil {
-		return err
+	syms := &elfSymbols{symbols: getElfSymbols(f)}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			syms.loads = append(syms.loads, prog.ProgHeader)
+		}
 	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		field := strings.Fields(line)
-		if len(field) < 6 {
-			continue
+	if f.Section(".symtab") == nil {
+		if debugSyms, err := loadDebugSymbols(f, root, mp.path); err != nil {
+			log.Debugf("Usym %s debug symbols: %v", mp.path, err)
+		} else {
+			syms.symbols = append(syms.symbols, debugSyms...)
 		}
-		addr := strings.Split(field[0], "-")
-		start := addr[0]
-		end := addr[1]
-		path := field[5]
-
-		startNum, _ := strconv.ParseUint(start, 16, 64)
-		endNum, _ := strconv.ParseUint(end, 16, 64)
-		if !m.isInBacked(path) {
-			sectionArray = append(sectionArray, section{name: path, start: startNum, end: endNum, sectiontype: libtype})
+	}
+
+	sort.Slice(syms.symbols, func(i, j int) bool { return syms.symbols[i].start < syms.symbols[j].start })
+	m.files.Add(key, syms)
+	return syms, nil
+}
+
+// vaddr converts the file offset into the virtual address of the symbols.
+func (e *elfSymbols) vaddr(offset uint64) uint64 {
+	for i := range e.loads {
+		load := &e.loads[i]
+		if offset >= load.Off && offset < load.Off+load.Filesz {
+			return offset - load.Off + load.Vaddr
 		}
 	}
-	sort.Slice(sectionArray, func(i, j int) bool { return sectionArray[i].start < sectionArray[j].start })
-	log.Debugf("Usym elf + maps section: %v", sectionArray)
+	return offset
+}
 
-	// load elfsymbols
-	tabsymbol := m.getElfSymbols(f)
-	sort.Slice(tabsymbol, func(i, j int) bool { return tabsymbol[i].start < tabsymbol[j].start })
+// lookup reloads the perf map if it is changed, and searches the address.
+func (j *jitSymbols) lookup(addr uint64) string {
+	if time.Since(j.checkTime) > usymJitCheckInterval {
+		j.checkTime = time.Now()
+		j.reload()
+	}
 
-	var elf elfcache
-	elf.sections = sectionArray
-	elf.symcaches = tabsymbol
-	m.elfcaches[pid] = elf
-	return nil
+	if len(j.symbols) == 0 {
+		return ""
+	}
+	return searchSym(addr, j.symbols)
 }
 
-func (m *Usym) loadLibCache(libPath string) error {
-	if _, ok := m.libcaches[libPath]; ok {
-		return nil
+func (j *jitSymbols) reload() {
+	info, err := os.Stat(j.path)
+	if err != nil {
+		j.symbols = nil
+		return
 	}
-	f, err := elf.Open(libPath)
+
+	if info.ModTime().Equal(j.modTime) && info.Size() == j.size {
+		return
+	}
+
+	symbols, err := loadJitSymbols(j.path)
 	if err != nil {
-		return err
+		log.Debugf("Usym load %s: %v", j.path, err)
+		return
 	}
-	defer f.Close()
-	mtabsymbols := m.getElfSymbols(f)
-	sort.Slice(mtabsymbols, func(i, j int) bool { return mtabsymbols[i].start < mtabsymbols[j].start })
-	m.libcaches[libPath] = mtabsymbols
-	return nil
+
+	j.symbols, j.modTime, j.size = symbols, info.ModTime(), info.Size()
 }
 
-func (m *Usym) searchSection(pid uint32, addr uint64) *section {
-	if _, ok := m.elfcaches[pid]; !ok {
-		return &section{}
-	}
-	progsection := m.elfcaches[pid].sections
-	index := sort.Search(len(progsection), func(i int) bool {
-		return progsection[i].start > addr
-	})
-	if index == len(progsection) {
-		return &section{}
+// loadJitSymbols loads the perf map, one "START SIZE symbol" in hex per line.
+func loadJitSymbols(path string) ([]symbol, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
-	index--
-	log.Debugf("Usym searchSection addr %d index %v len %v", addr, index, len(progsection))
-	if index < len(progsection) && index >= 0 {
-		log.Debugf("Usym searchSection curr %v next %v", progsection[index], progsection[index+1])
-		start := progsection[index].start
-		end := progsection[index].end
-		if progsection[index].name != "" && addr <= end && addr >= start {
-			return &progsection[index]
+	defer file.Close()
+
+	symbols := []symbol{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		field := strings.SplitN(scanner.Text(), " ", 3)
+		if len(field) != 3 {
+			continue
+		}
+
+		start, err := strconv.ParseUint(strings.TrimPrefix(field[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		size, err := strconv.ParseUint(strings.TrimPrefix(field[1], "0x"), 16, 64)
+		if err != nil {
+			continue
 		}
-		return &section{}
+
+		symbols = append(symbols, symbol{name: field[2], start: start, size: size})
 	}
-	return &section{}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].start < symbols[j].start })
+	return symbols, nil
 }
 
-func (m *Usym) searchSym(addr uint64, symbols []symbol) string {
+func searchSym(addr uint64, symbols []symbol) string {
 	index := sort.Search(len(symbols), func(i int) bool {
 		return symbols[i].start > addr
-	})
-	if index == len(symbols) {
-		return ""
-	}
-	index--
-	log.Debugf("Usym searchSym addr %d index %v len %v", addr, index, len(symbols))
-	if index < len(symbols) && index >= 0 {
-		log.Debugf("Usym searchSym curr %v next %v", symbols[index], symbols[index+1])
-		start := symbols[index].start
-		size := symbols[index].size
-		if symbols[index].name != "" && addr >= start && addr < start+size {
-			return symbols[index].name
+	}) - 1
+
+	// the symbols may overlap, e.g. the aliases, and the sizes may be 0
+	for ; index >= 0; index-- {
+		sym := &symbols[index]
+		if sym.name != "" && addr < sym.start+sym.size {
+			return sym.name
+		}
+
+		if sym.size != 0 {
+			break
 		}
-		return "<unknown>"
 	}
-	return ""
+	return "<unknown>"
 }
 
 // ResolveUstack display user mode stack information
 func (m *Usym) ResolveUstack(addr uint64, pid uint32) string {
 	log.Debugf("Usym ResolveUstack addr %d pid %d", addr, pid)
-	err := m.loadElfCaches(addr, pid)
+	proc, err := m.procCache(pid)
 	if err != nil {
-		log.Debugf("Usym loadElfCaches err %v", err)
+		log.Debugf("Usym load pid %d err %v", pid, err)
 		return ""
 	}
-	// search elf section
-	sec := m.searchSection(pid, addr)
-	if sec.name == "" {
-		return ""
-	}
-	// search elf symbol
-	if sec.sectiontype == elftype {
-		if _, ok := m.elfcaches[pid]; !ok {
+
+	mp := proc.searchMapping(addr)
+	if mp == nil && time.Since(proc.loadTime) > usymMapsReloadInterval {
+		m.procs.Remove(pid)
+		if proc, err = m.procCache(pid); err != nil {
 			return ""
 		}
-		log.Debugf("Usym elf type")
-		return m.searchSym(addr, m.elfcaches[pid].symcaches)
+		mp = proc.searchMapping(addr)
 	}
-	// search lib symbol
-	libpath := filepath.Join(fmt.Sprintf("/proc/%d/root", pid), sec.name)
-	baseaddr := sec.start
-	addr -= baseaddr
-	err = m.loadLibCache(libpath)
-	if err != nil {
-		log.Debugf("Usym loadLibCache err %v", err)
+
+	// the anonymous executable memory of the JIT runtimes
+	if mp == nil {
+		if name := proc.jit.lookup(addr); name != "" && name != "<unknown>" {
+			return name
+		}
 		return ""
 	}
-	if _, ok := m.libcaches[libpath]; !ok {
+
+	syms, err := m.elfSymbols(proc.root, mp)
+	if err != nil {
+		log.Debugf("Usym load %s err %v", mp.path, err)
 		return ""
 	}
-	log.Debugf("Usym lib type libpath %v", libpath)
-	return m.searchSym(addr, m.libcaches[libpath])
+
+	return searchSym(syms.vaddr(addr-mp.start+mp.offset), syms.symbols)
 }
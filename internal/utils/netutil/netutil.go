@@ -19,6 +19,7 @@ import (
 	"net"
 
 	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 var NativeEndian = nl.NativeEndian()
@@ -30,6 +31,16 @@ func InetNtop(ip uint32) net.IP {
 	return net.IPv4(buf[0], buf[1], buf[2], buf[3])
 }
 
+// InetAddr returns the IPv4 or IPv6 address by the address family, the IPv4
+// address is stored in the first 4 bytes of addr.
+func InetAddr(family uint8, addr [net.IPv6len]byte) net.IP {
+	if family == unix.AF_INET6 {
+		return net.IP(addr[:])
+	}
+
+	return net.IPv4(addr[0], addr[1], addr[2], addr[3])
+}
+
 // InetNtohs is same as the ntohs
 func InetNtohs(val uint16) uint16 {
 	buf := make([]byte, 2)
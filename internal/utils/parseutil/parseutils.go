@@ -99,3 +99,49 @@ func KV(path string) (string, uint64, error) {
 
 	return parseKV(scanner.Text())
 }
+
+// ReadCPUList read the cpu list in file, e.g. "0-3,8,10-11"
+func ReadCPUList(path string) ([]int, error) {
+	v, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCPUList(strings.TrimSpace(string(v)))
+}
+
+// ParseCPUList parse the cpu list format, e.g. "0-3,8,10-11"
+func ParseCPUList(raw string) ([]int, error) {
+	var cpus []int
+
+	if raw == "" {
+		return cpus, nil
+	}
+
+	for _, part := range strings.Split(raw, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q: %w", raw, err)
+		}
+
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q: %w", raw, err)
+			}
+		}
+
+		if end < start {
+			return nil, fmt.Errorf("invalid cpu list %q", raw)
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	return cpus, nil
+}
@@ -22,6 +22,7 @@ import (
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/pkg/tracing"
 
+	"github.com/cloudflare/backoff"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -33,10 +34,33 @@ type Collector interface {
 	Update() ([]*Data, error)
 }
 
+const (
+	// defaultScrapeTimeout is the deadline of one collector in a scrape.
+	defaultScrapeTimeout = 5 * time.Second
+	// maxStaleDuration is how long the last good snapshot can be served.
+	maxStaleDuration = 5 * time.Minute
+
+	collectorBackoffInterval = 10 * time.Second
+	collectorBackoffMax      = 10 * time.Minute
+)
+
 // CollectorWrapper adds a mutex to a Collector for thread-safe access.
 type CollectorWrapper struct {
 	collector Collector
 	mu        sync.Mutex
+
+	// the fields below are protected by stateMu
+	stateMu         sync.Mutex
+	updating        bool
+	snapshot        []prometheus.Metric
+	snapshotTime    time.Time
+	bo              *backoff.Backoff
+	nextAllowedTime time.Time
+}
+
+type collectResult struct {
+	metrics []prometheus.Metric
+	err     error
 }
 
 // CollectorManager implements the prometheus.Collector interface.
@@ -44,11 +68,16 @@ type CollectorManager struct {
 	collectors         map[string]*CollectorWrapper
 	hostname           string
 	region             string
+	scrapeTimeout      time.Duration
 	scrapeDurationDesc *prometheus.Desc
 	scrapeSuccessDesc  *prometheus.Desc
+	scrapeStaleDesc    *prometheus.Desc
 }
 
-func NewCollectorManager(blackListed []string, region string) (*CollectorManager, error) {
+// NewCollectorManager creates the manager of all the metric collectors, each
+// collector must return in the scrapeTimeout, or the last good snapshot is
+// served and marked as stale.
+func NewCollectorManager(blackListed []string, region string, scrapeTimeout time.Duration) (*CollectorManager, error) {
 	// Init defaultRegion, defaultHostname firstly,
 	// NewGaugeData may be used for data caching in tracing.NewRegister.
 	hostname, _ := os.Hostname()
@@ -59,6 +88,10 @@ func NewCollectorManager(blackListed []string, region string) (*CollectorManager
 		return nil, err
 	}
 
+	if scrapeTimeout <= 0 {
+		scrapeTimeout = defaultScrapeTimeout
+	}
+
 	collectors := make(map[string]*CollectorWrapper)
 	for key, trace := range tracings {
 		if trace.Flag&tracing.FlagMetric == 0 {
@@ -68,6 +101,7 @@ func NewCollectorManager(blackListed []string, region string) (*CollectorManager
 		collectors[key] = &CollectorWrapper{
 			collector: collector,
 			mu:        sync.Mutex{},
+			bo:        backoff.New(collectorBackoffMax, collectorBackoffInterval),
 		}
 	}
 
@@ -83,13 +117,21 @@ func NewCollectorManager(blackListed []string, region string) (*CollectorManager
 		[]string{LabelHost, LabelRegion, "collector"},
 		nil,
 	)
+	scrapeStaleDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(promNamespace, "scrape", "collector_stale"),
+		promNamespace+": Whether the metrics of a collector are the last good snapshot.",
+		[]string{LabelHost, LabelRegion, "collector"},
+		nil,
+	)
 
 	return &CollectorManager{
 		collectors:         collectors,
 		hostname:           hostname,
 		region:             region,
+		scrapeTimeout:      scrapeTimeout,
 		scrapeDurationDesc: scrapeDurationDesc,
 		scrapeSuccessDesc:  scrapeSuccessDesc,
+		scrapeStaleDesc:    scrapeStaleDesc,
 	}, nil
 }
 
@@ -97,6 +139,7 @@ func NewCollectorManager(blackListed []string, region string) (*CollectorManager
 func (m *CollectorManager) Describe(ch chan<- *prometheus.Desc) {
 	ch <- m.scrapeDurationDesc
 	ch <- m.scrapeSuccessDesc
+	ch <- m.scrapeStaleDesc
 }
 
 // Collect implements the prometheus.Collector interface.
@@ -113,39 +156,107 @@ func (m *CollectorManager) Collect(ch chan<- prometheus.Metric) {
 	wg.Wait()
 }
 
-func (m *CollectorManager) doCollect(collectorName string, c *CollectorWrapper, ch chan<- prometheus.Metric) {
-	var (
-		success float64
-		metrics []*Data
-		err     error
-	)
+// update fetches metrics from the collector, the result is sent to resultCh
+// and saved as the snapshot on success, even if the scrape has timed out.
+func (c *CollectorWrapper) update(collectorName string, resultCh chan<- collectResult) {
+	var result collectResult
 
-	begin := time.Now()
 	// only one goroutine fetches metrics from a collector at a time
 	func() {
 		c.mu.Lock()
 		defer c.mu.Unlock()
 
-		metrics, err = c.collector.Update()
+		var metrics []*Data
+		metrics, result.err = c.collector.Update()
+		if result.err != nil {
+			return
+		}
+
+		result.metrics = make([]prometheus.Metric, 0, len(metrics))
+		for _, data := range metrics {
+			result.metrics = append(result.metrics, data.prometheusMetric(collectorName))
+		}
 	}()
 
-	duration := time.Since(begin)
+	c.stateMu.Lock()
+	c.updating = false
+	switch {
+	case result.err == nil:
+		c.snapshot, c.snapshotTime = result.metrics, time.Now()
+		c.bo.Reset()
+		c.nextAllowedTime = time.Time{}
+	case !IsNoDataError(result.err):
+		c.nextAllowedTime = time.Now().Add(c.bo.Duration())
+	}
+	c.stateMu.Unlock()
 
-	if err != nil {
-		if IsNoDataError(err) {
-			log.Debugf("collector %s returned no data, duration_seconds %f: %v", collectorName, duration.Seconds(), err)
-		} else {
-			log.Infof("collector %s failed, duration_seconds %f: %v", collectorName, duration.Seconds(), err)
-		}
-		success = 0
+	resultCh <- result
+}
+
+// staleSnapshot returns the last good snapshot, nil if it is too old.
+func (c *CollectorWrapper) staleSnapshot() []prometheus.Metric {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if time.Since(c.snapshotTime) > maxStaleDuration {
+		return nil
+	}
+	return c.snapshot
+}
+
+func (m *CollectorManager) doCollect(collectorName string, c *CollectorWrapper, ch chan<- prometheus.Metric) {
+	var (
+		success float64
+		stale   float64
+		metrics []prometheus.Metric
+	)
+
+	begin := time.Now()
+
+	c.stateMu.Lock()
+	skip := c.updating || begin.Before(c.nextAllowedTime)
+	if !skip {
+		c.updating = true
+	}
+	c.stateMu.Unlock()
+
+	if skip {
+		// the last Update is still hanging, or the collector keeps failing.
+		log.Debugf("collector %s is skipped, serve the last good snapshot", collectorName)
+		metrics, stale = c.staleSnapshot(), 1
 	} else {
-		for _, data := range metrics {
-			ch <- data.prometheusMetric(collectorName)
+		resultCh := make(chan collectResult, 1)
+		go c.update(collectorName, resultCh)
+
+		timer := time.NewTimer(m.scrapeTimeout)
+		defer timer.Stop()
+
+		select {
+		case result := <-resultCh:
+			duration := time.Since(begin)
+			if result.err != nil {
+				if IsNoDataError(result.err) {
+					log.Debugf("collector %s returned no data, duration_seconds %f: %v", collectorName, duration.Seconds(), result.err)
+				} else {
+					log.Infof("collector %s failed, duration_seconds %f: %v", collectorName, duration.Seconds(), result.err)
+				}
+			} else {
+				log.Debugf("collector %s succeeded, duration_seconds %f", collectorName, duration.Seconds())
+				metrics, success = result.metrics, 1
+			}
+		case <-timer.C:
+			log.Infof("collector %s timed out after %v, serve the last good snapshot", collectorName, m.scrapeTimeout)
+			metrics, stale = c.staleSnapshot(), 1
 		}
-		log.Debugf("collector %s succeeded, duration_seconds %f", collectorName, duration.Seconds())
-		success = 1
+	}
+
+	duration := time.Since(begin)
+
+	for _, metric := range metrics {
+		ch <- metric
 	}
 
 	ch <- prometheus.MustNewConstMetric(m.scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), m.hostname, m.region, collectorName)
 	ch <- prometheus.MustNewConstMetric(m.scrapeSuccessDesc, prometheus.GaugeValue, success, m.hostname, m.region, collectorName)
+	ch <- prometheus.MustNewConstMetric(m.scrapeStaleDesc, prometheus.GaugeValue, stale, m.hostname, m.region, collectorName)
 }
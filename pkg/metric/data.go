@@ -36,6 +36,8 @@ const (
 	MetricTypeGauge = 0
 	// MetricTypeCounter indicates a counter metric.
 	MetricTypeCounter = 1
+	// MetricTypeHistogram indicates a histogram metric.
+	MetricTypeHistogram = 2
 
 	// LabelHost indicates the host.
 	LabelHost = "host"
@@ -66,6 +68,13 @@ type Data struct {
 	help       string
 	labelKey   []string
 	labelValue []string
+	histogram  *histogramData
+}
+
+type histogramData struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
 }
 
 // IsNoDataError is a function that checks whether the passed in error is the specific "NoData" error.
@@ -88,9 +97,84 @@ func IsNoDataError(err error) bool {
 //
 // NOTE: the default label `Host` will be added if it is not present in the label map.
 func NewGaugeData(name string, value float64, help string, label map[string]string) *Data {
+	return newData(name, MetricTypeGauge, value, help, label)
+}
+
+// NewCounterData creates a new instance of counter Data, the value must be
+// monotonically increasing.
+//
+// NOTE: the default label `Host` will be added if it is not present in the label map.
+func NewCounterData(name string, value float64, help string, label map[string]string) *Data {
+	return newData(name, MetricTypeCounter, value, help, label)
+}
+
+// NewHistogramData creates a new instance of histogram Data.
+//
+// Parameters:
+//
+//	count uint64 - The total number of the observations.
+//	sum float64 - The sum of all the observations.
+//	buckets map[float64]uint64 - The cumulative counts of the observations
+//	    which are less than or equal to the upper bound, the +Inf bucket is
+//	    implicit and equals to count, see CumulativeBuckets.
+//
+// NOTE: the default label `Host` will be added if it is not present in the label map.
+func NewHistogramData(name string, count uint64, sum float64, buckets map[float64]uint64, help string, label map[string]string) *Data {
+	data := newData(name, MetricTypeHistogram, 0, help, label)
+	data.histogram = &histogramData{count: count, sum: sum, buckets: buckets}
+	return data
+}
+
+// NewContainerGaugeData creates a new instance of container Data.
+//
+// NOTE: the default labels 'LabelContainerHost...' will be added if it is not present.
+// in the label map.
+func NewContainerGaugeData(container *pod.Container, name string, value float64, help string, label map[string]string) *Data {
+	return newContainerData(container, name, MetricTypeGauge, value, help, label)
+}
+
+// NewContainerCounterData creates a new instance of container counter Data.
+//
+// NOTE: the default labels 'LabelContainerHost...' will be added if it is not present.
+// in the label map.
+func NewContainerCounterData(container *pod.Container, name string, value float64, help string, label map[string]string) *Data {
+	return newContainerData(container, name, MetricTypeCounter, value, help, label)
+}
+
+// NewContainerHistogramData creates a new instance of container histogram
+// Data, the parameters are the same as NewHistogramData.
+//
+// NOTE: the default labels 'LabelContainerHost...' will be added if it is not present.
+// in the label map.
+func NewContainerHistogramData(container *pod.Container, name string, count uint64, sum float64, buckets map[float64]uint64, help string, label map[string]string) *Data {
+	data := newContainerData(container, name, MetricTypeHistogram, 0, help, label)
+	data.histogram = &histogramData{count: count, sum: sum, buckets: buckets}
+	return data
+}
+
+// CumulativeBuckets converts the counts of the latency zones to the
+// cumulative buckets of the histogram.
+//
+// The counts[i] is the number of observations in (upperBounds[i-1], upperBounds[i]],
+// and the last element of counts is the number of observations larger than the
+// last upper bound, so len(counts) must be len(upperBounds)+1.
+func CumulativeBuckets(upperBounds []float64, counts []uint64) (buckets map[float64]uint64, count uint64) {
+	buckets = make(map[float64]uint64, len(upperBounds))
+
+	for i, c := range counts {
+		count += c
+		if i < len(upperBounds) {
+			buckets[upperBounds[i]] = count
+		}
+	}
+
+	return buckets, count
+}
+
+func newData(name string, metricType int, value float64, help string, label map[string]string) *Data {
 	data := &Data{
 		metricName: name,
-		metricType: MetricTypeGauge,
+		metricType: metricType,
 		Value:      value,
 		help:       help,
 	}
@@ -98,30 +182,14 @@ func NewGaugeData(name string, value float64, help string, label map[string]stri
 	data.labelKey = append(data.labelKey, LabelRegion, LabelHost)
 	data.labelValue = append(data.labelValue, defaultRegion, defaultHostname)
 
-	// sort the labelKey
-	selfLabelKeys := make([]string, 0, len(label))
-	for k := range label {
-		selfLabelKeys = append(selfLabelKeys, k)
-	}
-	sort.Strings(selfLabelKeys)
-
-	// add self label
-	for _, k := range selfLabelKeys {
-		data.labelKey = append(data.labelKey, k)
-		data.labelValue = append(data.labelValue, label[k])
-	}
-
+	data.appendLabels(label)
 	return data
 }
 
-// NewContainerGaugeData creates a new instance of container Data.
-//
-// NOTE: the default labels 'LabelContainerHost...' will be added if it is not present.
-// in the label map.
-func NewContainerGaugeData(container *pod.Container, name string, value float64, help string, label map[string]string) *Data {
+func newContainerData(container *pod.Container, name string, metricType int, value float64, help string, label map[string]string) *Data {
 	data := &Data{
 		metricName: fmt.Sprintf("container_%s", name),
-		metricType: MetricTypeGauge,
+		metricType: metricType,
 		Value:      value,
 		help:       help,
 	}
@@ -144,6 +212,11 @@ func NewContainerGaugeData(container *pod.Container, name string, value float64,
 		container.LabelHostNamespace(),
 		defaultHostname)
 
+	data.appendLabels(label)
+	return data
+}
+
+func (d *Data) appendLabels(label map[string]string) {
 	// sort the labelKey
 	selfLabelKeys := make([]string, 0, len(label))
 	for k := range label {
@@ -153,11 +226,9 @@ func NewContainerGaugeData(container *pod.Container, name string, value float64,
 
 	// add self label
 	for _, k := range selfLabelKeys {
-		data.labelKey = append(data.labelKey, k)
-		data.labelValue = append(data.labelValue, label[k])
+		d.labelKey = append(d.labelKey, k)
+		d.labelValue = append(d.labelValue, label[k])
 	}
-
-	return data
 }
 
 // convert 'Data' to prometheus Metric
@@ -168,6 +239,7 @@ func (d *Data) prometheusMetric(collector string) prometheus.Metric {
 		valueType = prometheus.GaugeValue
 	case MetricTypeCounter:
 		valueType = prometheus.CounterValue
+	case MetricTypeHistogram:
 	default:
 		return nil
 	}
@@ -179,6 +251,16 @@ func (d *Data) prometheusMetric(collector string) prometheus.Metric {
 		metricDescCache.Store(metricName, desc)
 	}
 
+	if d.metricType == MetricTypeHistogram {
+		return prometheus.MustNewConstHistogram(
+			desc.(*prometheus.Desc),
+			d.histogram.count,
+			d.histogram.sum,
+			d.histogram.buckets,
+			d.labelValue...,
+		)
+	}
+
 	return prometheus.MustNewConstMetric(
 		desc.(*prometheus.Desc),
 		valueType,
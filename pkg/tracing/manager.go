@@ -17,9 +17,16 @@ package tracing
 import (
 	"fmt"
 	"slices"
+	"sort"
 	"sync"
+	"time"
+
+	"huatuo-bamai/internal/log"
 )
 
+// the max time waiting for a tracer to exit after it is canceled
+const stopTimeout = 10 * time.Second
+
 type MgrTracingEvent struct {
 	tracingEvents map[string]*EventTracing
 	mu            sync.Mutex
@@ -60,22 +67,23 @@ func (mgr *MgrTracingEvent) MgrTracingEventStart(name string) error {
 	}
 
 	if slices.Contains(mgr.blackListed, name) {
-		te.isRunning = false
 		return fmt.Errorf("%q blackListed", name)
 	}
 
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	if te.isRunning {
-		return fmt.Errorf("%q already running", name)
-	}
-
 	return te.Start()
 }
 
+// MgrTracingEventStopAll stops the tracers not exited, the failed and
+// disabled ones are skipped.
 func (mgr *MgrTracingEvent) MgrTracingEventStopAll() error {
-	for name := range mgr.tracingEvents {
+	for name, te := range mgr.tracingEvents {
+		if !te.active() {
+			continue
+		}
+
 		if err := mgr.MgrTracingEventStop(name); err != nil {
 			return err
 		}
@@ -91,21 +99,55 @@ func (mgr *MgrTracingEvent) MgrTracingEventStop(name string) error {
 
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
-	if !te.isRunning {
-		return fmt.Errorf("%q not running", name)
+
+	return te.Stop()
+}
+
+// MgrTracingEventRestartByConfig restarts the running, failed or unsupported
+// tracers whose config sections are changed in background, and returns the
+// names of them.
+func (mgr *MgrTracingEvent) MgrTracingEventRestartByConfig(changedKeys []string) []string {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	names := []string{}
+	for name, te := range mgr.tracingEvents {
+		if te.restartable() && te.configChanged(changedKeys) {
+			names = append(names, name)
+		}
 	}
+	sort.Strings(names)
 
-	te.Stop()
-	return nil
+	if len(names) == 0 {
+		return names
+	}
+
+	// snapshot the tracers, the lock is not held across the restarts, which
+	// may wait stopTimeout for each tracer.
+	tracers := make([]*EventTracing, 0, len(names))
+	for _, name := range names {
+		tracers = append(tracers, mgr.tracingEvents[name])
+	}
+
+	go func() {
+		for _, te := range tracers {
+			if err := te.Restart(stopTimeout); err != nil {
+				log.Errorf("restart tracing %s by config: %v", te.name, err)
+				continue
+			}
+
+			log.Infof("restart tracing %s by config", te.name)
+		}
+	}()
+
+	return names
 }
 
 // MgrTracingInfoDump gets all tracer info
 func (mgr *MgrTracingEvent) MgrTracingInfoDump() map[string]*EventTracingInfo {
 	dump := make(map[string]*EventTracingInfo)
 	for name, c := range mgr.tracingEvents {
-		mgr.mu.Lock()
 		dump[name] = c.Info()
-		mgr.mu.Unlock()
 	}
 	return dump
 }
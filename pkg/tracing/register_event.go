@@ -16,8 +16,12 @@ package tracing
 
 import (
 	"fmt"
+	"maps"
 	"slices"
 	"sync"
+
+	"huatuo-bamai/internal/capability"
+	"huatuo-bamai/internal/log"
 )
 
 const (
@@ -29,16 +33,29 @@ type EventTracingAttr struct {
 	Internal    int
 	Flag        uint32
 	TracingData any
+	// ConfigSections the config keys read by the tracer, e.g. "Tracing.Dload",
+	// the tracer is restarted when the config of them is changed.
+	ConfigSections []string
 }
 
 var (
 	factories           = make(map[string]func() (*EventTracingAttr, error))
+	requirements        = make(map[string][]capability.Requirement)
 	tracingEventAttrMap = make(map[string]*EventTracingAttr)
+	tracingSkipped      = make(map[string]string)
 	tracingOnce         sync.Once
 )
 
-func RegisterEventTracing(name string, factory func() (*EventTracingAttr, error)) {
+// RegisterEventTracing registers the tracing factory, which is skipped if
+// any of the capabilities required is not supported by the host.
+func RegisterEventTracing(name string, factory func() (*EventTracingAttr, error), requires ...capability.Requirement) {
 	factories[name] = factory
+	requirements[name] = requires
+}
+
+// SkippedTracing returns the tracings not registered, and the reasons.
+func SkippedTracing() map[string]string {
+	return maps.Clone(tracingSkipped)
 }
 
 func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
@@ -50,6 +67,13 @@ func NewRegister(blackListed []string) (map[string]*EventTracingAttr, error) {
 
 		for key, factory := range factories {
 			if slices.Contains(blackListed, key) {
+				tracingSkipped[key] = "blacklisted"
+				continue
+			}
+
+			if err := capability.Check(requirements[key]...); err != nil {
+				tracingSkipped[key] = err.Error()
+				log.Infof("skip tracing %s: %v", key, err)
 				continue
 			}
 
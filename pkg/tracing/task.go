@@ -15,12 +15,14 @@
 package tracing
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"math/big"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -54,22 +56,63 @@ const (
 	TaskStorageLocal
 )
 
+// TaskOutputText is the output format of the tools without the format argument.
+const TaskOutputText = "text"
+
+// the max size of the stderr saved in the task history.
+const maxTaskStderrSize = 4096
+
 type TaskResult struct {
 	TaskStatus Status
-	TaskData   []byte
+	TaskData   []byte // the stdout of the task
+	TaskStderr []byte
+	TaskFormat string
 	TaskErr    error
 }
 
-// task represents a unit of work to be executed.
+// the streams of the task output.
+const (
+	TaskStreamStdout = "stdout"
+	TaskStreamStderr = "stderr"
+)
+
+// TaskOutputChunk is a piece of the task output.
+type TaskOutputChunk struct {
+	Stream string
+	Data   []byte
+}
+
+// taskOutput collects the stdout and stderr of the task in order, the readers
+// waiting for more output are woken up by closing the notify channel.
+type taskOutput struct {
+	mu     sync.Mutex
+	chunks []TaskOutputChunk
+	done   bool
+	notify chan struct{}
+}
+
+type taskOutputWriter struct {
+	output *taskOutput
+	stream string
+}
+
+// task represents a unit of work to be executed, the fields updated by runTask
+// are guarded by mu.
 type task struct {
+	mu           sync.Mutex
 	id           string             // Unique identifier for the task.
+	name         string             // Name of the tool in the task catalogue.
 	execBinary   string             // Path to the executable file to run for this task.
 	execArgs     []string           // Arguments to pass to the executable.
 	stdoutData   []byte             // Data generated by the task.
+	stderrData   []byte             // Stderr of the task, kept apart from the data.
+	output       *taskOutput        // Output of the task while running.
 	status       Status             // Current status of the task.
 	error        error              // Error encountered during task execution.
 	storage      TaskStorageType    // Type of data produced by the task.
+	outputFormat string             // Format of the data produced by the task.
 	cancelFunc   context.CancelFunc // Function to cancel the task.
+	createdTime  time.Time          // Time when the task is created.
 	deadlineTime time.Time          // Time after which the task will be automatically deleted.
 }
 
@@ -81,6 +124,8 @@ var (
 	ErrTaskTimeout = errors.New("task timeout")
 	// ErrTaskCanceled Error returned when a task is canceled.
 	ErrTaskCanceled = errors.New("task canceled")
+	// ErrTaskInterrupted Error returned when the agent restarts while the task is running.
+	ErrTaskInterrupted = errors.New("task interrupted")
 )
 
 func init() {
@@ -95,11 +140,14 @@ func tasksGarbageCollect() {
 		now := time.Now()
 		taskLifeTmpCache.Range(func(key, value any) bool {
 			task := value.(*task)
-			if task.status == StatusCompleted || task.status == StatusFailed {
-				if now.After(task.deadlineTime) {
-					log.Infof("task %s deleted by timeout", key)
-					taskLifeTmpCache.Delete(key)
-				}
+
+			task.mu.Lock()
+			expired := task.finished() && now.After(task.deadlineTime)
+			task.mu.Unlock()
+
+			if expired {
+				log.Infof("task %s deleted by timeout", key)
+				taskLifeTmpCache.Delete(key)
 			}
 			return true
 		})
@@ -123,63 +171,169 @@ func allocTaskID() string {
 	return string(result)
 }
 
-// NewTask creates a new task, allocates an ID, and starts it.
-func NewTask(execBinary string, timeout time.Duration, storageType TaskStorageType, execArgs []string) string {
+// NewTask creates a new task of the tool in the task catalogue, validates the
+// arguments, allocates an ID, and starts it. The default timeout of the tool
+// is used if the timeout is 0.
+func NewTask(toolName string, timeout time.Duration, storageType TaskStorageType, args map[string]any) (string, error) {
+	tool, err := LookupTaskTool(toolName)
+	if err != nil {
+		return "", err
+	}
+
+	execArgs, format, err := tool.BuildArgs(args)
+	if err != nil {
+		return "", err
+	}
+
+	timeout, err = tool.Timeout(args, timeout)
+	if err != nil {
+		return "", err
+	}
+
 	taskID := allocTaskID()
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	task := &task{
-		id:         taskID,
-		status:     StatusPending,
-		cancelFunc: cancel,
-		execBinary: execBinary,
-		storage:    storageType,
-		execArgs:   execArgs,
+		id:           taskID,
+		name:         tool.Name,
+		status:       StatusPending,
+		cancelFunc:   cancel,
+		execBinary:   filepath.Join(TaskBinDir, tool.Binary),
+		storage:      storageType,
+		execArgs:     execArgs,
+		outputFormat: format,
+		output:       newTaskOutput(),
+		createdTime:  time.Now(),
 	}
 	taskLifeTmpCache.Store(taskID, task)
+	saveTaskRecord(task, nil)
 
 	go runTask(ctx, task)
 
-	return taskID
+	return taskID, nil
 }
 
 func runTask(ctx context.Context, task *task) {
-	defer func() {
-		setDeadlineDefault(task)
-	}()
+	// wake up the streams after the status is updated.
+	defer task.output.close()
 
+	task.mu.Lock()
 	task.status = StatusRunning
+	task.mu.Unlock()
+
+	saveTaskRecord(task, nil)
 	log.Infof("task %s %s started", task.execBinary, task.id)
 
 	cmd := exec.CommandContext(ctx, task.execBinary, task.execArgs...)
-	output, err := cmd.CombinedOutput()
+	cmd.Stdout = &taskOutputWriter{output: task.output, stream: TaskStreamStdout}
+	cmd.Stderr = &taskOutputWriter{output: task.output, stream: TaskStreamStderr}
+	err := cmd.Run()
+
+	// the stdout is the result, e.g. pprof in gzip, the stderr is never
+	// mixed into it.
+	output := task.output.bytes(TaskStreamStdout)
+	stderr := task.output.bytes(TaskStreamStderr)
 	if err != nil {
-		task.status = StatusFailed
+		var taskErr error
 		contextErr := ctx.Err()
 		if errors.Is(contextErr, context.DeadlineExceeded) {
-			task.error = ErrTaskTimeout
+			taskErr = ErrTaskTimeout
 		} else if errors.Is(contextErr, context.Canceled) {
-			task.error = ErrTaskCanceled
+			taskErr = ErrTaskCanceled
 		} else {
-			task.error = fmt.Errorf("task error: %s| cmd error: %s", err.Error(), string(output))
+			taskErr = fmt.Errorf("task error: %s| cmd error: %s", err.Error(), string(stderr))
 		}
-		log.Infof("task %s %s failed: %s", task.execBinary, task.id, task.error.Error())
+		task.finish(StatusFailed, taskErr, nil, stderr)
+		saveTaskRecord(task, nil)
+		log.Infof("task %s %s failed: %s", task.execBinary, task.id, taskErr.Error())
 		return
 	}
 
+	var stdout []byte
 	switch task.storage {
 	case TaskStorageDB:
-		storage.SaveTaskOutput(task.execBinary, task.id, "", time.Now(), string(output))
+		storage.SaveTaskOutput(task.name, task.id, "", time.Now(), task.outputFormat, output)
 	case TaskStorageStdout:
-		task.stdoutData = output
+		stdout = output
 	case TaskStorageLocal:
 	default:
 		log.Warn("not supported")
 	}
 
-	task.status = StatusCompleted
+	if len(stderr) != 0 {
+		log.Infof("task %s stderr: %s", task.id, stderr)
+	}
+
+	task.finish(StatusCompleted, nil, stdout, stderr)
+	saveTaskRecord(task, stdout)
 	log.Infof("task %s completed: %s", task.id, fmt.Sprint(task.execBinary, task.execArgs))
 }
 
+// finish sets the result of the task, the deadline is set at the same time,
+// so the finished task is never deleted before its record is saved.
+func (t *task) finish(status Status, err error, stdout, stderr []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status = status
+	t.error = err
+	t.stdoutData = stdout
+	t.stderrData = stderr
+	setDeadlineDefault(t)
+}
+
+// finished reports whether the task is completed or failed, t.mu is held.
+func (t *task) finished() bool {
+	return t.status == StatusCompleted || t.status == StatusFailed
+}
+
+// result returns the snapshot of the task result.
+func (t *task) result() *TaskResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &TaskResult{
+		TaskData:   t.stdoutData,
+		TaskStderr: t.stderrData,
+		TaskFormat: t.outputFormat,
+		TaskStatus: t.status,
+		TaskErr:    t.error,
+	}
+}
+
+// saveTaskRecord saves the task into the task history, the output is only
+// saved when the task completed.
+func saveTaskRecord(task *task, output []byte) {
+	result := task.result()
+	record := &storage.TaskRecord{
+		ID:          task.id,
+		Name:        task.name,
+		Args:        task.execArgs,
+		Status:      string(result.TaskStatus),
+		Format:      task.outputFormat,
+		OutputSize:  len(output),
+		CreatedTime: task.createdTime,
+	}
+
+	if result.TaskErr != nil {
+		record.Error = result.TaskErr.Error()
+	}
+
+	// the tail of the stderr, which is more likely the reason.
+	stderr := result.TaskStderr
+	if len(stderr) > maxTaskStderrSize {
+		stderr = stderr[len(stderr)-maxTaskStderrSize:]
+	}
+	record.Stderr = string(stderr)
+
+	if result.TaskStatus == StatusCompleted || result.TaskStatus == StatusFailed {
+		now := time.Now()
+		record.FinishedTime = &now
+	}
+
+	storage.SaveTaskRecord(record, output)
+}
+
+// setDeadlineDefault sets the time to delete the task, task.mu is held.
 func setDeadlineDefault(task *task) {
 	task.deadlineTime = time.Now().Add(10 * time.Minute)
 }
@@ -188,8 +342,7 @@ func setDeadlineDefault(task *task) {
 func RunningTaskCount() int {
 	count := 0
 	taskLifeTmpCache.Range(func(key, value any) bool {
-		task := value.(*task)
-		if task.status == StatusRunning {
+		if value.(*task).result().TaskStatus == StatusRunning {
 			count++
 		}
 		return true
@@ -197,9 +350,28 @@ func RunningTaskCount() int {
 	return count
 }
 
-// Result returns the result of a task given its ID.
+// Result returns the result of a task given its ID, the finished tasks are
+// loaded from the task history.
 func Result(taskID string) *TaskResult {
 	taskInterface, ok := taskLifeTmpCache.Load(taskID)
+	if !ok {
+		return historyResult(taskID)
+	}
+
+	task := taskInterface.(*task)
+
+	// the finished task is kept as it is still read.
+	task.mu.Lock()
+	if task.finished() {
+		setDeadlineDefault(task)
+	}
+	task.mu.Unlock()
+
+	return task.result()
+}
+
+func historyResult(taskID string) *TaskResult {
+	record, ok := storage.TaskRecordByID(taskID)
 	if !ok {
 		return &TaskResult{
 			TaskStatus: StatusNotExist,
@@ -207,15 +379,119 @@ func Result(taskID string) *TaskResult {
 		}
 	}
 
-	task := taskInterface.(*task)
-	if task.status == StatusFailed || task.status == StatusCompleted {
-		setDeadlineDefault(task)
+	historyRecordStatus(record)
+	result := &TaskResult{
+		TaskStatus: Status(record.Status),
+		TaskStderr: []byte(record.Stderr),
+		TaskFormat: record.Format,
 	}
-	return &TaskResult{
-		TaskData:   task.stdoutData,
-		TaskStatus: task.status,
-		TaskErr:    task.error,
+
+	switch result.TaskStatus {
+	case StatusCompleted:
+		if record.OutputSize > 0 {
+			data, err := storage.TaskRecordOutput(taskID)
+			if err != nil {
+				log.Infof("task %s output: %v", taskID, err)
+			}
+			result.TaskData = data
+		}
+	case StatusFailed:
+		result.TaskErr = errors.New(record.Error)
+	}
+
+	return result
+}
+
+// historyRecordStatus marks the task failed, which is not finished in the
+// history but not running in this agent, it was interrupted by the restart.
+func historyRecordStatus(record *storage.TaskRecord) {
+	if record.Status != StatusPending && record.Status != StatusRunning {
+		return
 	}
+
+	if _, ok := taskLifeTmpCache.Load(record.ID); ok {
+		return
+	}
+
+	record.Status = StatusFailed
+	record.Error = ErrTaskInterrupted.Error()
+}
+
+// TaskListQuery filters the tasks in the task history.
+type TaskListQuery struct {
+	Name   string
+	Status Status
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ListTasks returns the tasks in the task history, the newest first.
+func ListTasks(q *TaskListQuery) []*storage.TaskRecord {
+	records := storage.QueryTaskRecords(&storage.TaskQuery{
+		Name:  q.Name,
+		Since: q.Since,
+		Until: q.Until,
+	})
+
+	// filter the status after the interrupted tasks are marked.
+	result := []*storage.TaskRecord{}
+	for _, record := range records {
+		historyRecordStatus(record)
+		if q.Status != "" && Status(record.Status) != q.Status {
+			continue
+		}
+
+		result = append(result, record)
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+	}
+
+	return result
+}
+
+// StreamTask calls fn with the output chunks of the task from the start index,
+// until the task finished or the ctx is done, and then returns the result. The
+// tasks in the task history replay the saved output as one chunk.
+func StreamTask(ctx context.Context, taskID string, start int, fn func(index int, chunk *TaskOutputChunk) error) (*TaskResult, error) {
+	taskInterface, ok := taskLifeTmpCache.Load(taskID)
+	if !ok {
+		result := historyResult(taskID)
+		if result.TaskStatus == StatusNotExist {
+			return nil, ErrTaskNotFound
+		}
+
+		if start == 0 && len(result.TaskData) > 0 {
+			if err := fn(0, &TaskOutputChunk{Stream: TaskStreamStdout, Data: result.TaskData}); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	task := taskInterface.(*task)
+	for index := start; ; {
+		chunks, done, notify := task.output.next(index)
+		for i := range chunks {
+			if err := fn(index, &chunks[i]); err != nil {
+				return nil, err
+			}
+			index++
+		}
+
+		if done {
+			break
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return Result(taskID), nil
 }
 
 // StopTask stops a running task given its ID.
@@ -226,10 +502,62 @@ func StopTask(taskID string) error {
 	}
 
 	task := taskAny.(*task)
-	if task.status == StatusRunning {
+	if task.result().TaskStatus == StatusRunning {
 		task.cancelFunc()
 	}
 	taskLifeTmpCache.Delete(taskID)
 	log.Infof("task %s stoped", task.id)
 	return nil
 }
+
+func newTaskOutput() *taskOutput {
+	return &taskOutput{notify: make(chan struct{})}
+}
+
+func (w *taskOutputWriter) Write(p []byte) (int, error) {
+	w.output.write(w.stream, p)
+	return len(p), nil
+}
+
+func (o *taskOutput) write(stream string, p []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.chunks = append(o.chunks, TaskOutputChunk{Stream: stream, Data: bytes.Clone(p)})
+	close(o.notify)
+	o.notify = make(chan struct{})
+}
+
+func (o *taskOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.done = true
+	close(o.notify)
+}
+
+// next returns the chunks from the index, whether the output is closed, and
+// the channel closed on the next write.
+func (o *taskOutput) next(index int) (chunks []TaskOutputChunk, done bool, notify <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if index < len(o.chunks) {
+		chunks = o.chunks[index:]
+	}
+	return chunks, o.done, o.notify
+}
+
+// bytes returns the output of the stream.
+func (o *taskOutput) bytes(stream string) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range o.chunks {
+		if c.Stream == stream {
+			buf.Write(c.Data)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,295 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskArgType is the type of the tool argument.
+type TaskArgType string
+
+const (
+	TaskArgString TaskArgType = "string"
+	TaskArgInt    TaskArgType = "int"
+	TaskArgBool   TaskArgType = "bool"
+)
+
+// the time for the tool to start and write the output, besides the duration.
+const taskTimeoutMargin = 30 * time.Second
+
+// TaskArg declares an argument of the tool, passed as --<name>=<value>.
+type TaskArg struct {
+	Name        string      `json:"name"`
+	Type        TaskArgType `json:"type"`
+	Description string      `json:"description"`
+	Default     any         `json:"default,omitempty"`
+	// Enum is the allowed values of the string argument, any if empty.
+	Enum []string `json:"enum,omitempty"`
+	// Min and Max are the range of the int argument.
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
+}
+
+// TaskTool is an on-demand tool in the task catalogue.
+type TaskTool struct {
+	Name        string
+	Description string
+	// Binary is the executable in TaskBinDir.
+	Binary string
+	// DefaultTimeout is used when the task has no timeout.
+	DefaultTimeout time.Duration
+	// DurationArg is the argument of the running seconds, if any, the task
+	// has no timeout runs for the duration plus taskTimeoutMargin at least.
+	DurationArg string
+	// Format is the output format, or the default of the FormatArg.
+	Format string
+	// FormatArg is the argument to select the output format, if any.
+	FormatArg string
+	Args      []TaskArg
+}
+
+var (
+	taskCatalog = make(map[string]*TaskTool)
+
+	// ErrTaskToolNotFound Error returned when the tool is not in the task catalogue.
+	ErrTaskToolNotFound = errors.New("task tool not found")
+	// ErrTaskInvalidArgs Error returned when the arguments mismatch the schema of the tool.
+	ErrTaskInvalidArgs = errors.New("invalid task arguments")
+)
+
+// RegisterTaskTool registers the tool into the task catalogue, it should be
+// called in init().
+func RegisterTaskTool(tool *TaskTool) {
+	if _, ok := taskCatalog[tool.Name]; ok {
+		panic(fmt.Sprintf("task tool %s is already registered", tool.Name))
+	}
+
+	if arg := tool.arg(tool.FormatArg); arg != nil {
+		if format, ok := arg.Default.(string); ok {
+			tool.Format = format
+		}
+	}
+	if tool.Format == "" {
+		tool.Format = TaskOutputText
+	}
+	taskCatalog[tool.Name] = tool
+}
+
+// TaskCatalog returns the tools in the task catalogue, sorted by the name.
+func TaskCatalog() []*TaskTool {
+	names := slices.Sorted(maps.Keys(taskCatalog))
+
+	tools := make([]*TaskTool, 0, len(names))
+	for _, name := range names {
+		tools = append(tools, taskCatalog[name])
+	}
+	return tools
+}
+
+// LookupTaskTool returns the tool in the task catalogue.
+func LookupTaskTool(name string) (*TaskTool, error) {
+	tool, ok := taskCatalog[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTaskToolNotFound, name)
+	}
+	return tool, nil
+}
+
+func (t *TaskTool) arg(name string) *TaskArg {
+	for i := range t.Args {
+		if t.Args[i].Name == name {
+			return &t.Args[i]
+		}
+	}
+	return nil
+}
+
+// ParseArgs parses the raw arguments, --<name>=<value> or --<name> <value>,
+// into the values of the BuildArgs.
+func (t *TaskTool) ParseArgs(raw []string) (map[string]any, error) {
+	values := make(map[string]any)
+
+	for i := 0; i < len(raw); i++ {
+		name, ok := strings.CutPrefix(raw[i], "--")
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected %q", ErrTaskInvalidArgs, raw[i])
+		}
+
+		name, value, hasValue := strings.Cut(name, "=")
+		arg := t.arg(name)
+		if arg == nil {
+			return nil, fmt.Errorf("%w: unknown argument %q of %s", ErrTaskInvalidArgs, name, t.Name)
+		}
+
+		if !hasValue {
+			if arg.Type == TaskArgBool {
+				value = "true"
+			} else if i+1 < len(raw) {
+				i++
+				value = raw[i]
+			} else {
+				return nil, fmt.Errorf("%w: missing the value of %q", ErrTaskInvalidArgs, name)
+			}
+		}
+
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// BuildArgs validates the values by the schema of the tool, and returns the
+// command line arguments and the output format. The values are the JSON
+// values, or the strings of the ParseArgs.
+func (t *TaskTool) BuildArgs(values map[string]any) (args []string, format string, err error) {
+	for name := range values {
+		if t.arg(name) == nil {
+			return nil, "", fmt.Errorf("%w: unknown argument %q of %s", ErrTaskInvalidArgs, name, t.Name)
+		}
+	}
+
+	format = t.Format
+	for i := range t.Args {
+		arg := &t.Args[i]
+
+		v, ok := values[arg.Name]
+		if !ok {
+			continue
+		}
+
+		value, err := arg.value(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %s: %w", ErrTaskInvalidArgs, arg.Name, err)
+		}
+
+		if arg.Name == t.FormatArg {
+			format = value
+		}
+		args = append(args, "--"+arg.Name+"="+value)
+	}
+
+	return args, format, nil
+}
+
+// value returns the validated value in the command line.
+func (a *TaskArg) value(v any) (string, error) {
+	switch a.Type {
+	case TaskArgString:
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a string")
+		}
+		if len(a.Enum) != 0 && !slices.Contains(a.Enum, s) {
+			return "", fmt.Errorf("must be one of %s", strings.Join(a.Enum, ", "))
+		}
+		return s, nil
+	case TaskArgInt:
+		n, err := intValue(v)
+		if err != nil {
+			return "", err
+		}
+		if (a.Min != nil && n < *a.Min) || (a.Max != nil && n > *a.Max) {
+			return "", fmt.Errorf("out of range [%s, %s]", rangeBound(a.Min, "-inf"), rangeBound(a.Max, "inf"))
+		}
+		return strconv.FormatInt(n, 10), nil
+	case TaskArgBool:
+		switch b := v.(type) {
+		case bool:
+			return strconv.FormatBool(b), nil
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return "", fmt.Errorf("must be a bool")
+			}
+			return strconv.FormatBool(parsed), nil
+		}
+		return "", fmt.Errorf("must be a bool")
+	default:
+		return "", fmt.Errorf("unknown type %q", a.Type)
+	}
+}
+
+func intValue(v any) (int64, error) {
+	switch n := v.(type) {
+	case float64:
+		if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+			return 0, fmt.Errorf("must be an integer")
+		}
+		return int64(n), nil
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case string:
+		parsed, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("must be an integer")
+		}
+		return parsed, nil
+	default:
+		return 0, fmt.Errorf("must be an integer")
+	}
+}
+
+// Timeout returns the timeout of the task by the validated values of the
+// BuildArgs, the explicit timeout shorter than the duration plus
+// taskTimeoutMargin is rejected.
+func (t *TaskTool) Timeout(values map[string]any, timeout time.Duration) (time.Duration, error) {
+	var duration time.Duration
+	if arg := t.arg(t.DurationArg); arg != nil {
+		v, ok := values[arg.Name]
+		if !ok {
+			v = arg.Default
+		}
+
+		if v != nil {
+			n, err := intValue(v)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %s: %w", ErrTaskInvalidArgs, arg.Name, err)
+			}
+			duration = time.Duration(n) * time.Second
+		}
+	}
+
+	if timeout != 0 {
+		if minTimeout := duration + taskTimeoutMargin; duration > 0 && timeout < minTimeout {
+			return 0, fmt.Errorf("%w: timeout %s is shorter than the %s %s plus %s, the minimum is %s",
+				ErrTaskInvalidArgs, timeout, t.DurationArg, duration, taskTimeoutMargin, minTimeout)
+		}
+		return timeout, nil
+	}
+
+	return max(t.DefaultTimeout, duration+taskTimeoutMargin), nil
+}
+
+// taskArgBound returns the Min or Max of the TaskArg.
+func taskArgBound(n int64) *int64 {
+	return &n
+}
+
+func rangeBound(n *int64, unlimited string) string {
+	if n == nil {
+		return unlimited
+	}
+	return strconv.FormatInt(*n, 10)
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 The HuaTuo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import "time"
+
+func init() {
+	RegisterTaskTool(&TaskTool{
+		Name:           "perf",
+		Description:    "Profile the cpu of the host, container or process, on-cpu or off-cpu",
+		Binary:         "perf",
+		DefaultTimeout: 60 * time.Second,
+		DurationArg:    "duration",
+		FormatArg:      "format",
+		Args: []TaskArg{
+			{Name: "container-id", Type: TaskArgString, Description: "Container's ID, the host if empty"},
+			{Name: "pid", Type: TaskArgInt, Description: "Task pid number", Min: taskArgBound(0)},
+			{
+				Name: "duration", Type: TaskArgInt, Description: "Tool duration(s)", Default: 5,
+				Min: taskArgBound(1), Max: taskArgBound(3600),
+			},
+			{
+				Name: "mode", Type: TaskArgString, Description: "Profiling mode", Default: "oncpu",
+				Enum: []string{"oncpu", "offcpu"},
+			},
+			{
+				Name: "freq", Type: TaskArgInt, Description: "Sample frequency(Hz) of the oncpu mode", Default: 99,
+				Min: taskArgBound(1), Max: taskArgBound(10000),
+			},
+			{
+				Name: "period", Type: TaskArgInt, Description: "Sample period(ns) of the cpu clock of the oncpu mode, overrides the freq if set",
+				Default: 0, Min: taskArgBound(0),
+			},
+			{
+				Name: "stack-depth", Type: TaskArgInt, Description: "Max depth of the user and kernel stacks, up to kernel.perf_event_max_stack",
+				Default: 64, Min: taskArgBound(1), Max: taskArgBound(1024),
+			},
+			{
+				Name: "stack", Type: TaskArgString, Description: "Stacks to capture", Default: "all",
+				Enum: []string{"all", "user", "kernel"},
+			},
+			{
+				Name: "format", Type: TaskArgString, Description: "Output format", Default: "flamegraph-json",
+				Enum: []string{"flamegraph-json", "folded", "pprof"},
+			},
+		},
+	})
+}
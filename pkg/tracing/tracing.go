@@ -17,22 +17,59 @@ package tracing
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"huatuo-bamai/internal/log"
 	"huatuo-bamai/pkg/types"
 )
 
+// TracingState is the state of a tracing in the supervisor
+type TracingState string
+
+const (
+	// StateStarting the tracing is started, and not run yet
+	StateStarting TracingState = "starting"
+	// StateRunning the tracing is running, or waits the interval for the
+	// next run after a successful one
+	StateRunning TracingState = "running"
+	// StateBackoff the tracing failed, and waits to be restarted
+	StateBackoff TracingState = "backoff"
+	// StateFailed the tracing failed too many times, and is given up
+	StateFailed TracingState = "failed"
+	// StateDisabled the tracing is stopped, blacklisted or not supported
+	StateDisabled TracingState = "disabled"
+)
+
+const (
+	// the consecutive failures the tracing is given up after
+	maxFailures = 5
+	// the max delay of restarting a failed tracing, and a run longer than
+	// it resets the consecutive failures
+	maxBackoff = 5 * time.Minute
+)
+
 // EventTracing represents a tracing
 type EventTracing struct {
-	ic        ITracingEvent
-	name      string
-	interval  int
-	hitCount  int
-	cancelCtx context.CancelFunc
-	exit      bool
-	isRunning bool
-	flag      uint32
+	ic       ITracingEvent
+	name     string
+	interval int
+	flag     uint32
+	sections []string
+
+	// protect the states below, which are updated by the supervisor goroutine
+	mu            sync.Mutex
+	state         TracingState
+	hitCount      int
+	restarts      int
+	failures      int
+	lastError     string
+	lastStartTime time.Time
+	unsupported   bool
+	cancel        context.CancelFunc
+	done          chan struct{}
 }
 
 // ITracingEvent represents a tracing/event
@@ -47,75 +84,238 @@ func NewTracingEvent(tracing *EventTracingAttr, name string) *EventTracing {
 		name:     name,
 		interval: tracing.Internal,
 		flag:     tracing.Flag,
+		sections: tracing.ConfigSections,
+		state:    StateDisabled,
 	}
 }
 
-// Start do work
+// Start starts the supervisor goroutine of tracing, which runs the tracing
+// again after the interval when it exits, or after the backoff delay when it
+// fails. The tracing can't be started until the last goroutine exited.
 func (c *EventTracing) Start() error {
-	c.isRunning = true
-	c.exit = false
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	go func() {
-		for !c.exit {
-			c.doStart()
+	if c.cancel != nil {
+		return fmt.Errorf("%q already running", c.name)
+	}
 
-			c.hitCount++
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	c.state = StateStarting
+	c.failures = 0
+	c.unsupported = false
 
-			if c.exit {
-				break
-			}
+	go c.supervise(ctx, c.done)
 
-			time.Sleep(time.Duration(c.interval) * time.Second)
+	log.Infof("start tracing %s", c.name)
+	return nil
+}
+
+func (c *EventTracing) supervise(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		start := time.Now()
+		err := c.run(ctx)
+
+		delay, restart := c.exited(ctx, err, time.Since(start))
+		if !restart {
+			break
 		}
 
-		c.isRunning = false
-		log.Infof("%s: tracing goroutine exited", c.name)
-	}()
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
 
-	log.Infof("start tracing %s", c.name)
+		if ctx.Err() != nil {
+			break
+		}
+
+		c.mu.Lock()
+		if c.state == StateBackoff {
+			c.restarts++
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	c.cancel()
+	c.cancel = nil
+	if c.state != StateFailed {
+		c.state = StateDisabled
+	}
+	c.mu.Unlock()
+
+	log.Infof("%s: tracing goroutine exited", c.name)
+}
+
+func (c *EventTracing) run(ctx context.Context) error {
+	c.mu.Lock()
+	c.state = StateRunning
+	c.lastStartTime = time.Now()
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return c.ic.Start(ctx)
+}
+
+// exited updates the states after the tracing exits, and returns the delay
+// of the next run, or false if the tracing should not be run again.
+func (c *EventTracing) exited(ctx context.Context, err error, elapsed time.Duration) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hitCount++
+	interval := time.Duration(c.interval) * time.Second
+
+	switch {
+	case ctx.Err() != nil:
+		return 0, false
+	case err == nil ||
+		errors.Is(err, types.ErrExitByCancelCtx) ||
+		errors.Is(err, types.ErrDisconnectedHuatuo):
+		c.failures = 0
+		return interval, true
+	case errors.Is(err, types.ErrNotSupported):
+		c.lastError = err.Error()
+		c.unsupported = true
+		return 0, false
+	}
+
+	if elapsed >= maxBackoff {
+		c.failures = 0
+	}
+
+	c.failures++
+	c.lastError = err.Error()
+
+	if c.failures >= maxFailures {
+		c.state = StateFailed
+		log.Errorf("start tracing %s: %v, give up after %d failures", c.name, err, c.failures)
+		return 0, false
+	}
+
+	delay := backoffDelay(interval, c.failures)
+	c.state = StateBackoff
+	log.Errorf("start tracing %s: %v, restart in %s", c.name, err, delay)
+	return delay, true
+}
+
+// backoffDelay doubles the interval for each consecutive failure.
+func backoffDelay(interval time.Duration, failures int) time.Duration {
+	delay := max(interval, time.Second)
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
+
+// Stop cancels the tracing, and the supervisor goroutine exits in background.
+func (c *EventTracing) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cancel == nil {
+		return fmt.Errorf("%q not running", c.name)
+	}
+
+	c.cancel()
 	return nil
 }
 
-func (c *EventTracing) doStart() {
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancelCtx = cancel
-	defer c.cancelCtx()
-
-	if err := c.ic.Start(ctx); err != nil {
-		if !(errors.Is(err, types.ErrExitByCancelCtx) ||
-			errors.Is(err, types.ErrDisconnectedHuatuo) ||
-			errors.Is(err, types.ErrNotSupported)) {
-			log.Errorf("start tracing %s: %v", c.name, err)
-		}
+// Restart stops the tracing, and starts it again after the supervisor
+// goroutine exited, the new config is read in Start.
+func (c *EventTracing) Restart(timeout time.Duration) error {
+	c.mu.Lock()
+	done := c.done
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
 
-		if errors.Is(err, types.ErrNotSupported) {
-			c.exit = true
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			return fmt.Errorf("stop tracing %s timeout", c.name)
 		}
 	}
+
+	return c.Start()
+}
+
+// active returns true if the supervisor goroutine is not exited.
+func (c *EventTracing) active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cancel != nil
+}
+
+// restartable returns true if the tracing is active, gave up, or not
+// supported by the config, e.g. the server is not configured.
+func (c *EventTracing) restartable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cancel != nil || c.state == StateFailed || c.unsupported
 }
 
-// Stop stop tracing
-func (c *EventTracing) Stop() {
-	c.exit = true
-	c.cancelCtx()
+// configChanged checks whether the config sections of tracing are changed.
+func (c *EventTracing) configChanged(changedKeys []string) bool {
+	for _, key := range changedKeys {
+		for _, section := range c.sections {
+			if key == section || strings.HasPrefix(key, section+".") {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // EventTracingInfo represents tracing information
 type EventTracingInfo struct {
-	Name     string `json:"name"`
-	Running  bool   `json:"running"`
-	HitCount int    `json:"hit"`
-	Interval int    `json:"restart_interval"`
-	Flag     uint32 `json:"flag"`
+	Name     string       `json:"name"`
+	State    TracingState `json:"state"`
+	Running  bool         `json:"running"`
+	HitCount int          `json:"hit"`
+	// Restarts the times of restarting after failures
+	Restarts      int        `json:"restarts"`
+	Failures      int        `json:"failures"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastStartTime *time.Time `json:"last_start_time,omitempty"`
+	Interval      int        `json:"restart_interval"`
+	Flag          uint32     `json:"flag"`
 }
 
 // Info return tracing's base information
 func (c *EventTracing) Info() *EventTracingInfo {
-	return &EventTracingInfo{
-		Name:     c.name,
-		Running:  c.isRunning,
-		HitCount: c.hitCount,
-		Interval: c.interval,
-		Flag:     c.flag,
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info := &EventTracingInfo{
+		Name:      c.name,
+		State:     c.state,
+		Running:   c.state == StateRunning,
+		HitCount:  c.hitCount,
+		Restarts:  c.restarts,
+		Failures:  c.failures,
+		LastError: c.lastError,
+		Interval:  c.interval,
+		Flag:      c.flag,
+	}
+
+	if !c.lastStartTime.IsZero() {
+		lastStartTime := c.lastStartTime
+		info.LastStartTime = &lastStartTime
 	}
+
+	return info
 }